@@ -5,8 +5,11 @@ go 1.25
 require (
 	github.com/alecthomas/kong v1.13.0
 	github.com/cenkalti/backoff/v4 v4.3.0
+	github.com/klauspost/compress v1.18.0
+	github.com/natefinch/lumberjack v2.0.0+incompatible
 	github.com/sirupsen/logrus v1.9.3
 	github.com/zalando/go-keyring v0.2.6
+	golang.org/x/sys v0.39.0
 	golang.org/x/term v0.38.0
 )
 
@@ -14,6 +17,4 @@ require (
 	al.essio.dev/pkg/shellescape v1.5.1 // indirect
 	github.com/danieljoos/wincred v1.2.2 // indirect
 	github.com/godbus/dbus/v5 v5.1.0 // indirect
-	github.com/natefinch/lumberjack v2.0.0+incompatible // indirect
-	golang.org/x/sys v0.39.0 // indirect
 )
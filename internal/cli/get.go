@@ -10,102 +10,103 @@ import (
 	"github.com/alapierre/itrust-updater/internal/support"
 	"github.com/alapierre/itrust-updater/pkg/backend"
 	"github.com/alapierre/itrust-updater/pkg/install"
-	"github.com/alapierre/itrust-updater/pkg/secrets"
-	"github.com/zalando/go-keyring"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/progress"
 )
 
 type GetCmd struct {
-	Profile   string `arg:"" help:"Profile name."`
-	Version   string `help:"Specific version to install (v1 supports 'latest' only via channel manifest)."`
-	Dest      string `help:"Override destination path."`
-	Os        string `default:"${default_os}" help:"Override operating system."`
-	Arch      string `default:"${default_arch}" help:"Override architecture."`
-	ConfigDir string `help:"Override configuration directory."`
-	StateDir  string `help:"Override state directory."`
-	Force     bool   `help:"Force download and installation."`
+	Profile        string `arg:"" help:"Profile name."`
+	Version        string `help:"Specific version to install (v1 supports 'latest' only via channel manifest)."`
+	Dest           string `help:"Override destination path."`
+	Os             string `default:"${default_os}" help:"Override operating system."`
+	Arch           string `default:"${default_arch}" help:"Override architecture."`
+	ConfigDir      string `help:"Override configuration directory."`
+	StateDir       string `help:"Override state directory."`
+	Force          bool   `help:"Force download and installation."`
+	LimitRate      string `help:"Limit download rate in bytes per second (e.g. 500K, 2M)."`
+	AllowDowngrade bool   `help:"Allow installing a lower version or an older manifest than previously installed."`
 }
 
 func (c *GetCmd) Run(g *Globals) error {
-	return handleGet(context.Background(), c.Profile, c.Version, c.Dest, c.Os, c.Arch, c.ConfigDir, c.StateDir, c.Force, g.NonInteractive, g.UseKeyring)
+	return handleGet(context.Background(), c.Profile, c.Version, c.Dest, c.Os, c.Arch, c.ConfigDir, c.StateDir, c.LimitRate, c.Force, c.AllowDowngrade, g.NonInteractive, g.UseKeyring)
 }
 
-func handleGet(ctx context.Context, profile, version, destOverride, goos, goarch, customConfigDir, customStateDir string, force, nonInteractive, useKeyring bool) error {
+func handleGet(ctx context.Context, profile, version, destOverride, goos, goarch, customConfigDir, customStateDir, limitRate string, force, allowDowngrade, nonInteractive, useKeyring bool) error {
 	configDir, stateDir := support.GetPaths(customConfigDir, customStateDir)
 	logger.Infof("Starting get for profile %s, version %s", profile, version)
 	logger.Debugf("Config dir: %s, state dir: %s", configDir, stateDir)
 
 	cfg := support.LoadConfigWithRepoOverlay(configDir, profile)
 
-	repoID := cfg.Get("ITRUST_REPO_ID", "")
 	baseURL := cfg.Get("ITRUST_BASE_URL", "")
+	repoID := cfg.Get("ITRUST_REPO_ID", "")
 	appId := cfg.Get("ITRUST_APP_ID", "")
 	channel := cfg.Get("ITRUST_CHANNEL", "stable")
 	expectedPubkeySha := cfg.Get("ITRUST_REPO_PUBKEY_SHA256", "")
 	dest := cfg.Get("ITRUST_DEST", "")
-	backendType := cfg.Get("ITRUST_BACKEND", "nexus")
+	backendType := backend.TypeOf(cfg)
 	pubkeyPath := cfg.Get("ITRUST_REPO_PUBKEY_PATH", "repo/public-keys/ed25519.pub")
 
 	if destOverride != "" {
 		dest = destOverride
 	}
-
-	if baseURL == "" || appId == "" || expectedPubkeySha == "" || dest == "" {
-		return fmt.Errorf("missing required configuration (ITRUST_BASE_URL, ITRUST_APP_ID, ITRUST_REPO_PUBKEY_SHA256, ITRUST_DEST)")
+	if limitRate == "" {
+		limitRate = cfg.Get("ITRUST_LIMIT_RATE", "")
 	}
-
-	username := cfg.Get("ITRUST_NEXUS_USERNAME", "")
-	password := os.Getenv("ITRUST_NEXUS_PASSWORD")
-
-	if password == "" && useKeyring && repoID != "" {
-		logger.Debug("Attempting to get credentials from keyring for repo")
-		ss := &secrets.KeyringSecretStore{}
-		if username == "" {
-			username, _ = ss.Get("itrust-updater", "nexus:"+repoID+":username")
-		}
-		password, _ = ss.Get("itrust-updater", "nexus:"+repoID+":password")
+	rateLimit, err := progress.ParseRate(limitRate)
+	if err != nil {
+		return err
 	}
-
-	// Backward compatibility for non-multi-repo keyring
-	if password == "" && useKeyring && username != "" {
-		logger.Debug("Attempting to get credentials from keyring (fallback)")
-		password, _ = keyring.Get("itrust-updater", username)
+	retention, err := support.BackupRetentionFromConfig(cfg)
+	if err != nil {
+		return err
 	}
-
-	if password == "" && !nonInteractive {
-		if username == "" {
-			fmt.Print("Enter Nexus username: ")
-			fmt.Scanln(&username)
-		}
-		if username != "" {
-			var err error
-			password, err = support.ReadPassword(fmt.Sprintf("Enter Nexus password for %s: ", username))
-			if err != nil {
-				return fmt.Errorf("failed to read password: %w", err)
-			}
-		}
+	versioned, keepVersions, err := support.VersionedInstallFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+	extractLimits, err := support.ExtractLimitsFromConfig(cfg)
+	if err != nil {
+		return err
 	}
 
-	if password == "" && username != "" && nonInteractive {
-		return fmt.Errorf("Nexus password is required but not provided (use ITRUST_NEXUS_PASSWORD or init --store-credentials)")
+	if baseURL == "" || appId == "" || expectedPubkeySha == "" || dest == "" {
+		return fmt.Errorf("missing required configuration (ITRUST_BASE_URL, ITRUST_APP_ID, ITRUST_REPO_PUBKEY_SHA256, ITRUST_DEST)")
 	}
 
-	if password == "" && username == "" && nonInteractive {
-		logger.Debug("No Nexus credentials provided, proceeding without auth")
+	policy, err := support.SignaturePolicyFromConfig(cfg)
+	if err != nil {
+		return err
 	}
 
-	var b backend.Backend
-	if backendType == "nexus" {
-		logger.Debugf("Using Nexus backend at %s", baseURL)
-		b = backend.NewNexusBackend(baseURL, username, password)
-	} else {
-		return fmt.Errorf("unsupported backend: %s", backendType)
+	b, err := support.OpenBackend(cfg, nonInteractive, useKeyring)
+	if err != nil {
+		return fmt.Errorf("failed to open backend: %w", err)
 	}
 
 	logger.Infof("Fetching manifest for %s (channel: %s, version: %s)", appId, channel, version)
-	m, _, err := support.FetchAndVerifyManifest(ctx, b, appId, channel, version, pubkeyPath, expectedPubkeySha)
+	m, verification, err := support.FetchAndVerifyManifest(ctx, b, repoID, appId, channel, version, pubkeyPath, expectedPubkeySha, policy)
 	if err != nil {
 		return fmt.Errorf("failed to fetch/verify manifest: %w", err)
 	}
+	support.PersistKeyRotation(configDir, profile, repoID, expectedPubkeySha, verification.RepoKey)
+
+	// Only a missing index means nothing is yanked; one that cannot be
+	// fetched or verified could hide a yank. Whether it is missing or older
+	// than one seen before is checked against the state below.
+	index, indexErr := support.FetchIndex(ctx, b, repoID, appId, verification.RepoKey)
+	if indexErr != nil {
+		if !force {
+			return fmt.Errorf("cannot check for yanked releases: %w (use --force to install anyway)", indexErr)
+		}
+		logger.Warnf("Cannot check for yanked releases: %v", indexErr)
+	}
+	if y := index.Yanked(m.Payload.Latest.Version); y != nil {
+		if !force {
+			return fmt.Errorf("version %s was yanked%s (use --force to install it anyway)", m.Payload.Latest.Version, yankReason(y))
+		}
+		logger.Warnf("Installing yanked version %s%s", m.Payload.Latest.Version, yankReason(y))
+	}
 
 	artifact, err := m.FindArtifact(goos, goarch)
 	if err != nil {
@@ -113,22 +114,58 @@ func handleGet(ctx context.Context, profile, version, destOverride, goos, goarch
 	}
 	logger.Debugf("Found artifact: %s", artifact.URL)
 
-	// Resolve destination if it's a directory
-	if fi, err := os.Stat(dest); err == nil && fi.IsDir() {
-		name := appId
-		ext := filepath.Ext(artifact.URL)
-		if ext != "" {
-			name += ext
+	// Resolve destination if it's a directory; a versioned install always
+	// treats it as the root of the versions. Archives are extracted into the
+	// directory itself.
+	archive := install.IsArchive(artifact.Type)
+	name := appId + filepath.Ext(artifact.URL)
+	installRoot := ""
+	if versioned {
+		installRoot = dest
+		dest = install.VersionDir(installRoot, m.Payload.Latest.Version)
+		if !archive {
+			dest = filepath.Join(dest, name)
 		}
+	} else if fi, err := os.Stat(dest); err == nil && fi.IsDir() && !archive {
 		dest = filepath.Join(dest, name)
 	}
 	logger.Debugf("Resolved destination path: %s", dest)
 
 	// 3. Check state
+	pinned := version != "" && version != "latest"
 	st, err := install.LoadState(stateDir, profile)
+	if err == nil {
+		if err := st.CheckRevocations(verification.Revocations.GeneratedAt()); err != nil {
+			if !allowDowngrade {
+				return fmt.Errorf("refusing possible rollback: %v (use --allow-downgrade to install anyway)", err)
+			}
+			logger.Warnf("Installing despite rollback check: %v", err)
+		}
+		if indexErr == nil {
+			if err := st.CheckIndex(appId, index.GeneratedAt()); err != nil {
+				if !allowDowngrade {
+					return fmt.Errorf("refusing possible rollback: %v (use --allow-downgrade to install anyway)", err)
+				}
+				logger.Warnf("Installing despite rollback check: %v", err)
+			}
+		}
+		withdrawYanked(st, index, m.Payload.Latest.Version)
+		if err := st.CheckDowngrade(appId, channel, m.Payload.Latest.Version, m.Payload.GeneratedAt, pinned); err != nil {
+			if !allowDowngrade {
+				return fmt.Errorf("refusing possible rollback: %v (use --allow-downgrade to install anyway)", err)
+			}
+			logger.Warnf("Installing despite rollback check: %v", err)
+		}
+	}
 	if err == nil && st != nil && !force {
 		if st.InstalledVersion == m.Payload.Latest.Version && st.InstalledSha256 == artifact.Sha256 {
 			if _, err := os.Stat(dest); err == nil {
+				seenRevocations := st.SeeRevocations(verification.Revocations.GeneratedAt())
+				if st.SeeIndex(index.GeneratedAt()) || seenRevocations {
+					if err := install.SaveState(stateDir, profile, st); err != nil {
+						logger.Errorf("Failed to save state: %v", err)
+					}
+				}
 				fmt.Printf("Application %s is up to date (version %s)\n", appId, st.InstalledVersion)
 				logger.Infof("Application %s is up to date (version %s)", appId, st.InstalledVersion)
 				return nil
@@ -139,17 +176,34 @@ func handleGet(ctx context.Context, profile, version, destOverride, goos, goarch
 	// 4. Download and install
 	fmt.Printf("Downloading %s version %s...\n", appId, m.Payload.Latest.Version)
 	logger.Infof("Downloading %s version %s from %s", appId, m.Payload.Latest.Version, artifact.URL)
-	artifactReader, err := b.Get(ctx, artifact.URL)
+	downloaded, err := install.DownloadArtifact(ctx, b, artifact.URL, artifact.Sha256, artifact.Size, stateDir, install.DownloadOptions{
+		Progress:  support.NewProgressReporter(),
+		RateLimit: rateLimit,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to download artifact: %w", err)
 	}
-	defer artifactReader.Close()
-
 	logger.Infof("Installing artifact to %s", dest)
-	actualSha, err := install.InstallArtifact(artifactReader, dest, artifact.Sha256, stateDir, profile, artifact.Type)
+	var actualSha, contentSha string
+	switch {
+	case versioned:
+		dest, actualSha, err = install.InstallVersion(downloaded, installRoot, m.Payload.Latest.Version, name, artifact.Sha256, stateDir, profile, artifact.Type, extractLimits)
+	case archive:
+		actualSha, err = install.InstallArchive(downloaded, dest, artifact.Sha256, stateDir, profile, artifact.Type, !retention.Disabled, extractLimits)
+	default:
+		actualSha, err = installFile(downloaded, dest, artifact.Sha256, stateDir, profile, artifact.Type, !retention.Disabled)
+	}
 	if err != nil {
 		return fmt.Errorf("installation failed: %w", err)
 	}
+	if archive {
+		if contentSha, err = install.DirSHA256(dest); err != nil {
+			logger.Warnf("Failed to hash %s: %v", dest, err)
+		}
+	}
+	if err := os.Remove(downloaded); err != nil {
+		logger.Warnf("Failed to remove downloaded file %s: %v", downloaded, err)
+	}
 
 	// 5. Save state
 	newState := &install.State{
@@ -164,12 +218,57 @@ func handleGet(ctx context.Context, profile, version, destOverride, goos, goarch
 		Arch:             goarch,
 		SourceURL:        artifact.URL,
 		BackendInfo:      backendType,
+		SignedBy:         verification.Signers,
+		InstallRoot:      installRoot,
+		ContentSha256:    contentSha,
 	}
+	newState.Advance(st, m.Payload.GeneratedAt, pinned)
+	newState.SeeRevocations(verification.Revocations.GeneratedAt())
+	newState.SeeIndex(index.GeneratedAt())
 	if err := install.SaveState(stateDir, profile, newState); err != nil {
 		logger.Errorf("Failed to save state: %v", err)
 	}
+	if versioned {
+		if err := install.SaveVersionState(installRoot, newState); err != nil {
+			logger.Warnf("Failed to record version %s: %v", newState.InstalledVersion, err)
+		}
+		pruneVersions(installRoot, keepVersions)
+	} else {
+		pruneBackups(stateDir, profile, retention, "")
+	}
 
 	fmt.Printf("Successfully installed %s version %s to %s\n", appId, m.Payload.Latest.Version, dest)
 	logger.Infof("Successfully installed %s version %s to %s", appId, m.Payload.Latest.Version, dest)
 	return nil
 }
+
+func installFile(downloaded, dest, sha256, stateDir, profile, artifactType string, backup bool) (string, error) {
+	f, err := os.Open(downloaded)
+	if err != nil {
+		return "", fmt.Errorf("failed to open downloaded artifact: %w", err)
+	}
+	defer f.Close()
+	return install.InstallArtifact(f, dest, sha256, stateDir, profile, artifactType, backup)
+}
+
+// withdrawYanked lets the profile leave a yanked release for replacement, the
+// release its channel was rolled back to. index must have been fetched and
+// verified; a nil index withdraws nothing.
+func withdrawYanked(st *install.State, index *manifest.Index, replacement string) {
+	if st == nil || index.Yanked(replacement) != nil {
+		return
+	}
+	for _, v := range []string{st.HighestVersion, st.InstalledVersion} {
+		if v != "" && index.Yanked(v) != nil {
+			logger.Infof("Version %s was yanked, allowing %s", v, replacement)
+			st.Withdraw(v, replacement)
+		}
+	}
+}
+
+func yankReason(y *manifest.Yank) string {
+	if y.Reason == "" {
+		return ""
+	}
+	return " (" + y.Reason + ")"
+}
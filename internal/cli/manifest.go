@@ -1,11 +1,15 @@
 package cli
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 
+	"github.com/alapierre/itrust-updater/internal/support"
+	"github.com/alapierre/itrust-updater/pkg/config"
 	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/secrets"
 	"github.com/alapierre/itrust-updater/pkg/sign"
 	"github.com/zalando/go-keyring"
 )
@@ -13,16 +17,35 @@ import (
 type ManifestCmd struct {
 	Verify ManifestVerifyCmd `cmd:"" help:"Verify a manifest file."`
 	Sign   ManifestSignCmd   `cmd:"" help:"Sign a payload."`
+	Cosign ManifestCosignCmd `cmd:"" help:"Add a cosignature to a release or manifest file."`
 }
 
 type ManifestVerifyCmd struct {
-	File             string `required:"" help:"Manifest file to verify."`
-	RepoPubkey       string `required:"" help:"Path to repository public key."`
-	RepoPubkeySha256 string `name:"repo-pubkey-sha256" help:"Expected SHA256 of public key."`
+	File             string   `required:"" help:"Manifest file to verify."`
+	RepoPubkey       string   `required:"" help:"Path to repository public key."`
+	RepoPubkeySha256 string   `name:"repo-pubkey-sha256" help:"Expected SHA256 of public key."`
+	CosignerPubkey   []string `help:"Path to a cosigner public key (repeatable)."`
+	Threshold        int      `default:"1" help:"Number of distinct trusted keys that must have signed."`
 }
 
 func (c *ManifestVerifyCmd) Run(g *Globals) error {
-	return handleManifestVerify(c.File, c.RepoPubkey, c.RepoPubkeySha256)
+	return handleManifestVerify(c.File, c.RepoPubkey, c.RepoPubkeySha256, c.CosignerPubkey, c.Threshold)
+}
+
+type ManifestCosignCmd struct {
+	RepoID     string `help:"Repository ID of the published release to cosign."`
+	AppID      string `help:"Application ID of the release."`
+	Version    string `help:"Version of the release."`
+	File       string `help:"Manifest file to cosign instead of a published release."`
+	RepoPubkey string `help:"Path to repository public key (with --file)."`
+	Out        string `help:"Output file (with --file, default: overwrite the input)."`
+}
+
+func (c *ManifestCosignCmd) Run(g *Globals) error {
+	if c.File != "" {
+		return handleManifestCosignFile(c.File, c.RepoPubkey, c.Out, c.RepoID, g.UseKeyring)
+	}
+	return handleManifestCosign(context.Background(), c.RepoID, c.AppID, c.Version, g.NonInteractive, g.UseKeyring)
 }
 
 type ManifestSignCmd struct {
@@ -35,7 +58,7 @@ func (c *ManifestSignCmd) Run(g *Globals) error {
 	return handleManifestSign(c.Payload, c.Out, c.KeyID, g.UseKeyring)
 }
 
-func handleManifestVerify(filePath, pubKeyPath, expectedSha string) error {
+func handleManifestVerify(filePath, pubKeyPath, expectedSha string, cosignerKeyPaths []string, threshold int) error {
 	logger.Infof("Verifying manifest: %s", filePath)
 	data, err := os.ReadFile(filePath)
 	if err != nil {
@@ -58,7 +81,15 @@ func handleManifestVerify(filePath, pubKeyPath, expectedSha string) error {
 		}
 	}
 
-	if err := m.Verify(pubKey); err != nil {
+	keys := [][]byte{pubKey}
+	for _, path := range cosignerKeyPaths {
+		key, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read cosigner public key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := m.VerifyThreshold(keys, threshold); err != nil {
 		return fmt.Errorf("verification failed: %w", err)
 	}
 	fmt.Println("Manifest verified successfully.")
@@ -97,3 +128,93 @@ func handleManifestSign(payloadPath, outPath, keyId string, useKeyring bool) err
 	fmt.Printf("Manifest signed and saved to %s\n", outPath)
 	return nil
 }
+
+// resolveCosignSeed returns the cosigner's own seed, which is never the
+// repository signing seed.
+func resolveCosignSeed(repoID string, useKeyring bool) (string, error) {
+	seed := os.Getenv("ITRUST_COSIGN_ED25519_SEED_B64")
+	if seed == "" && useKeyring && repoID != "" {
+		logger.Debug("Attempting to get cosigning seed from keyring")
+		ss := &secrets.KeyringSecretStore{}
+		seed, _ = ss.Get("itrust-updater", "cosign:"+repoID+":ed25519-seed-b64")
+	}
+	if seed == "" {
+		return "", fmt.Errorf("cosigning seed missing (ITRUST_COSIGN_ED25519_SEED_B64)")
+	}
+	return seed, nil
+}
+
+func handleManifestCosign(ctx context.Context, repoID, appID, version string, nonInteractive, useKeyring bool) error {
+	if repoID == "" || appID == "" || version == "" {
+		return fmt.Errorf("--repo-id, --app-id and --version are required (or --file)")
+	}
+	logger.Infof("Cosigning %s version %s in repository %s", appID, version, repoID)
+	cfg := config.GetEnvConfig()
+	cfg["ITRUST_REPO_ID"] = repoID
+	support.OverlayRepoConfig(cfg, support.GetDefaultConfigDir())
+	pubkeyPath := cfg.Get("ITRUST_REPO_PUBKEY_PATH", "repo/public-keys/ed25519.pub")
+	repoPubkeySha := cfg.Get("ITRUST_REPO_PUBKEY_SHA256", "")
+	if repoPubkeySha == "" {
+		return fmt.Errorf("missing ITRUST_REPO_PUBKEY_SHA256 for repository %s", repoID)
+	}
+
+	seed, err := resolveCosignSeed(repoID, useKeyring)
+	if err != nil {
+		return err
+	}
+	b, err := support.OpenBackend(cfg, nonInteractive, useKeyring)
+	if err != nil {
+		return fmt.Errorf("failed to open backend: %w", err)
+	}
+
+	cosigned, err := support.CosignRelease(ctx, b, repoID, appID, version, pubkeyPath, repoPubkeySha, seed)
+	for _, path := range cosigned {
+		fmt.Printf("Cosigned %s\n", path)
+	}
+	if err != nil {
+		return fmt.Errorf("cosign failed: %w", err)
+	}
+	pubKey, _ := sign.SeedToPubKey(seed)
+	fmt.Printf("\nCosigner key fingerprint: %s\n", sign.SHA256(pubKey))
+	fmt.Println("Clients requiring this key: ITRUST_COSIGNER_PUBKEYS_SHA256=<fingerprints> and ITRUST_SIGNATURE_THRESHOLD=<n>")
+	return nil
+}
+
+func handleManifestCosignFile(filePath, pubKeyPath, outPath, repoID string, useKeyring bool) error {
+	logger.Infof("Cosigning manifest file %s", filePath)
+	if pubKeyPath == "" {
+		return fmt.Errorf("--repo-pubkey is required with --file")
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var m manifest.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	repoKey, err := os.ReadFile(pubKeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read public key: %w", err)
+	}
+	if repoID == "" {
+		repoID = m.Payload.Repo.ID
+	}
+	seed, err := resolveCosignSeed(repoID, useKeyring)
+	if err != nil {
+		return err
+	}
+
+	if err := support.CosignManifest(&m, repoKey, seed); err != nil {
+		return err
+	}
+	if outPath == "" {
+		outPath = filePath
+	}
+	mJson, _ := json.MarshalIndent(&m, "", "  ")
+	if err := os.WriteFile(outPath, mJson, 0644); err != nil {
+		return fmt.Errorf("failed to write cosigned manifest: %w", err)
+	}
+	fmt.Printf("Manifest cosigned and saved to %s\n", outPath)
+	return nil
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alapierre/itrust-updater/internal/support"
+	"github.com/alapierre/itrust-updater/pkg/config"
+	"github.com/alapierre/itrust-updater/pkg/publish"
+)
+
+type PromoteCmd struct {
+	Config  string `default:"./itrust-updater.project.env" help:"Project configuration file."`
+	RepoID  string `help:"Repository ID."`
+	AppID   string `help:"Application ID."`
+	Version string `required:"" help:"Version to promote."`
+	From    string `required:"" help:"Channel the release was tested in."`
+	To      string `required:"" help:"Channel to promote the release to."`
+	Force   bool   `help:"Promote even if the release was not published to --from, --to has a newer version or its cosignatures would be dropped."`
+}
+
+func (c *PromoteCmd) Run(g *Globals) error {
+	return handlePromote(context.Background(), c.Config, c.RepoID, c.AppID, c.Version, c.From, c.To, c.Force, g.NonInteractive, g.UseKeyring)
+}
+
+func handlePromote(ctx context.Context, configPath, repoIDFlag, appIDFlag, version, from, to string, force, nonInteractive, useKeyring bool) error {
+	cfg, err := support.LoadProjectConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load project config: %w", err)
+	}
+	cfg.Merge(config.GetEnvConfig())
+
+	repoID := repoIDFlag
+	if repoID == "" {
+		repoID = cfg.Get("ITRUST_REPO_ID", "")
+	}
+	if repoID != "" {
+		cfg["ITRUST_REPO_ID"] = repoID
+		support.OverlayRepoConfig(cfg, support.GetDefaultConfigDir())
+	}
+	appID := appIDFlag
+	if appID == "" {
+		appID = cfg.Get("ITRUST_APP_ID", "")
+	}
+	if cfg.Get("ITRUST_BASE_URL", "") == "" || appID == "" {
+		return fmt.Errorf("missing required project configuration (base-url, app-id)")
+	}
+	logger.Infof("Promoting %s version %s from %s to %s", appID, version, from, to)
+
+	b, err := support.OpenBackend(cfg, nonInteractive, useKeyring)
+	if err != nil {
+		return fmt.Errorf("failed to open backend: %w", err)
+	}
+	seed, err := support.ResolveSigningSeed(cfg, repoID, useKeyring)
+	if err != nil {
+		return err
+	}
+	if err := support.CheckSigningSeed(ctx, b, cfg.Get("ITRUST_REPO_PUBKEY_PATH", "repo/public-keys/ed25519.pub"), seed); err != nil {
+		return err
+	}
+	ttl, err := support.ParseTTL(cfg.Get("ITRUST_MANIFEST_TTL", ""))
+	if err != nil {
+		return fmt.Errorf("invalid ITRUST_MANIFEST_TTL: %w", err)
+	}
+
+	_, err = publish.Promote(ctx, b, &publish.Promotion{
+		RepoID:  repoID,
+		AppID:   appID,
+		Version: version,
+		From:    from,
+		To:      to,
+		Seed:    seed,
+		KeyID:   support.SigningKeyID(),
+		TTL:     ttl,
+		Force:   force,
+	})
+	if err != nil {
+		return fmt.Errorf("promotion failed: %w", err)
+	}
+	fmt.Printf("Promoted %s version %s from %s to %s.\n", appID, version, from, to)
+	return nil
+}
@@ -2,43 +2,37 @@ package cli
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"runtime"
 	"strings"
-	"time"
 
 	"github.com/alapierre/itrust-updater/internal/support"
-	"github.com/alapierre/itrust-updater/pkg/backend"
 	"github.com/alapierre/itrust-updater/pkg/config"
-	"github.com/alapierre/itrust-updater/pkg/manifest"
-	"github.com/alapierre/itrust-updater/pkg/repo"
-	"github.com/alapierre/itrust-updater/pkg/secrets"
-	"github.com/alapierre/itrust-updater/pkg/sign"
-	"github.com/zalando/go-keyring"
+	"github.com/alapierre/itrust-updater/pkg/publish"
 )
 
 type PushCmd struct {
-	Config       string `default:"./itrust-updater.project.env" help:"Project configuration file."`
-	ArtifactPath string `help:"Path to the artifact to push."`
-	RepoID       string `help:"Repository ID."`
-	AppID        string `help:"Application ID."`
-	Version      string `help:"Version to push."`
-	RunHooks     bool   `default:"true" help:"Run pre-push hooks."`
-	Force        bool   `help:"Allow overwriting an existing release (dangerous)."`
+	Config       string   `default:"./itrust-updater.project.env" help:"Project configuration file."`
+	ArtifactPath string   `help:"Path to the artifact to push."`
+	Artifact     []string `help:"Artifact to push as path[:os/arch]; the path may be a glob. Repeatable." placeholder:"PATH[:OS/ARCH]"`
+	Dist         string   `help:"GoReleaser dist directory whose archives (or binaries) are pushed."`
+	RepoID       string   `help:"Repository ID."`
+	AppID        string   `help:"Application ID."`
+	Version      string   `help:"Version to push."`
+	RunHooks     bool     `default:"true" help:"Run pre-push hooks."`
+	Force        bool     `help:"Allow overwriting an existing release or dropping its cosignatures (dangerous)."`
+	VerifyUpload bool     `help:"Download every uploaded artifact again and check its SHA256 before signing."`
 }
 
 func (c *PushCmd) Run(g *Globals) error {
-	return handlePush(context.Background(), c.Config, c.ArtifactPath, c.RepoID, c.AppID, c.Version, c.RunHooks, c.Force, g.NonInteractive, g.UseKeyring)
+	return handlePush(context.Background(), c.Config, c.ArtifactPath, c.Artifact, c.Dist, c.RepoID, c.AppID, c.Version, c.RunHooks, c.Force, c.VerifyUpload, g.NonInteractive, g.UseKeyring)
 }
 
-func handlePush(ctx context.Context, configPath, artifactPathFlag, repoIDFlag, appIDFlag, versionFlag string, runHooks, force, nonInteractive, useKeyring bool) error {
+func handlePush(ctx context.Context, configPath, artifactPathFlag string, artifactSpecs []string, distDir string, repoIDFlag, appIDFlag, versionFlag string, runHooks, force, verifyUpload, nonInteractive, useKeyring bool) error {
 	logger.Infof("Starting push with config: %s", configPath)
-	cfg, err := config.LoadFile(configPath)
+	cfg, err := support.LoadProjectConfig(configPath)
 	if err != nil {
 		return fmt.Errorf("failed to load project config: %w", err)
 	}
@@ -51,14 +45,8 @@ func handlePush(ctx context.Context, configPath, artifactPathFlag, repoIDFlag, a
 	}
 
 	if repoID != "" {
-		configDir := support.GetDefaultConfigDir()
-		logger.Debugf("Loading repo config for %s from %s", repoID, configDir)
-		rc, err := repo.LoadRepoConfig(configDir, repoID)
-		if err == nil {
-			if cfg.Get("ITRUST_BASE_URL", "") == "" {
-				cfg["ITRUST_BASE_URL"] = rc.BaseURL
-			}
-		}
+		cfg["ITRUST_REPO_ID"] = repoID
+		support.OverlayRepoConfig(cfg, support.GetDefaultConfigDir())
 	}
 
 	baseURL := cfg.Get("ITRUST_BASE_URL", "")
@@ -75,228 +63,119 @@ func handlePush(ctx context.Context, configPath, artifactPathFlag, repoIDFlag, a
 		version = cfg.Get("ITRUST_VERSION", "")
 	}
 
-	goos := cfg.Get("ITRUST_OS", runtime.GOOS)
-	goarch := cfg.Get("ITRUST_ARCH", runtime.GOARCH)
-
-	// Artifact path priority: CLI flag > ENV/Config
-	artifactPath := artifactPathFlag
-	if artifactPath == "" {
-		artifactPath = cfg.Get("ITRUST_ARTIFACT_PATH", "")
+	locals, err := collectArtifacts(cfg, artifactPathFlag, artifactSpecs, distDir)
+	if err != nil {
+		return err
 	}
 
-	backendType := cfg.Get("ITRUST_BACKEND", "nexus")
 	repoName := cfg.Get("ITRUST_REPO_NAME", "Default Repo")
 	appName := cfg.Get("ITRUST_APP_NAME", appId)
 
-	if baseURL == "" || appId == "" || version == "" || artifactPath == "" {
+	if baseURL == "" || appId == "" || version == "" || len(locals) == 0 {
 		return fmt.Errorf("missing required project configuration (base-url, app-id, version, artifact-path)")
 	}
-	logger.Infof("Pushing app %s version %s to %s", appId, version, baseURL)
-
-	// Secrety
-	username := cfg.Get("ITRUST_NEXUS_USERNAME", os.Getenv("ITRUST_NEXUS_USERNAME"))
-	password := os.Getenv("ITRUST_NEXUS_PASSWORD")
-
-	if password == "" && useKeyring && repoID != "" {
-		logger.Debug("Attempting to get credentials from keyring")
-		ss := &secrets.KeyringSecretStore{}
-		if username == "" {
-			username, _ = ss.Get("itrust-updater", "nexus:"+repoID+":username")
-		}
-		password, _ = ss.Get("itrust-updater", "nexus:"+repoID+":password")
-	}
+	logger.Infof("Pushing app %s version %s (%d artifact(s)) to %s", appId, version, len(locals), baseURL)
 
-	if password == "" && useKeyring && username != "" {
-		logger.Debug("Attempting to get credentials from keyring (fallback)")
-		password, _ = keyring.Get("itrust-updater", username)
-	}
-	if password == "" && !nonInteractive {
-		var err error
-		password, err = support.ReadPassword(fmt.Sprintf("Enter password for %s: ", username))
-		if err != nil {
-			return fmt.Errorf("failed to read password: %w", err)
-		}
+	b, err := support.OpenBackend(cfg, nonInteractive, useKeyring)
+	if err != nil {
+		return fmt.Errorf("failed to open backend: %w", err)
 	}
 
-	seed := cfg.Get("ITRUST_REPO_SIGNING_ED25519_SEED_B64", os.Getenv("ITRUST_REPO_SIGNING_ED25519_SEED_B64"))
-	if seed == "" && useKeyring && repoID != "" {
-		logger.Debug("Attempting to get signing seed from keyring")
-		ss := &secrets.KeyringSecretStore{}
-		seed, _ = ss.Get("itrust-updater", "signing:"+repoID+":ed25519-seed-b64")
+	seed, err := support.ResolveSigningSeed(cfg, repoID, useKeyring)
+	if err != nil {
+		return err
 	}
-	if seed == "" && useKeyring {
-		seed, _ = keyring.Get("itrust-updater-sign", repoID)
+	if err := support.CheckSigningSeed(ctx, b, cfg.Get("ITRUST_REPO_PUBKEY_PATH", "repo/public-keys/ed25519.pub"), seed); err != nil {
+		return err
 	}
-	if seed == "" {
-		return fmt.Errorf("repository signing seed missing (ITRUST_REPO_SIGNING_ED25519_SEED_B64)")
+	ttl, err := support.ParseTTL(cfg.Get("ITRUST_MANIFEST_TTL", ""))
+	if err != nil {
+		return fmt.Errorf("invalid ITRUST_MANIFEST_TTL: %w", err)
 	}
 
-	// Hook
+	// Hook, run once per artifact
 	hook := cfg.Get("ITRUST_PREPUSH_HOOK", "")
 	if hook != "" && runHooks {
-		fmt.Printf("Running pre-push hook: %s\n", hook)
-		logger.Infof("Running pre-push hook: %s", hook)
-		cmdParts := strings.Fields(hook)
-		cmd := exec.Command(cmdParts[0], cmdParts[1:]...)
-		cmd.Env = append(os.Environ(), "ITRUST_ARTIFACT_PATH="+artifactPath)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("pre-push hook failed: %w", err)
+		for _, local := range locals {
+			fmt.Printf("Running pre-push hook for %s: %s\n", local.Path, hook)
+			logger.Infof("Running pre-push hook for %s: %s", local.Path, hook)
+			cmdParts := strings.Fields(hook)
+			cmd := exec.Command(cmdParts[0], cmdParts[1:]...)
+			cmd.Env = append(os.Environ(), "ITRUST_ARTIFACT_PATH="+local.Path, "ITRUST_OS="+local.OS, "ITRUST_ARCH="+local.Arch)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				return fmt.Errorf("pre-push hook failed for %s: %w", local.Path, err)
+			}
 		}
 	}
 
-	// 1. Calculate artifact metadata
-	logger.Debugf("Calculating metadata for artifact: %s", artifactPath)
-	sha256, err := sign.FileSHA256(artifactPath)
-	if err != nil {
-		return fmt.Errorf("failed to calculate artifact SHA256: %w", err)
+	release := &publish.Release{
+		RepoID:       repoID,
+		RepoName:     repoName,
+		AppID:        appId,
+		AppName:      appName,
+		Version:      version,
+		Channel:      channel,
+		Artifacts:    locals,
+		Seed:         seed,
+		KeyID:        support.SigningKeyID(),
+		TTL:          ttl,
+		Force:        force,
+		VerifyUpload: verifyUpload || cfg.Get("ITRUST_VERIFY_UPLOAD", "false") == "true",
 	}
-	fi, err := os.Stat(artifactPath)
-	if err != nil {
-		return fmt.Errorf("failed to stat artifact: %w", err)
+	if _, err := publish.Publish(ctx, b, release); err != nil {
+		return fmt.Errorf("push failed: %w", err)
 	}
-
-	// Keep extension exactly as provided by the artifact path.
-	// If the file has no extension, do NOT add one.
-	ext := strings.ToLower(filepath.Ext(artifactPath))
-
-	artifactType := "binary"
-	switch ext {
-	case ".jar":
-		artifactType = "jar"
-		goos = "any"
-		goarch = "any"
-	case ".zip":
-		artifactType = "zip"
-	case ".msi":
-		artifactType = "msi"
-	case ".exe":
-		artifactType = "exe"
+	for _, local := range locals {
+		fmt.Printf("Uploaded %s to %s\n", local.Path, release.ArtifactPath(local))
 	}
 
-	remoteArtifactPath := fmt.Sprintf("apps/%s/releases/v%s/%s/%s/%s_%s_%s_%s%s", appId, version, goos, goarch, appId, version, goos, goarch, ext)
-	logger.Debugf("Remote artifact path: %s", remoteArtifactPath)
-
-	var b backend.Backend
-	if backendType == "nexus" {
-		b = backend.NewNexusBackend(baseURL, username, password)
-	}
+	fmt.Println("Push successful!")
+	logger.Infof("Push successful for %s version %s", appId, version)
+	return nil
+}
 
-	// 1.5 Check if release already exists
-	versionManifestPath := fmt.Sprintf("apps/%s/releases/v%s/artifacts.json", appId, version)
-	var existingArtifacts []manifest.Artifact
-	exists, err := b.Exists(ctx, versionManifestPath)
-	if err != nil {
-		return fmt.Errorf("failed to check if release exists: %w", err)
-	}
-	if exists {
-		logger.Debugf("Version manifest exists at %s, checking for conflicts", versionManifestPath)
-		// Fetch existing manifest to check for OS/Arch conflict
-		rc, err := b.Get(ctx, versionManifestPath)
+// collectArtifacts returns the artifacts to push: those of --dist and
+// --artifact, or else the single --artifact-path (ITRUST_ARTIFACT_PATH) for
+// ITRUST_OS/ITRUST_ARCH.
+func collectArtifacts(cfg config.Config, artifactPathFlag string, specs []string, distDir string) ([]publish.LocalArtifact, error) {
+	var locals []publish.LocalArtifact
+	if distDir != "" {
+		dist, err := publish.DistArtifacts(distDir)
 		if err != nil {
-			return fmt.Errorf("failed to fetch existing manifest: %w", err)
+			return nil, err
 		}
-		var m manifest.Manifest
-		if err := json.NewDecoder(rc).Decode(&m); err != nil {
-			rc.Close()
-			return fmt.Errorf("failed to decode existing manifest: %w", err)
-		}
-		rc.Close()
-
-		existingArtifacts = m.Payload.Latest.Artifacts
-		for _, a := range existingArtifacts {
-			if a.OS == goos && a.Arch == goarch {
-				if !force {
-					return fmt.Errorf("artifact for %s/%s in version v%s already exists. Use --force to overwrite", goos, goarch, version)
-				}
-				logger.Warnf("Overwriting existing artifact for %s/%s", goos, goarch)
-				break
-			}
-		}
-	}
-
-	// 2. Upload artifact
-	fmt.Printf("Uploading artifact to %s\n", remoteArtifactPath)
-	logger.Infof("Uploading artifact to %s", remoteArtifactPath)
-	openArtifact := func() (io.ReadCloser, error) {
-		return os.Open(artifactPath)
-	}
-	if err := b.Put(ctx, remoteArtifactPath, openArtifact, "application/octet-stream"); err != nil {
-		return fmt.Errorf("upload failed: %w", err)
+		locals = append(locals, dist...)
 	}
-
-	// 3. Upload SHA256
-	logger.Debug("Uploading SHA256")
-	openSha := func() (io.ReadCloser, error) {
-		return io.NopCloser(strings.NewReader(sha256)), nil
-	}
-	if err := b.Put(ctx, remoteArtifactPath+".sha256", openSha, "text/plain"); err != nil {
-		logger.Errorf("Failed to upload SHA256: %v", err)
-	}
-
-	// 4. Create and upload artifacts.json (version manifest)
-	newArt := manifest.Artifact{
-		OS:     goos,
-		Arch:   goarch,
-		Type:   artifactType,
-		URL:    remoteArtifactPath,
-		Size:   fi.Size(),
-		Sha256: sha256,
-	}
-
-	// Merge with existing artifacts
-	finalArtifacts := make([]manifest.Artifact, 0)
-	found := false
-	for _, a := range existingArtifacts {
-		if a.OS == goos && a.Arch == goarch {
-			finalArtifacts = append(finalArtifacts, newArt)
-			found = true
-		} else {
-			finalArtifacts = append(finalArtifacts, a)
+	for _, spec := range specs {
+		parsed, err := publish.ParseArtifactSpec(spec)
+		if err != nil {
+			return nil, err
 		}
-	}
-	if !found {
-		finalArtifacts = append(finalArtifacts, newArt)
+		locals = append(locals, parsed...)
 	}
 
-	payload := manifest.Payload{
-		SchemaVersion: 1,
-		Repo:          manifest.RepoInfo{ID: repoID, Name: repoName},
-		App:           manifest.AppInfo{ID: appId, Name: appName},
-		Channel:       channel,
-		GeneratedAt:   time.Now().UTC(),
-		Latest: manifest.Release{
-			Version:     version,
-			ReleaseDate: time.Now().UTC(),
-			Artifacts:   finalArtifacts,
-		},
-	}
-
-	logger.Infof("Signing and uploading manifest")
-	m, err := manifest.SignManifest(payload, seed, "repo-key-"+time.Now().Format("2006-01"))
-	if err != nil {
-		return fmt.Errorf("failed to sign manifest: %w", err)
-	}
-
-	mJson, _ := json.MarshalIndent(m, "", "  ")
-	openManifest := func() (io.ReadCloser, error) {
-		return io.NopCloser(strings.NewReader(string(mJson))), nil
-	}
-
-	versionManifestPath = fmt.Sprintf("apps/%s/releases/v%s/artifacts.json", appId, version)
-	if err := b.Put(ctx, versionManifestPath, openManifest, "application/json"); err != nil {
-		return fmt.Errorf("failed to upload version manifest: %w", err)
-	}
-
-	// 5. Update channel manifest
-	channelManifestPath := fmt.Sprintf("apps/%s/channels/%s.json", appId, channel)
-	logger.Infof("Updating channel manifest: %s", channelManifestPath)
-	if err := b.Put(ctx, channelManifestPath, openManifest, "application/json"); err != nil {
-		return fmt.Errorf("failed to upload channel manifest: %w", err)
+	if len(locals) == 0 {
+		// Artifact path priority: CLI flag > ENV/Config
+		artifactPath := artifactPathFlag
+		if artifactPath == "" {
+			artifactPath = cfg.Get("ITRUST_ARTIFACT_PATH", "")
+		}
+		if artifactPath == "" {
+			return nil, nil
+		}
+		local := publish.LocalArtifact{
+			Path: artifactPath,
+			OS:   cfg.Get("ITRUST_OS", runtime.GOOS),
+			Arch: cfg.Get("ITRUST_ARCH", runtime.GOARCH),
+		}
+		if publish.ArtifactType(artifactPath) == "jar" {
+			local.OS, local.Arch = "any", "any"
+		}
+		locals = append(locals, local)
+	} else if artifactPathFlag != "" {
+		return nil, fmt.Errorf("--artifact-path cannot be combined with --artifact or --dist")
 	}
-
-	fmt.Println("Push successful!")
-	logger.Infof("Push successful for %s version %s", appId, version)
-	return nil
+	return locals, publish.CheckPlatforms(locals)
 }
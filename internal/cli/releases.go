@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alapierre/itrust-updater/internal/support"
+	"github.com/alapierre/itrust-updater/pkg/config"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+)
+
+type ReleasesCmd struct {
+	List ReleasesListCmd `cmd:"" help:"List the published releases of an application."`
+}
+
+type ReleasesListCmd struct {
+	App    string `arg:"" help:"Application ID, or the name of a profile installing it."`
+	RepoID string `help:"Repository ID (default: from the profile or ITRUST_REPO_ID)."`
+	Format string `default:"table" enum:"table,json" help:"Output format (table, json)."`
+}
+
+func (c *ReleasesListCmd) Run(g *Globals) error {
+	return handleReleasesList(context.Background(), c.App, c.RepoID, c.Format, g.NonInteractive, g.UseKeyring)
+}
+
+func handleReleasesList(ctx context.Context, app, repoIDFlag, format string, nonInteractive, useKeyring bool) error {
+	configDir := support.GetDefaultConfigDir()
+
+	// A profile of that name supplies the app ID and repository.
+	var cfg config.Config
+	appID := app
+	if _, err := os.Stat(filepath.Join(configDir, "apps", app+".env")); err == nil {
+		logger.Debugf("Using profile %s", app)
+		cfg = support.LoadConfigWithRepoOverlay(configDir, app)
+		appID = cfg.Get("ITRUST_APP_ID", app)
+	} else {
+		cfg = config.GetEnvConfig()
+	}
+	if repoIDFlag != "" {
+		cfg["ITRUST_REPO_ID"] = repoIDFlag
+	}
+	support.OverlayRepoConfig(cfg, configDir)
+
+	repoID := cfg.Get("ITRUST_REPO_ID", "")
+	expectedPubkeySha := cfg.Get("ITRUST_REPO_PUBKEY_SHA256", "")
+	pubkeyPath := cfg.Get("ITRUST_REPO_PUBKEY_PATH", "repo/public-keys/ed25519.pub")
+	if repoID == "" || expectedPubkeySha == "" {
+		return fmt.Errorf("repository ID and pinned key are required (--repo-id with a configured repository)")
+	}
+
+	b, err := support.OpenBackend(cfg, nonInteractive, useKeyring)
+	if err != nil {
+		return fmt.Errorf("failed to open backend: %w", err)
+	}
+
+	logger.Infof("Fetching release index of %s", appID)
+	index, _, err := support.FetchAndVerifyIndex(ctx, b, repoID, appID, pubkeyPath, expectedPubkeySha)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		out, err := json.MarshalIndent(index.Payload.Releases, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+	printReleases(index.Payload.Releases)
+	return nil
+}
+
+func printReleases(releases []manifest.IndexEntry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tRELEASED\tCHANNELS\tPLATFORMS\tSTATUS")
+	for _, r := range releases {
+		var platforms []string
+		for _, a := range r.Artifacts {
+			platforms = append(platforms, a.OS+"/"+a.Arch)
+		}
+		status := ""
+		if r.Yanked != nil {
+			status = "yanked" + yankReason(r.Yanked)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Version, r.ReleaseDate.Local().Format(time.RFC3339), strings.Join(r.Channels, ","), strings.Join(platforms, ","), status)
+	}
+	w.Flush()
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/alapierre/itrust-updater/internal/support"
 	"github.com/alapierre/itrust-updater/pkg/backend"
@@ -15,25 +17,33 @@ import (
 	"github.com/alapierre/itrust-updater/pkg/repo"
 	"github.com/alapierre/itrust-updater/pkg/secrets"
 	"github.com/alapierre/itrust-updater/pkg/sign"
+	"github.com/alapierre/itrust-updater/pkg/trust"
 )
 
 type RepoCmd struct {
-	Init   RepoInitCmd   `cmd:"" help:"Initialize a new repository."`
-	Config RepoConfigCmd `cmd:"" help:"Show repository configuration."`
-	Export RepoExportCmd `cmd:"" help:"Export repository configuration and secrets."`
-	Import RepoImportCmd `cmd:"" help:"Import repository configuration and secrets."`
+	Init      RepoInitCmd      `cmd:"" help:"Initialize a new repository."`
+	Config    RepoConfigCmd    `cmd:"" help:"Show repository configuration."`
+	Export    RepoExportCmd    `cmd:"" help:"Export repository configuration and secrets."`
+	Import    RepoImportCmd    `cmd:"" help:"Import repository configuration and secrets."`
+	Refresh   RepoRefreshCmd   `cmd:"" help:"Re-sign channel manifests with a new expiry."`
+	RotateKey RepoRotateKeyCmd `cmd:"" help:"Replace the repository signing key, publishing a transition signed by the old key."`
+	RevokeKey RepoRevokeKeyCmd `cmd:"" help:"Add a key to the repository's signed revocation list."`
 }
 
 type RepoInitCmd struct {
-	RepoID        string `required:"" help:"Repository ID."`
-	BaseURL       string `required:"" help:"Repository base URL."`
-	NexusUser     string `required:"" help:"Nexus username."`
-	NexusPassword string `help:"Nexus password (prompted if missing)."`
-	PubkeyPath    string `default:"repo/public-keys/ed25519.pub" help:"Path in repository for public key."`
+	RepoID         string `required:"" help:"Repository ID."`
+	BaseURL        string `required:"" help:"Repository base URL."`
+	Backend        string `default:"nexus" help:"Repository backend type."`
+	NexusUser      string `help:"Nexus username."`
+	NexusPassword  string `help:"Nexus password (prompted if missing)."`
+	NexusToken     string `help:"Nexus bearer token (instead of username/password)."`
+	NexusUserToken string `help:"Nexus user token as <name code>:<pass code> (instead of username/password)."`
+	PubkeyPath     string `default:"repo/public-keys/ed25519.pub" help:"Path in repository for public key."`
 }
 
 func (c *RepoInitCmd) Run(g *Globals) error {
-	return handleRepoInit(context.Background(), c.RepoID, c.BaseURL, c.NexusUser, c.NexusPassword, c.PubkeyPath, g.NonInteractive, g.UseKeyring)
+	auth := nexusAuth{User: c.NexusUser, Password: c.NexusPassword, Token: c.NexusToken, UserToken: c.NexusUserToken}
+	return handleRepoInit(context.Background(), c.RepoID, c.BaseURL, c.Backend, auth, c.PubkeyPath, g.NonInteractive, g.UseKeyring)
 }
 
 type RepoConfigCmd struct {
@@ -64,17 +74,64 @@ func (c *RepoImportCmd) Run(g *Globals) error {
 	return handleRepoImport(c.In, c.WriteRepoConfig, g.UseKeyring)
 }
 
-func handleRepoInit(ctx context.Context, repoID, baseURL, user, pass, pubkeyPath string, nonInteractive, useKeyring bool) error {
+// nexusAuth holds the Nexus credentials given on the command line.
+type nexusAuth struct {
+	User      string
+	Password  string
+	Token     string
+	UserToken string
+}
+
+type RepoRefreshCmd struct {
+	RepoID string `required:"" help:"Repository ID."`
+	AppID  string `help:"Only refresh the channels of this application."`
+	TTL    string `name:"ttl" help:"Manifest lifetime, e.g. 30d or 720h (default: ITRUST_MANIFEST_TTL)."`
+	Force  bool   `help:"Also refresh cosigned manifests, dropping their cosignatures."`
+}
+
+func (c *RepoRefreshCmd) Run(g *Globals) error {
+	return handleRepoRefresh(context.Background(), c.RepoID, c.AppID, c.TTL, c.Force, g.NonInteractive, g.UseKeyring)
+}
+
+type RepoRotateKeyCmd struct {
+	RepoID string `required:"" help:"Repository ID."`
+}
+
+func (c *RepoRotateKeyCmd) Run(g *Globals) error {
+	return handleRepoRotateKey(context.Background(), c.RepoID, g.NonInteractive, g.UseKeyring)
+}
+
+type RepoRevokeKeyCmd struct {
+	RepoID      string `required:"" help:"Repository ID."`
+	Fingerprint string `required:"" help:"SHA256 fingerprint of the key to revoke."`
+	KeyID       string `help:"Key ID the revoked key signed with, for reference."`
+	Reason      string `help:"Reason recorded in the revocation list."`
+}
+
+func (c *RepoRevokeKeyCmd) Run(g *Globals) error {
+	return handleRepoRevokeKey(context.Background(), c.RepoID, c.Fingerprint, c.KeyID, c.Reason, g.NonInteractive, g.UseKeyring)
+}
+
+func handleRepoInit(ctx context.Context, repoID, baseURL, backendType string, auth nexusAuth, pubkeyPath string, nonInteractive, useKeyring bool) error {
 	logger.Infof("Initializing repository %s at %s", repoID, baseURL)
-	if pass == "" && !nonInteractive {
-		var err error
-		pass, err = support.ReadPassword(fmt.Sprintf("Enter password for %s: ", user))
-		if err != nil {
-			return fmt.Errorf("failed to read password: %w", err)
+	cfg := config.GetEnvConfig()
+	cfg["ITRUST_REPO_ID"] = repoID
+	cfg["ITRUST_BASE_URL"] = baseURL
+	cfg["ITRUST_BACKEND"] = backendType
+	for key, value := range map[string]string{
+		"ITRUST_NEXUS_USERNAME":   auth.User,
+		"ITRUST_NEXUS_PASSWORD":   auth.Password,
+		"ITRUST_NEXUS_TOKEN":      auth.Token,
+		"ITRUST_NEXUS_USER_TOKEN": auth.UserToken,
+	} {
+		if value != "" {
+			cfg[key] = value
 		}
 	}
-	if pass == "" && !nonInteractive {
-		return fmt.Errorf("password is required")
+
+	b, err := support.OpenBackend(cfg, nonInteractive, false)
+	if err != nil {
+		return fmt.Errorf("failed to open backend: %w", err)
 	}
 
 	seed := make([]byte, 32)
@@ -89,7 +146,6 @@ func handleRepoInit(ctx context.Context, repoID, baseURL, user, pass, pubkeyPath
 	pubKeySha := sign.SHA256(pubKey)
 
 	logger.Infof("Uploading public key to %s", pubkeyPath)
-	b := backend.NewNexusBackend(baseURL, user, pass)
 	openPubKey := func() (io.ReadCloser, error) {
 		return io.NopCloser(strings.NewReader(string(pubKey))), nil
 	}
@@ -100,6 +156,7 @@ func handleRepoInit(ctx context.Context, repoID, baseURL, user, pass, pubkeyPath
 	rc := &repo.RepoConfig{
 		RepoID:       repoID,
 		BaseURL:      baseURL,
+		Backend:      backend.TypeOf(cfg),
 		PubkeyPath:   pubkeyPath,
 		PubkeySha256: pubKeySha,
 	}
@@ -112,8 +169,14 @@ func handleRepoInit(ctx context.Context, repoID, baseURL, user, pass, pubkeyPath
 	if useKeyring {
 		logger.Debug("Storing repository secrets in keyring")
 		ss := &secrets.KeyringSecretStore{}
-		_ = ss.Set("itrust-updater", "nexus:"+repoID+":username", user)
-		_ = ss.Set("itrust-updater", "nexus:"+repoID+":password", pass)
+		if token := cfg.Get("ITRUST_NEXUS_TOKEN", ""); token != "" {
+			_ = ss.Set("itrust-updater", "nexus:"+repoID+":token", token)
+		} else if userToken := cfg.Get("ITRUST_NEXUS_USER_TOKEN", ""); userToken != "" {
+			_ = ss.Set("itrust-updater", "nexus:"+repoID+":user-token", userToken)
+		} else if user := cfg.Get("ITRUST_NEXUS_USERNAME", ""); user != "" {
+			_ = ss.Set("itrust-updater", "nexus:"+repoID+":username", user)
+			_ = ss.Set("itrust-updater", "nexus:"+repoID+":password", cfg.Get("ITRUST_NEXUS_PASSWORD", ""))
+		}
 		_ = ss.Set("itrust-updater", "signing:"+repoID+":ed25519-seed-b64", seedB64)
 		fmt.Println("Secrets stored in keyring.")
 	} else {
@@ -125,6 +188,7 @@ func handleRepoInit(ctx context.Context, repoID, baseURL, user, pass, pubkeyPath
 	fmt.Println("-------------------------------------------")
 	fmt.Printf("ITRUST_REPO_ID=%s\n", repoID)
 	fmt.Printf("ITRUST_BASE_URL=%s\n", baseURL)
+	fmt.Printf("ITRUST_BACKEND=%s\n", rc.Backend)
 	fmt.Printf("ITRUST_REPO_PUBKEY_SHA256=%s\n", pubKeySha)
 	fmt.Printf("ITRUST_REPO_PUBKEY_PATH=%s\n", pubkeyPath)
 	fmt.Println("-------------------------------------------")
@@ -175,6 +239,12 @@ func handleRepoExport(repoID string, includeSeed, includeNexus bool, outPath str
 			if pass != "" {
 				sb.WriteString(fmt.Sprintf("ITRUST_NEXUS_PASSWORD=%s\n", pass))
 			}
+			if token, _ := ss.Get("itrust-updater", "nexus:"+repoID+":token"); token != "" {
+				sb.WriteString(fmt.Sprintf("ITRUST_NEXUS_TOKEN=%s\n", token))
+			}
+			if userToken, _ := ss.Get("itrust-updater", "nexus:"+repoID+":user-token"); userToken != "" {
+				sb.WriteString(fmt.Sprintf("ITRUST_NEXUS_USER_TOKEN=%s\n", userToken))
+			}
 		}
 	}
 
@@ -217,6 +287,7 @@ func handleRepoImport(inPath string, writeConfig, useKeyring bool) error {
 		rc := &repo.RepoConfig{
 			RepoID:       repoID,
 			BaseURL:      cfg.Get("ITRUST_BASE_URL", ""),
+			Backend:      cfg.Get("ITRUST_BACKEND", ""),
 			PubkeyPath:   cfg.Get("ITRUST_REPO_PUBKEY_PATH", "repo/public-keys/ed25519.pub"),
 			PubkeySha256: cfg.Get("ITRUST_REPO_PUBKEY_SHA256", ""),
 		}
@@ -245,8 +316,239 @@ func handleRepoImport(inPath string, writeConfig, useKeyring bool) error {
 			}
 			fmt.Println("Nexus credentials imported to keyring.")
 		}
+		if token := cfg.Get("ITRUST_NEXUS_TOKEN", ""); token != "" {
+			_ = ss.Set("itrust-updater", "nexus:"+repoID+":token", token)
+			fmt.Println("Nexus token imported to keyring.")
+		}
+		if userToken := cfg.Get("ITRUST_NEXUS_USER_TOKEN", ""); userToken != "" {
+			_ = ss.Set("itrust-updater", "nexus:"+repoID+":user-token", userToken)
+			fmt.Println("Nexus user token imported to keyring.")
+		}
 	} else {
 		fmt.Println("Keyring not enabled, secrets not imported.")
 	}
 	return nil
 }
+
+func handleRepoRefresh(ctx context.Context, repoID, appID, ttlFlag string, force, nonInteractive, useKeyring bool) error {
+	logger.Infof("Refreshing channel manifests of repository %s", repoID)
+	cfg := config.GetEnvConfig()
+	cfg["ITRUST_REPO_ID"] = repoID
+	support.OverlayRepoConfig(cfg, support.GetDefaultConfigDir())
+
+	if ttlFlag == "" {
+		ttlFlag = cfg.Get("ITRUST_MANIFEST_TTL", "")
+	}
+	ttl, err := support.ParseTTL(ttlFlag)
+	if err != nil {
+		return err
+	}
+	if ttl == 0 {
+		return fmt.Errorf("manifest TTL is required (--ttl or ITRUST_MANIFEST_TTL)")
+	}
+
+	seed, err := support.ResolveSigningSeed(cfg, repoID, useKeyring)
+	if err != nil {
+		return err
+	}
+	if expected := cfg.Get("ITRUST_REPO_PUBKEY_SHA256", ""); expected != "" {
+		pubKey, err := sign.SeedToPubKey(seed)
+		if err != nil {
+			return fmt.Errorf("failed to derive public key: %w", err)
+		}
+		if err := sign.VerifyFingerprint(pubKey, expected); err != nil {
+			return fmt.Errorf("signing seed does not match the repository key: %w", err)
+		}
+	}
+
+	b, err := support.OpenBackend(cfg, nonInteractive, useKeyring)
+	if err != nil {
+		return fmt.Errorf("failed to open backend: %w", err)
+	}
+	if err := support.CheckSigningSeed(ctx, b, cfg.Get("ITRUST_REPO_PUBKEY_PATH", "repo/public-keys/ed25519.pub"), seed); err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().UTC().Add(ttl)
+	refreshed, err := support.RefreshChannelManifests(ctx, b, appID, seed, expiresAt, force)
+	for _, path := range refreshed {
+		fmt.Printf("Refreshed %s\n", path)
+	}
+	if err != nil {
+		return fmt.Errorf("refresh failed: %w", err)
+	}
+	fmt.Printf("%d channel manifest(s) now expire at %s\n", len(refreshed), expiresAt.Format(time.RFC3339))
+	return nil
+}
+
+func handleRepoRotateKey(ctx context.Context, repoID string, nonInteractive, useKeyring bool) error {
+	logger.Infof("Rotating signing key of repository %s", repoID)
+	configDir := support.GetDefaultConfigDir()
+	cfg := config.GetEnvConfig()
+	cfg["ITRUST_REPO_ID"] = repoID
+	support.OverlayRepoConfig(cfg, configDir)
+	pubkeyPath := cfg.Get("ITRUST_REPO_PUBKEY_PATH", "repo/public-keys/ed25519.pub")
+
+	oldSeed, err := support.ResolveSigningSeed(cfg, repoID, useKeyring)
+	if err != nil {
+		return err
+	}
+	oldPubKey, err := sign.SeedToPubKey(oldSeed)
+	if err != nil {
+		return fmt.Errorf("failed to derive public key: %w", err)
+	}
+	oldFp := sign.SHA256(oldPubKey)
+
+	b, err := support.OpenBackend(cfg, nonInteractive, useKeyring)
+	if err != nil {
+		return fmt.Errorf("failed to open backend: %w", err)
+	}
+	current, err := b.Get(ctx, pubkeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to get repository public key: %w", err)
+	}
+	currentKey, err := io.ReadAll(current)
+	current.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read repository public key: %w", err)
+	}
+
+	// A rotation interrupted earlier is resumed with its pending seed.
+	var ss secrets.SecretStore
+	pendingName := "signing:" + repoID + ":pending-ed25519-seed-b64"
+	newSeed := cfg.Get("ITRUST_REPO_PENDING_SIGNING_ED25519_SEED_B64", os.Getenv("ITRUST_REPO_PENDING_SIGNING_ED25519_SEED_B64"))
+	if useKeyring {
+		ss = &secrets.KeyringSecretStore{}
+		if newSeed == "" {
+			newSeed, _ = ss.Get("itrust-updater", pendingName)
+		}
+	}
+	activated := false
+	if newSeed != "" {
+		newPubKey, err := sign.SeedToPubKey(newSeed)
+		if err != nil {
+			return fmt.Errorf("invalid pending signing seed: %w", err)
+		}
+		activated = sign.SHA256(currentKey) == sign.SHA256(newPubKey)
+		logger.Infof("Resuming rotation to key %s", sign.SHA256(newPubKey))
+	}
+	if !activated {
+		if err := sign.VerifyFingerprint(currentKey, oldFp); err != nil {
+			return fmt.Errorf("signing seed does not belong to the current repository key: %w", err)
+		}
+	}
+
+	if newSeed == "" {
+		seed := make([]byte, 32)
+		if _, err := rand.Read(seed); err != nil {
+			return fmt.Errorf("failed to generate seed: %w", err)
+		}
+		newSeed = base64.StdEncoding.EncodeToString(seed)
+
+		// Keep both seeds safe before anything is published with the new one.
+		// The new seed stays pending until the rotation is complete.
+		if useKeyring {
+			if err := ss.Set("itrust-updater", "signing:"+repoID+":retired:"+oldFp, oldSeed); err != nil {
+				return fmt.Errorf("failed to store retired signing seed: %w", err)
+			}
+			if err := ss.Set("itrust-updater", pendingName, newSeed); err != nil {
+				return fmt.Errorf("failed to store new signing seed: %w", err)
+			}
+		} else {
+			fmt.Printf("\nIMPORTANT: Store the new signing seed securely (it will NOT be saved to disk):\n%s\n", newSeed)
+			fmt.Printf("Once the rotation completes, replace ITRUST_REPO_SIGNING_ED25519_SEED_B64 with it wherever you push from (CI included).\n")
+			fmt.Printf("If the rotation fails, set ITRUST_REPO_PENDING_SIGNING_ED25519_SEED_B64 to it and run the command again.\n\n")
+		}
+	}
+	newPubKey, err := sign.SeedToPubKey(newSeed)
+	if err != nil {
+		return fmt.Errorf("failed to derive public key: %w", err)
+	}
+	newFp := sign.SHA256(newPubKey)
+	resume := "run the command again to resume"
+
+	// Clients keep verifying with the old key until it is replaced, so every
+	// manifest carries both signatures by then.
+	if !activated {
+		if _, err := trust.PublishTransition(ctx, b, repoID, pubkeyPath, oldSeed, newPubKey, support.SigningKeyID()); err != nil {
+			return fmt.Errorf("failed to publish new key, %s: %w", resume, err)
+		}
+		cosigned, err := support.AddRotationSignatures(ctx, b, oldPubKey, newSeed)
+		fmt.Printf("Added the new key's signature to %d manifest(s).\n", len(cosigned))
+		if err != nil {
+			return fmt.Errorf("signing manifests with the new key failed, %s: %w", resume, err)
+		}
+	}
+	if err := trust.ActivateKey(ctx, b, repoID, pubkeyPath, oldPubKey, newSeed, support.SigningKeyID()); err != nil {
+		return fmt.Errorf("failed to activate new key %s, %s: %w", newFp, resume, err)
+	}
+
+	resigned, err := support.ResignManifests(ctx, b, oldPubKey, newSeed)
+	fmt.Printf("Re-signed %d manifest(s) with the new key.\n", len(resigned))
+	if err != nil {
+		return fmt.Errorf("new key %s is published but re-signing manifests failed, %s: %w", newFp, resume, err)
+	}
+
+	if useKeyring {
+		if err := ss.Set("itrust-updater", "signing:"+repoID+":ed25519-seed-b64", newSeed); err != nil {
+			return fmt.Errorf("failed to store new signing seed, %s: %w", resume, err)
+		}
+		if err := ss.Delete("itrust-updater", pendingName); err != nil {
+			logger.Warnf("Failed to remove pending signing seed: %v", err)
+		}
+	}
+
+	repoConfigPath := repo.GetRepoConfigPath(configDir, repoID)
+	_, pinErr := config.ReplaceValue(repoConfigPath, "ITRUST_REPO_PUBKEY_SHA256", oldFp, newFp)
+
+	fmt.Printf("\nRepository %s signing key rotated.\n", repoID)
+	fmt.Printf("Old fingerprint: %s\n", oldFp)
+	fmt.Printf("New fingerprint: %s\n", newFp)
+	fmt.Println("Clients pinned to the old key follow the signed transition and update their pin automatically.")
+	// The seed from the environment takes precedence over the keyring, so it
+	// must be replaced as well; the old seed can no longer sign anything.
+	if !useKeyring || cfg.Get("ITRUST_REPO_SIGNING_ED25519_SEED_B64", os.Getenv("ITRUST_REPO_SIGNING_ED25519_SEED_B64")) != "" {
+		fmt.Println("\nIMPORTANT: Replace ITRUST_REPO_SIGNING_ED25519_SEED_B64 with the new seed wherever you push from (CI included).")
+		fmt.Println("Pushes signed with the old seed are refused.")
+	}
+	if pinErr != nil {
+		return fmt.Errorf("failed to update the pinned key in %s, set ITRUST_REPO_PUBKEY_SHA256=%s there manually: %w", repoConfigPath, newFp, pinErr)
+	}
+	return nil
+}
+
+func handleRepoRevokeKey(ctx context.Context, repoID, fingerprint, keyID, reason string, nonInteractive, useKeyring bool) error {
+	fingerprint = strings.ToLower(strings.TrimSpace(fingerprint))
+	if _, err := hex.DecodeString(fingerprint); err != nil || len(fingerprint) != 64 {
+		return fmt.Errorf("invalid key fingerprint %q (expected 64 hex characters)", fingerprint)
+	}
+	logger.Infof("Revoking key %s of repository %s", fingerprint, repoID)
+	configDir := support.GetDefaultConfigDir()
+	cfg := config.GetEnvConfig()
+	cfg["ITRUST_REPO_ID"] = repoID
+	support.OverlayRepoConfig(cfg, configDir)
+	pubkeyPath := cfg.Get("ITRUST_REPO_PUBKEY_PATH", "repo/public-keys/ed25519.pub")
+
+	seed, err := support.ResolveSigningSeed(cfg, repoID, useKeyring)
+	if err != nil {
+		return err
+	}
+	b, err := support.OpenBackend(cfg, nonInteractive, useKeyring)
+	if err != nil {
+		return fmt.Errorf("failed to open backend: %w", err)
+	}
+
+	l, err := trust.PublishRevocation(ctx, b, repoID, pubkeyPath, seed, support.SigningKeyID(), trust.RevokedKey{
+		Fingerprint: fingerprint,
+		KeyID:       keyID,
+		RevokedAt:   time.Now().UTC(),
+		Reason:      reason,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish revocation: %w", err)
+	}
+
+	fmt.Printf("Key %s revoked in repository %s (%d revoked key(s) in %s).\n", fingerprint, repoID, len(l.Payload.Keys), trust.RevocationPath(pubkeyPath))
+	fmt.Println("Clients no longer accept signatures of this key; manifests signed only by it must be re-signed.")
+	return nil
+}
@@ -0,0 +1,315 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alapierre/itrust-updater/internal/support"
+	"github.com/alapierre/itrust-updater/pkg/config"
+	"github.com/alapierre/itrust-updater/pkg/install"
+	"github.com/alapierre/itrust-updater/pkg/progress"
+)
+
+type RollbackCmd struct {
+	Profile   string `arg:"" help:"Profile name."`
+	To        string `help:"Backup to restore, by ID (timestamp) or version (default: the most recent backup, or the previous version of a versioned install)."`
+	ConfigDir string `help:"Override configuration directory."`
+	StateDir  string `help:"Override state directory."`
+}
+
+func (c *RollbackCmd) Run(g *Globals) error {
+	return handleRollback(c.Profile, c.To, c.ConfigDir, c.StateDir)
+}
+
+type BackupsCmd struct {
+	List  BackupsListCmd  `cmd:"" help:"List the backups of a profile."`
+	Prune BackupsPruneCmd `cmd:"" help:"Remove the backups of a profile beyond its retention limits."`
+}
+
+type BackupsListCmd struct {
+	Profile   string `arg:"" help:"Profile name."`
+	ConfigDir string `help:"Override configuration directory."`
+	StateDir  string `help:"Override state directory."`
+}
+
+func (c *BackupsListCmd) Run(g *Globals) error {
+	return handleBackupsList(c.Profile, c.ConfigDir, c.StateDir)
+}
+
+type BackupsPruneCmd struct {
+	Profile   string `arg:"" help:"Profile name."`
+	Keep      string `help:"Number of most recent backups to keep (default: ITRUST_BACKUP_KEEP)."`
+	MaxAge    string `help:"Remove backups older than this, e.g. 30d (default: ITRUST_BACKUP_MAX_AGE)."`
+	MaxSize   string `help:"Total size of the backups kept, e.g. 1G (default: ITRUST_BACKUP_MAX_SIZE)."`
+	DryRun    bool   `help:"Only show which backups would be removed."`
+	ConfigDir string `help:"Override configuration directory."`
+	StateDir  string `help:"Override state directory."`
+}
+
+func (c *BackupsPruneCmd) Run(g *Globals) error {
+	return handleBackupsPrune(c.Profile, c.Keep, c.MaxAge, c.MaxSize, c.DryRun, c.ConfigDir, c.StateDir)
+}
+
+func handleRollback(profile, to, customConfigDir, customStateDir string) error {
+	configDir, stateDir := support.GetPaths(customConfigDir, customStateDir)
+	logger.Infof("Rolling back profile %s to %q", profile, to)
+
+	st, err := install.LoadState(stateDir, profile)
+	if err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+	if st == nil {
+		return fmt.Errorf("profile %s is not installed", profile)
+	}
+	if st.InstallRoot != "" {
+		return switchVersion(stateDir, profile, st, to)
+	}
+
+	retention, err := support.BackupRetentionFromConfig(support.LoadConfigWithRepoOverlay(configDir, profile))
+	if err != nil {
+		return err
+	}
+	backups, err := install.ListBackups(stateDir, profile)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+	backup, err := install.FindBackup(backups, to)
+	if err != nil {
+		return err
+	}
+	if backup.Dest != "" && backup.Dest != st.Dest {
+		logger.Warnf("Backup %s was taken from %s, restoring it to %s", backup.ID, backup.Dest, st.Dest)
+	}
+
+	sha, err := install.RestoreBackup(backup, st.Dest, stateDir, profile, !retention.Disabled)
+	if err != nil {
+		return fmt.Errorf("rollback failed: %w", err)
+	}
+
+	// The rollback protection marks are kept: they guard what get accepts
+	// from the repository, not what is restored locally.
+	newState := *st
+	newState.InstalledVersion = backup.Version
+	if newState.InstalledVersion == "" {
+		newState.InstalledVersion = "unknown"
+	}
+	newState.InstalledSha256 = sha
+	newState.ContentSha256 = ""
+	if fi, err := os.Stat(st.Dest); err == nil && fi.IsDir() {
+		// A restored archive install is identified by its tree.
+		newState.ContentSha256 = sha
+	}
+	newState.InstalledAt = time.Now().UTC()
+	newState.SourceURL = backup.SourceURL
+	newState.SignedBy = backup.SignedBy
+	if err := install.SaveState(stateDir, profile, &newState); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+
+	fmt.Printf("Restored %s version %s from backup %s to %s\n", st.AppID, newState.InstalledVersion, backup.ID, st.Dest)
+	logger.Infof("Restored %s version %s from backup %s", st.AppID, newState.InstalledVersion, backup.ID)
+	pruneBackups(stateDir, profile, retention, backup.ID)
+	return nil
+}
+
+// switchVersion rolls a versioned install back by pointing it at another
+// installed version, by default the one before the current.
+func switchVersion(stateDir, profile string, st *install.State, to string) error {
+	var err error
+	if to == "" {
+		if to, err = install.PreviousVersion(st.InstallRoot); err != nil {
+			return err
+		}
+	}
+	to = strings.TrimPrefix(to, "v")
+	vs, err := install.LoadVersionState(st.InstallRoot, to)
+	if err != nil {
+		return fmt.Errorf("rollback failed: %w", err)
+	}
+	if err := install.SwitchCurrent(st.InstallRoot, to); err != nil {
+		return fmt.Errorf("rollback failed: %w", err)
+	}
+
+	// As with backups, the rollback protection marks are kept.
+	newState := *vs
+	newState.InstalledAt = time.Now().UTC()
+	newState.HighestVersion = st.HighestVersion
+	newState.NewestGeneratedAt = st.NewestGeneratedAt
+	newState.RevocationsGeneratedAt = st.RevocationsGeneratedAt
+	newState.IndexGeneratedAt = st.IndexGeneratedAt
+	if err := install.SaveState(stateDir, profile, &newState); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+
+	fmt.Printf("Switched %s to version %s in %s\n", st.AppID, to, st.InstallRoot)
+	logger.Infof("Switched %s to version %s in %s", st.AppID, to, st.InstallRoot)
+	return nil
+}
+
+func handleBackupsList(profile, customConfigDir, customStateDir string) error {
+	_, stateDir := support.GetPaths(customConfigDir, customStateDir)
+	if st, _ := install.LoadState(stateDir, profile); st != nil && st.InstallRoot != "" {
+		return listVersions(st)
+	}
+	backups, err := install.ListBackups(stateDir, profile)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+	if len(backups) == 0 {
+		fmt.Printf("No backups for profile %s.\n", profile)
+		return nil
+	}
+	st, _ := install.LoadState(stateDir, profile)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tVERSION\tCREATED\tSIZE\tSHA256\t")
+	for _, b := range backups {
+		version := backupVersion(b)
+		note := ""
+		if st != nil && b.Sha256 == st.InstalledSha256 {
+			note = "(installed)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", b.ID, version, b.CreatedAt.Local().Format(time.RFC3339), progress.FormatBytes(b.Size), shortSha(b.Sha256), note)
+	}
+	return w.Flush()
+}
+
+// listVersions lists the versions of a versioned install, which take the
+// place of backups.
+func listVersions(st *install.State) error {
+	versions, err := install.ListVersions(st.InstallRoot)
+	if err != nil {
+		return fmt.Errorf("failed to list versions: %w", err)
+	}
+	current, _ := install.CurrentVersion(st.InstallRoot)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tINSTALLED\tSHA256\t")
+	for _, v := range versions {
+		installed, sha := "unknown", "unknown"
+		if vs, err := install.LoadVersionState(st.InstallRoot, v); err == nil {
+			installed = vs.InstalledAt.Local().Format(time.RFC3339)
+			sha = shortSha(vs.InstalledSha256)
+		} else {
+			logger.Warnf("Version %s: %v", v, err)
+		}
+		note := ""
+		if v == current {
+			note = "(current)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v, installed, sha, note)
+	}
+	return w.Flush()
+}
+
+func handleBackupsPrune(profile, keep, maxAge, maxSize string, dryRun bool, customConfigDir, customStateDir string) error {
+	configDir, stateDir := support.GetPaths(customConfigDir, customStateDir)
+
+	cfg := support.LoadConfigWithRepoOverlay(configDir, profile)
+	if st, _ := install.LoadState(stateDir, profile); st != nil && st.InstallRoot != "" {
+		return pruneVersionsCmd(cfg, st.InstallRoot, keep, dryRun)
+	}
+	for key, v := range map[string]string{"ITRUST_BACKUP_KEEP": keep, "ITRUST_BACKUP_MAX_AGE": maxAge, "ITRUST_BACKUP_MAX_SIZE": maxSize} {
+		if v != "" {
+			cfg[key] = v
+		}
+	}
+	retention, err := support.BackupRetentionFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if retention.IsZero() {
+		return fmt.Errorf("no retention limit set (--keep, --max-age, --max-size or ITRUST_BACKUP_KEEP, ITRUST_BACKUP_MAX_AGE, ITRUST_BACKUP_MAX_SIZE)")
+	}
+
+	pruned, err := install.PruneBackups(stateDir, profile, retention, "", dryRun)
+	action := "Removed"
+	if dryRun {
+		action = "Would remove"
+	}
+	var freed int64
+	for _, b := range pruned {
+		freed += b.Size
+		fmt.Printf("%s backup %s (version %s, %s)\n", action, b.ID, backupVersion(b), progress.FormatBytes(b.Size))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to prune backups: %w", err)
+	}
+	fmt.Printf("%s %d backup(s) of %s, %s.\n", action, len(pruned), profile, progress.FormatBytes(freed))
+	return nil
+}
+
+// pruneVersionsCmd prunes a versioned install, whose old versions take the
+// place of backups; only the number of versions kept applies.
+func pruneVersionsCmd(cfg config.Config, root, keep string, dryRun bool) error {
+	if keep != "" {
+		cfg["ITRUST_VERSIONS_KEEP"] = keep
+	}
+	_, n, err := support.VersionedInstallFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("no retention limit set (--keep or ITRUST_VERSIONS_KEEP)")
+	}
+
+	pruned, err := install.PruneVersions(root, n, dryRun)
+	action := "Removed"
+	if dryRun {
+		action = "Would remove"
+	}
+	for _, v := range pruned {
+		fmt.Printf("%s version %s\n", action, v)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to prune versions: %w", err)
+	}
+	fmt.Printf("%s %d version(s) from %s.\n", action, len(pruned), root)
+	return nil
+}
+
+// pruneVersions removes the old versions of a versioned install beyond keep;
+// a failure is not fatal.
+func pruneVersions(root string, keep int) {
+	pruned, err := install.PruneVersions(root, keep, false)
+	if err != nil {
+		logger.Warnf("Failed to prune versions: %v", err)
+	}
+	if len(pruned) > 0 {
+		logger.Infof("Removed old version(s) %s from %s", strings.Join(pruned, ", "), root)
+	}
+}
+
+// pruneBackups applies the retention policy of profile after an install or a
+// rollback, keeping the backup keepID; a failure is not fatal.
+func pruneBackups(stateDir, profile string, r install.Retention, keepID string) {
+	pruned, err := install.PruneBackups(stateDir, profile, r, keepID, false)
+	if err != nil {
+		logger.Warnf("Failed to prune backups: %v", err)
+	}
+	if len(pruned) > 0 {
+		logger.Infof("Pruned %d backup(s) of %s", len(pruned), profile)
+	}
+}
+
+func backupVersion(b install.Backup) string {
+	if b.Version == "" {
+		return "unknown"
+	}
+	return b.Version
+}
+
+// shortSha abbreviates a SHA256 for listings; records written by hand or by
+// older releases may hold a shorter value or none.
+func shortSha(sha string) string {
+	if sha == "" {
+		return "unknown"
+	}
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
@@ -26,9 +26,14 @@ type CLI struct {
 	Init     InitCmd     `cmd:"" help:"Initialize a new profile."`
 	Get      GetCmd      `cmd:"" help:"Install or update an application."`
 	Status   StatusCmd   `cmd:"" help:"Show installation status."`
+	Rollback RollbackCmd `cmd:"" help:"Restore a previous installation from a backup."`
+	Backups  BackupsCmd  `cmd:"" help:"Backup management."`
 	Push     PushCmd     `cmd:"" help:"Publish a new release (publisher mode)."`
+	Promote  PromoteCmd  `cmd:"" help:"Move a published release to another channel (publisher mode)."`
+	Yank     YankCmd     `cmd:"" help:"Withdraw a broken release and roll its channels back (publisher mode)."`
 	Manifest ManifestCmd `cmd:"" help:"Manifest utilities."`
 	Repo     RepoCmd     `cmd:"" help:"Repository management."`
+	Releases ReleasesCmd `cmd:"" help:"Release history."`
 	Version  VersionCmd  `cmd:"" help:"Show application version."`
 }
 
@@ -3,14 +3,15 @@ package cli
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"time"
 
 	"github.com/alapierre/itrust-updater/internal/support"
 	"github.com/alapierre/itrust-updater/pkg/backend"
 	"github.com/alapierre/itrust-updater/pkg/install"
-	"github.com/alapierre/itrust-updater/pkg/secrets"
-	"github.com/zalando/go-keyring"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/trust"
 )
 
 type StatusCmd struct {
@@ -27,12 +28,11 @@ func handleStatus(ctx context.Context, profile string, nonInteractive, useKeyrin
 
 	cfg := support.LoadConfigWithRepoOverlay(configDir, profile)
 
-	repoID := cfg.Get("ITRUST_REPO_ID", "")
 	baseURL := cfg.Get("ITRUST_BASE_URL", "")
+	repoID := cfg.Get("ITRUST_REPO_ID", "")
 	appId := cfg.Get("ITRUST_APP_ID", "")
 	channel := cfg.Get("ITRUST_CHANNEL", "stable")
 	expectedPubkeySha := cfg.Get("ITRUST_REPO_PUBKEY_SHA256", "")
-	backendType := cfg.Get("ITRUST_BACKEND", "nexus")
 	pubkeyPath := cfg.Get("ITRUST_REPO_PUBKEY_PATH", "repo/public-keys/ed25519.pub")
 
 	st, err := install.LoadState(stateDir, profile)
@@ -49,6 +49,9 @@ func handleStatus(ctx context.Context, profile string, nonInteractive, useKeyrin
 		fmt.Printf("Channel:           %s\n", st.Channel)
 		fmt.Printf("Installed Version: %s\n", st.InstalledVersion)
 		fmt.Printf("Installed At:      %s\n", st.InstalledAt.Local().Format(time.RFC3339))
+		if st.HighestVersion != "" && st.HighestVersion != st.InstalledVersion {
+			fmt.Printf("Highest Version:   %s\n", st.HighestVersion)
+		}
 		fmt.Printf("Destination:       %s\n", st.Dest)
 	}
 
@@ -58,69 +61,85 @@ func handleStatus(ctx context.Context, profile string, nonInteractive, useKeyrin
 		return nil
 	}
 
-	username := cfg.Get("ITRUST_NEXUS_USERNAME", "")
-	password := os.Getenv("ITRUST_NEXUS_PASSWORD")
-
-	if password == "" && useKeyring && repoID != "" {
-		logger.Debug("Attempting to get credentials from keyring")
-		ss := &secrets.KeyringSecretStore{}
-		if username == "" {
-			username, _ = ss.Get("itrust-updater", "nexus:"+repoID+":username")
-		}
-		password, _ = ss.Get("itrust-updater", "nexus:"+repoID+":password")
-	}
-
-	// Backward compatibility for non-multi-repo keyring
-	if password == "" && useKeyring && username != "" {
-		logger.Debug("Attempting to get credentials from keyring (fallback)")
-		password, _ = keyring.Get("itrust-updater", username)
-	}
-
-	if password == "" && !nonInteractive {
-		if username == "" {
-			fmt.Print("Enter Nexus username: ")
-			fmt.Scanln(&username)
-		}
-		if username != "" {
-			var err error
-			password, err = support.ReadPassword(fmt.Sprintf("Enter Nexus password for %s: ", username))
-			if err != nil {
-				fmt.Printf("Latest Version:    unverified (failed to read password: %v)\n", err)
-				logger.Errorf("Failed to read password: %v", err)
-				return nil
-			}
-		}
-	}
-
-	if password == "" && username != "" && nonInteractive {
-		fmt.Println("Latest Version:    unverified (missing credentials)")
-		logger.Debug("Missing credentials in non-interactive mode")
+	policy, err := support.SignaturePolicyFromConfig(cfg)
+	if err != nil {
+		fmt.Printf("Latest Version:    unverified (%v)\n", err)
 		return nil
 	}
 
-	var b backend.Backend
-	if backendType == "nexus" {
-		b = backend.NewNexusBackend(baseURL, username, password)
-	} else {
-		logger.Errorf("Unsupported backend: %s", backendType)
+	b, err := support.OpenBackend(cfg, nonInteractive, useKeyring)
+	if err != nil {
+		fmt.Printf("Latest Version:    unverified (%v)\n", err)
+		logger.Errorf("Failed to open backend: %v", err)
 		return nil
 	}
 
 	logger.Infof("Fetching manifest to check for updates")
-	m, _, err := support.FetchAndVerifyManifest(ctx, b, appId, channel, "", pubkeyPath, expectedPubkeySha)
+	m, verification, err := support.FetchAndVerifyManifest(ctx, b, repoID, appId, channel, "", pubkeyPath, expectedPubkeySha, policy)
 	if err != nil {
 		fmt.Printf("Latest Version:    unverified (%v)\n", err)
 		logger.Errorf("Failed to fetch/verify manifest: %v", err)
 		return nil
 	}
+	support.PersistKeyRotation(configDir, profile, repoID, expectedPubkeySha, verification.RepoKey)
 
 	fmt.Printf("Latest Version:    %s\n", m.Payload.Latest.Version)
-	if st != nil {
-		if st.InstalledVersion != m.Payload.Latest.Version {
-			fmt.Println("\nUpdate available!")
-		} else {
-			fmt.Println("\nApplication is up to date.")
-		}
+	if !m.Payload.ExpiresAt.IsZero() {
+		fmt.Printf("Manifest Expires:  %s\n", m.Payload.ExpiresAt.Local().Format(time.RFC3339))
 	}
+	reportRemoteStatus(ctx, os.Stdout, b, st, repoID, appId, channel, m, verification)
 	return nil
 }
+
+// reportRemoteStatus compares the installed state with the verified latest
+// manifest and writes the warnings and the update advice to w. A release index
+// that cannot be fetched or verified only disables the yank check; revoked
+// signers and rollbacks are still reported.
+func reportRemoteStatus(ctx context.Context, w io.Writer, b backend.Backend, st *install.State, repoID, appId, channel string, m *manifest.Manifest, verification *support.Verification) {
+	index, err := support.FetchIndex(ctx, b, repoID, appId, verification.RepoKey)
+	if err != nil {
+		fmt.Fprintf(w, "\nWARNING: Cannot check for yanked releases: %v\n", err)
+		logger.Warnf("Cannot check for yanked releases: %v", err)
+		index = nil
+	} else if err := st.CheckIndex(appId, index.GeneratedAt()); err != nil {
+		fmt.Fprintf(w, "\nWARNING: Release index refused as a possible rollback: %v\n", err)
+		logger.Warnf("Release index refused as a possible rollback: %v", err)
+	}
+	if st == nil {
+		return
+	}
+	if y := index.Yanked(st.InstalledVersion); y != nil {
+		advice := "No replacement has been published yet."
+		if latest := m.Payload.Latest.Version; latest != st.InstalledVersion && index.Yanked(latest) == nil {
+			advice = fmt.Sprintf("Run 'get' to move to %s.", latest)
+		}
+		fmt.Fprintf(w, "\nWARNING: Installed version %s was yanked%s. %s\n", st.InstalledVersion, yankReason(y), advice)
+		logger.Warnf("Installed version %s was yanked", st.InstalledVersion)
+	}
+	withdrawYanked(st, index, m.Payload.Latest.Version)
+	if err := st.CheckRevocations(verification.Revocations.GeneratedAt()); err != nil {
+		fmt.Fprintf(w, "\nWARNING: Revocation list refused as a possible rollback: %v\n", err)
+		logger.Warnf("Revocation list refused as a possible rollback: %v", err)
+	}
+	for _, fp := range st.SignedBy {
+		if r := verification.Revocations.Lookup(fp); r != nil {
+			fmt.Fprintf(w, "\nWARNING: Installed version %s was signed by key %s, which has since been revoked%s. Reinstall it with 'get --force'.\n", st.InstalledVersion, fp, revocationReason(r))
+			logger.Warnf("Installed version %s was signed by revoked key %s", st.InstalledVersion, fp)
+		}
+	}
+	if err := st.CheckDowngrade(appId, channel, m.Payload.Latest.Version, m.Payload.GeneratedAt, false); err != nil {
+		fmt.Fprintf(w, "\nWARNING: Latest manifest refused as a possible rollback: %v\n", err)
+		logger.Warnf("Latest manifest refused as a possible rollback: %v", err)
+	} else if st.InstalledVersion != m.Payload.Latest.Version {
+		fmt.Fprintln(w, "\nUpdate available!")
+	} else {
+		fmt.Fprintln(w, "\nApplication is up to date.")
+	}
+}
+
+func revocationReason(r *trust.RevokedKey) string {
+	if r.Reason == "" {
+		return ""
+	}
+	return " (" + r.Reason + ")"
+}
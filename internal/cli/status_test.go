@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alapierre/itrust-updater/internal/support"
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/install"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/trust"
+)
+
+func TestReportRemoteStatusWithoutIndex(t *testing.T) {
+	root := t.TempDir()
+	indexPath := filepath.Join(root, filepath.FromSlash(manifest.IndexPath("app1")))
+	if err := os.MkdirAll(filepath.Dir(indexPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(indexPath, []byte("not an index"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	st := &install.State{AppID: "app1", Channel: "stable", InstalledVersion: "1.0.0", SignedBy: []string{"oldkey"}}
+	m := &manifest.Manifest{Payload: manifest.Payload{Latest: manifest.Release{Version: "1.1.0"}}}
+	verification := &support.Verification{
+		RepoKey: []byte("repo key"),
+		Revocations: &trust.RevocationList{Payload: trust.RevocationPayload{
+			GeneratedAt: time.Now().UTC(),
+			Keys:        []trust.RevokedKey{{Fingerprint: "oldkey", Reason: "leaked"}},
+		}},
+	}
+
+	var out bytes.Buffer
+	reportRemoteStatus(context.Background(), &out, backend.NewFileBackend(root), st, "repo1", "app1", "stable", m, verification)
+
+	for _, want := range []string{
+		"Cannot check for yanked releases",
+		"signed by key oldkey, which has since been revoked (leaked)",
+		"Update available!",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestReportRemoteStatusWithDeletedIndex(t *testing.T) {
+	st := &install.State{AppID: "app1", Channel: "stable", InstalledVersion: "1.0.0", IndexGeneratedAt: time.Now().UTC()}
+	m := &manifest.Manifest{Payload: manifest.Payload{Latest: manifest.Release{Version: "1.0.0"}}}
+	verification := &support.Verification{RepoKey: []byte("repo key")}
+
+	var out bytes.Buffer
+	reportRemoteStatus(context.Background(), &out, backend.NewFileBackend(t.TempDir()), st, "repo1", "app1", "stable", m, verification)
+
+	if want := "Release index refused as a possible rollback: release index is missing"; !strings.Contains(out.String(), want) {
+		t.Errorf("Expected output to contain %q, got:\n%s", want, out.String())
+	}
+}
@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alapierre/itrust-updater/internal/support"
+	"github.com/alapierre/itrust-updater/pkg/config"
+	"github.com/alapierre/itrust-updater/pkg/publish"
+)
+
+type YankCmd struct {
+	Config  string `default:"./itrust-updater.project.env" help:"Project configuration file."`
+	RepoID  string `help:"Repository ID."`
+	AppID   string `help:"Application ID."`
+	Version string `required:"" help:"Version to withdraw."`
+	Reason  string `help:"Reason shown to users of the release."`
+	Force   bool   `help:"Roll channels back to a cosigned release even though its cosignatures are dropped."`
+}
+
+func (c *YankCmd) Run(g *Globals) error {
+	return handleYank(context.Background(), c.Config, c.RepoID, c.AppID, c.Version, c.Reason, c.Force, g.NonInteractive, g.UseKeyring)
+}
+
+func handleYank(ctx context.Context, configPath, repoIDFlag, appIDFlag, version, reason string, force, nonInteractive, useKeyring bool) error {
+	cfg, err := support.LoadProjectConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load project config: %w", err)
+	}
+	cfg.Merge(config.GetEnvConfig())
+
+	repoID := repoIDFlag
+	if repoID == "" {
+		repoID = cfg.Get("ITRUST_REPO_ID", "")
+	}
+	if repoID != "" {
+		cfg["ITRUST_REPO_ID"] = repoID
+		support.OverlayRepoConfig(cfg, support.GetDefaultConfigDir())
+	}
+	appID := appIDFlag
+	if appID == "" {
+		appID = cfg.Get("ITRUST_APP_ID", "")
+	}
+	if cfg.Get("ITRUST_BASE_URL", "") == "" || appID == "" {
+		return fmt.Errorf("missing required project configuration (base-url, app-id)")
+	}
+	logger.Infof("Yanking %s version %s", appID, version)
+
+	b, err := support.OpenBackend(cfg, nonInteractive, useKeyring)
+	if err != nil {
+		return fmt.Errorf("failed to open backend: %w", err)
+	}
+	seed, err := support.ResolveSigningSeed(cfg, repoID, useKeyring)
+	if err != nil {
+		return err
+	}
+	if err := support.CheckSigningSeed(ctx, b, cfg.Get("ITRUST_REPO_PUBKEY_PATH", "repo/public-keys/ed25519.pub"), seed); err != nil {
+		return err
+	}
+	ttl, err := support.ParseTTL(cfg.Get("ITRUST_MANIFEST_TTL", ""))
+	if err != nil {
+		return fmt.Errorf("invalid ITRUST_MANIFEST_TTL: %w", err)
+	}
+
+	rollbacks, err := publish.Yank(ctx, b, &publish.Yanking{
+		RepoID:  repoID,
+		AppID:   appID,
+		Version: version,
+		Reason:  reason,
+		Seed:    seed,
+		KeyID:   support.SigningKeyID(),
+		TTL:     ttl,
+		Force:   force,
+	})
+	for _, rb := range rollbacks {
+		if rb.Version == "" {
+			fmt.Printf("WARNING: Channel %s has no earlier release and still serves %s; clients refuse to install it. Publish a fixed version.\n", rb.Channel, version)
+			continue
+		}
+		fmt.Printf("Channel %s rolled back to %s.\n", rb.Channel, rb.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("yank failed: %w", err)
+	}
+	fmt.Printf("Yanked %s version %s.\n", appID, version)
+	return nil
+}
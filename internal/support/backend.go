@@ -0,0 +1,131 @@
+package support
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/config"
+	"github.com/alapierre/itrust-updater/pkg/secrets"
+	"github.com/zalando/go-keyring"
+)
+
+// secretStore holds backend credentials; tests replace it with an in-memory store.
+var secretStore secrets.SecretStore = &secrets.KeyringSecretStore{}
+
+// OpenBackend resolves backend credentials and opens the backend selected by cfg.
+// It is the single setup path used by all commands talking to a repository.
+func OpenBackend(cfg config.Config, nonInteractive, useKeyring bool) (backend.Backend, error) {
+	if err := ResolveCredentials(cfg, nonInteractive, useKeyring); err != nil {
+		return nil, err
+	}
+	return backend.Open(cfg)
+}
+
+// ResolveCredentials fills backend credentials into cfg using the hierarchy
+// ENV/config > OS keyring (for ITRUST_REPO_ID) > interactive prompt. Config
+// files never supply passwords, tokens or secret keys (see fileSecrets).
+func ResolveCredentials(cfg config.Config, nonInteractive, useKeyring bool) error {
+	repoID := cfg.Get("ITRUST_REPO_ID", "")
+	switch backend.TypeOf(cfg) {
+	case "nexus":
+		return resolveNexusCredentials(cfg, repoID, nonInteractive, useKeyring)
+	case "s3":
+		resolveS3Credentials(cfg, repoID, useKeyring)
+	}
+	return nil
+}
+
+func resolveNexusCredentials(cfg config.Config, repoID string, nonInteractive, useKeyring bool) error {
+	if resolveNexusTokens(cfg, repoID, useKeyring) {
+		return nil
+	}
+
+	username := cfg.Get("ITRUST_NEXUS_USERNAME", "")
+	password := cfg.Get("ITRUST_NEXUS_PASSWORD", "")
+
+	if password == "" && useKeyring && repoID != "" {
+		logger.Debug("Attempting to get credentials from keyring for repo")
+		if username == "" {
+			username, _ = secretStore.Get("itrust-updater", "nexus:"+repoID+":username")
+		}
+		password, _ = secretStore.Get("itrust-updater", "nexus:"+repoID+":password")
+	}
+
+	// Backward compatibility for non-multi-repo keyring
+	if password == "" && useKeyring && username != "" {
+		logger.Debug("Attempting to get credentials from keyring (fallback)")
+		password, _ = keyring.Get("itrust-updater", username)
+	}
+
+	if password == "" && !nonInteractive {
+		if username == "" {
+			fmt.Print("Enter Nexus username: ")
+			fmt.Scanln(&username)
+		}
+		if username != "" {
+			var err error
+			password, err = ReadPassword(fmt.Sprintf("Enter Nexus password for %s: ", username))
+			if err != nil {
+				return fmt.Errorf("failed to read password: %w", err)
+			}
+		}
+	}
+
+	if password == "" && username != "" && nonInteractive {
+		return fmt.Errorf("Nexus password is required but not provided (use ITRUST_NEXUS_PASSWORD or init --store-credentials)")
+	}
+
+	if password == "" && username == "" {
+		logger.Debug("No Nexus credentials provided, proceeding without auth")
+	}
+
+	cfg["ITRUST_NEXUS_USERNAME"] = username
+	cfg["ITRUST_NEXUS_PASSWORD"] = password
+	return nil
+}
+
+// resolveNexusTokens looks for token based Nexus authentication (auth command,
+// bearer token or user token) in cfg and then in the keyring entries
+// nexus:<repo>:token and nexus:<repo>:user-token. It reports whether one was
+// found, in which case no password is needed.
+func resolveNexusTokens(cfg config.Config, repoID string, useKeyring bool) bool {
+	if cfg.Get("ITRUST_NEXUS_AUTH_COMMAND", "") != "" ||
+		cfg.Get("ITRUST_NEXUS_TOKEN", "") != "" ||
+		cfg.Get("ITRUST_NEXUS_USER_TOKEN", "") != "" {
+		return true
+	}
+	// A password given explicitly takes precedence over stored tokens.
+	if cfg.Get("ITRUST_NEXUS_PASSWORD", "") != "" || !useKeyring || repoID == "" {
+		return false
+	}
+
+	logger.Debug("Attempting to get Nexus tokens from keyring for repo")
+	if token, _ := secretStore.Get("itrust-updater", "nexus:"+repoID+":token"); token != "" {
+		cfg["ITRUST_NEXUS_TOKEN"] = token
+		return true
+	}
+	if userToken, _ := secretStore.Get("itrust-updater", "nexus:"+repoID+":user-token"); userToken != "" {
+		cfg["ITRUST_NEXUS_USER_TOKEN"] = userToken
+		return true
+	}
+	return false
+}
+
+// resolveS3Credentials falls back to the standard AWS_* variables and the OS
+// keyring. Without credentials requests are sent anonymously.
+func resolveS3Credentials(cfg config.Config, repoID string, useKeyring bool) {
+	accessKey := cfg.Get("ITRUST_S3_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID"))
+	secretKey := cfg.Get("ITRUST_S3_SECRET_ACCESS_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY"))
+	if secretKey == "" && useKeyring && repoID != "" {
+		logger.Debug("Attempting to get S3 credentials from keyring")
+		if accessKey == "" {
+			accessKey, _ = secretStore.Get("itrust-updater", "s3:"+repoID+":access-key-id")
+		}
+		secretKey, _ = secretStore.Get("itrust-updater", "s3:"+repoID+":secret-access-key")
+	}
+
+	cfg["ITRUST_S3_ACCESS_KEY_ID"] = accessKey
+	cfg["ITRUST_S3_SECRET_ACCESS_KEY"] = secretKey
+	cfg["ITRUST_S3_SESSION_TOKEN"] = cfg.Get("ITRUST_S3_SESSION_TOKEN", os.Getenv("AWS_SESSION_TOKEN"))
+}
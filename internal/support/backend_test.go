@@ -0,0 +1,43 @@
+package support
+
+import (
+	"testing"
+
+	"github.com/alapierre/itrust-updater/pkg/config"
+	"github.com/alapierre/itrust-updater/pkg/secrets"
+)
+
+func TestResolveNexusTokensFromKeyring(t *testing.T) {
+	store := secrets.NewInMemorySecretStore()
+	orig := secretStore
+	secretStore = store
+	defer func() { secretStore = orig }()
+
+	_ = store.Set("itrust-updater", "nexus:main:user-token", "name:code")
+
+	cfg := config.Config{"ITRUST_REPO_ID": "main", "ITRUST_BASE_URL": "https://nexus/repository/raw"}
+	if err := ResolveCredentials(cfg, true, true); err != nil {
+		t.Fatalf("ResolveCredentials failed: %v", err)
+	}
+	if cfg["ITRUST_NEXUS_USER_TOKEN"] != "name:code" {
+		t.Errorf("Expected user token from keyring, got %q", cfg["ITRUST_NEXUS_USER_TOKEN"])
+	}
+
+	_ = store.Set("itrust-updater", "nexus:main:token", "bearer")
+	cfg = config.Config{"ITRUST_REPO_ID": "main", "ITRUST_BASE_URL": "https://nexus/repository/raw"}
+	if err := ResolveCredentials(cfg, true, true); err != nil {
+		t.Fatalf("ResolveCredentials failed: %v", err)
+	}
+	if cfg["ITRUST_NEXUS_TOKEN"] != "bearer" {
+		t.Errorf("Expected bearer token from keyring, got %q", cfg["ITRUST_NEXUS_TOKEN"])
+	}
+
+	// An explicit password is not overridden by stored tokens.
+	cfg = config.Config{"ITRUST_REPO_ID": "main", "ITRUST_BASE_URL": "https://nexus/repository/raw", "ITRUST_NEXUS_USERNAME": "u", "ITRUST_NEXUS_PASSWORD": "p"}
+	if err := ResolveCredentials(cfg, true, true); err != nil {
+		t.Fatalf("ResolveCredentials failed: %v", err)
+	}
+	if cfg["ITRUST_NEXUS_TOKEN"] != "" {
+		t.Errorf("Expected no token, got %q", cfg["ITRUST_NEXUS_TOKEN"])
+	}
+}
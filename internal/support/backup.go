@@ -0,0 +1,86 @@
+package support
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/alapierre/itrust-updater/pkg/config"
+	"github.com/alapierre/itrust-updater/pkg/install"
+	"github.com/alapierre/itrust-updater/pkg/progress"
+)
+
+// BackupRetentionFromConfig reads the backup policy of a profile:
+// ITRUST_BACKUP=false disables backups, ITRUST_BACKUP_KEEP,
+// ITRUST_BACKUP_MAX_AGE and ITRUST_BACKUP_MAX_SIZE limit the ones kept.
+func BackupRetentionFromConfig(cfg config.Config) (install.Retention, error) {
+	var r install.Retention
+	r.Disabled = cfg.Get("ITRUST_BACKUP", "true") == "false"
+	if v := cfg.Get("ITRUST_BACKUP_KEEP", ""); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return r, fmt.Errorf("invalid ITRUST_BACKUP_KEEP %q", v)
+		}
+		r.Keep = n
+	}
+	if v := cfg.Get("ITRUST_BACKUP_MAX_AGE", ""); v != "" {
+		d, err := ParseTTL(v)
+		if err != nil {
+			return r, fmt.Errorf("invalid ITRUST_BACKUP_MAX_AGE %q (expected e.g. 30d or 720h)", v)
+		}
+		r.MaxAge = d
+	}
+	if v := cfg.Get("ITRUST_BACKUP_MAX_SIZE", ""); v != "" {
+		// Sizes take the same units as rates, e.g. 500M.
+		n, err := progress.ParseRate(v)
+		if err != nil {
+			return r, fmt.Errorf("invalid ITRUST_BACKUP_MAX_SIZE %q (expected e.g. 500M or 2G)", v)
+		}
+		r.MaxSize = n
+	}
+	return r, nil
+}
+
+// VersionedInstallFromConfig reports whether the profile installs versions
+// side by side (ITRUST_INSTALL_MODE=versioned) and how many of them are kept
+// (ITRUST_VERSIONS_KEEP, 0 for all).
+func VersionedInstallFromConfig(cfg config.Config) (bool, int, error) {
+	var versioned bool
+	switch mode := cfg.Get("ITRUST_INSTALL_MODE", "file"); mode {
+	case "file":
+	case "versioned":
+		versioned = true
+	default:
+		return false, 0, fmt.Errorf("invalid ITRUST_INSTALL_MODE %q (expected file or versioned)", mode)
+	}
+	keep := 0
+	if v := cfg.Get("ITRUST_VERSIONS_KEEP", ""); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return false, 0, fmt.Errorf("invalid ITRUST_VERSIONS_KEEP %q", v)
+		}
+		keep = n
+	}
+	return versioned, keep, nil
+}
+
+// ExtractLimitsFromConfig reads the caps on extracting archive artifacts:
+// ITRUST_MAX_EXTRACT_BYTES (e.g. 2G) and ITRUST_MAX_EXTRACT_ENTRIES. Unset
+// values take install.DefaultExtractLimits; 0 removes a limit.
+func ExtractLimitsFromConfig(cfg config.Config) (install.ExtractLimits, error) {
+	limits := install.DefaultExtractLimits
+	if v := cfg.Get("ITRUST_MAX_EXTRACT_BYTES", ""); v != "" {
+		n, err := progress.ParseRate(v)
+		if err != nil {
+			return limits, fmt.Errorf("invalid ITRUST_MAX_EXTRACT_BYTES %q (expected e.g. 500M or 2G)", v)
+		}
+		limits.MaxBytes = n
+	}
+	if v := cfg.Get("ITRUST_MAX_EXTRACT_ENTRIES", ""); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return limits, fmt.Errorf("invalid ITRUST_MAX_EXTRACT_ENTRIES %q", v)
+		}
+		limits.MaxEntries = n
+	}
+	return limits, nil
+}
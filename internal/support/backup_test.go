@@ -0,0 +1,69 @@
+package support
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/config"
+	"github.com/alapierre/itrust-updater/pkg/install"
+)
+
+func TestBackupRetentionFromConfig(t *testing.T) {
+	r, err := BackupRetentionFromConfig(config.Config{
+		"ITRUST_BACKUP_KEEP":     "3",
+		"ITRUST_BACKUP_MAX_AGE":  "30d",
+		"ITRUST_BACKUP_MAX_SIZE": "500M",
+	})
+	if err != nil {
+		t.Fatalf("BackupRetentionFromConfig failed: %v", err)
+	}
+	if r.Disabled || r.Keep != 3 || r.MaxAge != 30*24*time.Hour || r.MaxSize != 500<<20 {
+		t.Errorf("Unexpected retention: %+v", r)
+	}
+
+	if r, _ := BackupRetentionFromConfig(config.Config{"ITRUST_BACKUP": "false"}); !r.Disabled || !r.IsZero() {
+		t.Errorf("Expected backups disabled without limits, got %+v", r)
+	}
+
+	for _, cfg := range []config.Config{
+		{"ITRUST_BACKUP_KEEP": "-1"},
+		{"ITRUST_BACKUP_MAX_AGE": "a month"},
+		{"ITRUST_BACKUP_MAX_SIZE": "lots"},
+	} {
+		if _, err := BackupRetentionFromConfig(cfg); err == nil {
+			t.Errorf("Expected error for %v", cfg)
+		}
+	}
+}
+
+func TestVersionedInstallFromConfig(t *testing.T) {
+	versioned, keep, err := VersionedInstallFromConfig(config.Config{"ITRUST_INSTALL_MODE": "versioned", "ITRUST_VERSIONS_KEEP": "3"})
+	if err != nil || !versioned || keep != 3 {
+		t.Errorf("Expected versioned install keeping 3, got %v %d (%v)", versioned, keep, err)
+	}
+	if versioned, _, _ := VersionedInstallFromConfig(config.Config{}); versioned {
+		t.Error("Expected file install by default")
+	}
+	if _, _, err := VersionedInstallFromConfig(config.Config{"ITRUST_INSTALL_MODE": "side-by-side"}); err == nil {
+		t.Error("Expected error for unknown install mode")
+	}
+}
+
+func TestExtractLimitsFromConfig(t *testing.T) {
+	limits, err := ExtractLimitsFromConfig(config.Config{})
+	if err != nil || limits != install.DefaultExtractLimits {
+		t.Errorf("Expected default limits, got %+v (%v)", limits, err)
+	}
+	limits, err = ExtractLimitsFromConfig(config.Config{"ITRUST_MAX_EXTRACT_BYTES": "2G", "ITRUST_MAX_EXTRACT_ENTRIES": "0"})
+	if err != nil || limits.MaxBytes != 2<<30 || limits.MaxEntries != 0 {
+		t.Errorf("Expected 2G and no entry limit, got %+v (%v)", limits, err)
+	}
+	for _, cfg := range []config.Config{
+		{"ITRUST_MAX_EXTRACT_BYTES": "huge"},
+		{"ITRUST_MAX_EXTRACT_ENTRIES": "-1"},
+	} {
+		if _, err := ExtractLimitsFromConfig(cfg); err == nil {
+			t.Errorf("Expected error for %v", cfg)
+		}
+	}
+}
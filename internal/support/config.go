@@ -1,11 +1,13 @@
 package support
 
 import (
+	"fmt"
 	"path/filepath"
 
 	"github.com/alapierre/itrust-updater/pkg/config"
 	"github.com/alapierre/itrust-updater/pkg/logging"
 	"github.com/alapierre/itrust-updater/pkg/repo"
+	"github.com/alapierre/itrust-updater/pkg/sign"
 	"github.com/sirupsen/logrus"
 )
 
@@ -15,43 +17,126 @@ func LoadMergedConfig(configDir, profile string, logger *logrus.Entry) config.Co
 	envCfg := config.GetEnvConfig()
 	repoPath := filepath.Join(configDir, "repo.env")
 	repoCfg, _ := config.LoadFile(repoPath)
+	dropFileSecrets(repoCfg, repoPath)
 
 	profilePath := filepath.Join(configDir, "apps", profile+".env")
 	profileCfg, err := config.LoadFile(profilePath)
 	if err != nil {
 		logger.Warnf("Failed to load profile %s: %v", profile, err)
 	}
+	dropFileSecrets(profileCfg, profilePath)
 
 	return config.MergeConfigs(envCfg, profileCfg, repoCfg)
 }
 
+// LoadProjectConfig loads the project configuration used by push, promote
+// and yank.
+func LoadProjectConfig(path string) (config.Config, error) {
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dropFileSecrets(cfg, path)
+	return cfg, nil
+}
+
+// fileSecrets are the backend secrets only taken from the environment or the
+// keyring: config files, project files in particular, tend to end up in
+// version control.
+var fileSecrets = []string{
+	"ITRUST_NEXUS_PASSWORD",
+	"ITRUST_NEXUS_TOKEN",
+	"ITRUST_NEXUS_USER_TOKEN",
+	"ITRUST_S3_SECRET_ACCESS_KEY",
+}
+
+// dropFileSecrets removes the fileSecrets from cfg, loaded from path.
+func dropFileSecrets(cfg config.Config, path string) {
+	for _, key := range fileSecrets {
+		if _, ok := cfg[key]; ok {
+			logger.Warnf("Ignoring %s in %s; set it in the environment or store it in the keyring", key, path)
+			delete(cfg, key)
+		}
+	}
+}
+
 // LoadConfigWithRepoOverlay loads merged config and, if ITRUST_REPO_ID is set,
 // overlays repo config values only when corresponding keys are missing.
 func LoadConfigWithRepoOverlay(configDir, profile string) config.Config {
 	cfg := LoadMergedConfig(configDir, profile, logger)
+	OverlayRepoConfig(cfg, configDir)
+	return cfg
+}
 
+// OverlayRepoConfig copies every setting of the repo config referenced by
+// ITRUST_REPO_ID (backend type, URL, key pinning, TLS, ...) into cfg, keeping
+// values that are already set.
+func OverlayRepoConfig(cfg config.Config, configDir string) {
 	repoID := cfg.Get("ITRUST_REPO_ID", "")
 	if repoID == "" {
-		return cfg
+		return
 	}
 
 	logger.Debugf("Loading repo config for %s", repoID)
-	rc, err := repo.LoadRepoConfig(configDir, repoID)
+	repoPath := repo.GetRepoConfigPath(configDir, repoID)
+	repoCfg, err := config.LoadFile(repoPath)
 	if err != nil {
 		// Silent fallback keeps current behavior (just skip overlay if repo config fails).
-		return cfg
+		return
 	}
+	dropFileSecrets(repoCfg, repoPath)
 
-	// Merge repo config if not already set
-	if cfg.Get("ITRUST_BASE_URL", "") == "" {
-		cfg["ITRUST_BASE_URL"] = rc.BaseURL
+	for k, v := range repoCfg {
+		if cfg.Get(k, "") == "" {
+			cfg[k] = v
+		}
 	}
-	if cfg.Get("ITRUST_REPO_PUBKEY_SHA256", "") == "" {
-		cfg["ITRUST_REPO_PUBKEY_SHA256"] = rc.PubkeySha256
+}
+
+// UpdatePinnedFingerprint replaces the pinned repository key fingerprint
+// oldFp with newFp after a verified key rotation, in every config file of the
+// profile (profile, repo.env and the linked repo config) that pins oldFp. It
+// returns the updated files; none means the pin came from the environment.
+func UpdatePinnedFingerprint(configDir, profile, repoID, oldFp, newFp string) ([]string, error) {
+	paths := []string{
+		filepath.Join(configDir, "apps", profile+".env"),
+		filepath.Join(configDir, "repo.env"),
 	}
-	if cfg.Get("ITRUST_REPO_PUBKEY_PATH", "") == "" {
-		cfg["ITRUST_REPO_PUBKEY_PATH"] = rc.PubkeyPath
+	if repoID != "" {
+		paths = append(paths, repo.GetRepoConfigPath(configDir, repoID))
 	}
 
-	return cfg
+	var updated []string
+	for _, path := range paths {
+		changed, err := config.ReplaceValue(path, "ITRUST_REPO_PUBKEY_SHA256", oldFp, newFp)
+		if err != nil {
+			return updated, fmt.Errorf("failed to update %s: %v", path, err)
+		}
+		if changed {
+			updated = append(updated, path)
+		}
+	}
+	return updated, nil
+}
+
+// PersistKeyRotation updates the pinned fingerprint when pubKey, the key that
+// verified the manifest, is not the pinned one, i.e. after following a key
+// rotation. Failures are reported but not fatal: the chain is followed again
+// on the next run.
+func PersistKeyRotation(configDir, profile, repoID, pinnedFp string, pubKey []byte) {
+	newFp := sign.SHA256(pubKey)
+	if newFp == pinnedFp {
+		return
+	}
+	fmt.Printf("Repository signing key rotated: %s -> %s\n", pinnedFp, newFp)
+	updated, err := UpdatePinnedFingerprint(configDir, profile, repoID, pinnedFp, newFp)
+	if err != nil {
+		logger.Errorf("Failed to update pinned key fingerprint: %v", err)
+	}
+	for _, path := range updated {
+		logger.Infof("Updated pinned key fingerprint in %s", path)
+	}
+	if err == nil && len(updated) == 0 {
+		logger.Warnf("Pinned key fingerprint is not set in a config file; update ITRUST_REPO_PUBKEY_SHA256 to %s", newFp)
+	}
 }
@@ -0,0 +1,50 @@
+package support
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFilesDoNotSupplySecrets(t *testing.T) {
+	configDir := t.TempDir()
+	for path, content := range map[string]string{
+		filepath.Join(configDir, "apps", "app1.env"):   "ITRUST_REPO_ID=repo1\nITRUST_NEXUS_USERNAME=u\nITRUST_NEXUS_PASSWORD=profile-secret\nITRUST_NEXUS_TOKEN=profile-token\n",
+		filepath.Join(configDir, "repos", "repo1.env"): "ITRUST_BASE_URL=https://nexus/repository/raw\nITRUST_NEXUS_PASSWORD=repo-secret\nITRUST_NEXUS_USER_TOKEN=name:pass\nITRUST_S3_SECRET_ACCESS_KEY=repo-key\n",
+		filepath.Join(configDir, "project.env"):        "ITRUST_APP_ID=app1\nITRUST_NEXUS_PASSWORD=project-secret\nITRUST_S3_SECRET_ACCESS_KEY=project-key\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, key := range fileSecrets {
+		t.Setenv(key, "")
+	}
+	cfg := LoadConfigWithRepoOverlay(configDir, "app1")
+	for _, key := range fileSecrets {
+		if cfg.Get(key, "") != "" {
+			t.Errorf("Expected %s in config files to be ignored, got %q", key, cfg[key])
+		}
+	}
+	if cfg.Get("ITRUST_NEXUS_USERNAME", "") != "u" || cfg.Get("ITRUST_BASE_URL", "") == "" {
+		t.Errorf("Expected other settings to be loaded, got %v", cfg)
+	}
+	project, err := LoadProjectConfig(filepath.Join(configDir, "project.env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(project) != 1 || project["ITRUST_APP_ID"] != "app1" {
+		t.Errorf("Expected only the secrets to be dropped from the project config, got %v", project)
+	}
+
+	t.Setenv("ITRUST_NEXUS_PASSWORD", "env-secret")
+	t.Setenv("ITRUST_NEXUS_TOKEN", "env-token")
+	cfg = LoadConfigWithRepoOverlay(configDir, "app1")
+	if cfg.Get("ITRUST_NEXUS_PASSWORD", "") != "env-secret" || cfg.Get("ITRUST_NEXUS_TOKEN", "") != "env-token" {
+		t.Errorf("Expected the secrets from the environment, got %q / %q", cfg["ITRUST_NEXUS_PASSWORD"], cfg["ITRUST_NEXUS_TOKEN"])
+	}
+}
@@ -0,0 +1,81 @@
+package support
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+	"github.com/alapierre/itrust-updater/pkg/trust"
+)
+
+// CosignRelease adds a signature of seed to the manifest of the given release
+// and to every channel manifest of the app currently pointing at it. Only
+// manifests carrying a valid repository key signature are cosigned. The
+// cosigner's public key is published under keys/ so clients can fetch it by
+// its pinned fingerprint. It returns the cosigned paths.
+func CosignRelease(ctx context.Context, b backend.Backend, repoID, appID, version, pubkeyPath, repoPubkeySha, seed string) ([]string, error) {
+	repoKey, err := trust.ResolveKey(ctx, b, repoID, pubkeyPath, repoPubkeySha)
+	if err != nil {
+		return nil, err
+	}
+	cosignerKey, err := sign.SeedToPubKey(seed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive cosigner public key: %v", err)
+	}
+	if err := trust.PublishKey(ctx, b, pubkeyPath, cosignerKey); err != nil {
+		return nil, err
+	}
+
+	paths := []string{fmt.Sprintf("apps/%s/releases/v%s/artifacts.json", appID, version)}
+	channels, err := b.List(ctx, "apps/"+appID+"/channels/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list channels: %v", err)
+	}
+	for _, obj := range channels {
+		if isChannelManifest(obj.Path) {
+			paths = append(paths, obj.Path)
+		}
+	}
+
+	var cosigned []string
+	for i, path := range paths {
+		m, err := GetManifest(ctx, b, path)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			logger.Warnf("Skipping %s: %v", path, err)
+			continue
+		}
+		if m.Payload.Latest.Version != version {
+			continue
+		}
+		if err := m.Payload.VerifyBinding(repoID, appID, "", version); err != nil {
+			return cosigned, fmt.Errorf("refusing to cosign %s: %v", path, err)
+		}
+		if err := CosignManifest(m, repoKey, seed); err != nil {
+			return cosigned, fmt.Errorf("%s: %v", path, err)
+		}
+		if err := PutManifest(ctx, b, path, m); err != nil {
+			return cosigned, fmt.Errorf("failed to upload %s: %v", path, err)
+		}
+		logger.Infof("Cosigned %s", path)
+		cosigned = append(cosigned, path)
+	}
+	return cosigned, nil
+}
+
+// CosignManifest adds a signature of seed to a manifest that is already
+// signed by repoKey, e.g. for a manifest file reviewed offline.
+func CosignManifest(m *manifest.Manifest, repoKey []byte, seed string) error {
+	if err := m.VerifySignature(repoKey); err != nil {
+		return fmt.Errorf("refusing to cosign: no valid repository key signature: %v", err)
+	}
+	cosignerKey, err := sign.SeedToPubKey(seed)
+	if err != nil {
+		return fmt.Errorf("failed to derive cosigner public key: %v", err)
+	}
+	return m.AddSignature(seed, "cosign-"+sign.SHA256(cosignerKey)[:16])
+}
@@ -0,0 +1,130 @@
+package support
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/config"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+	"github.com/alapierre/itrust-updater/pkg/trust"
+)
+
+func TestCosignRelease(t *testing.T) {
+	root, repoFp := newTestRepo(t)
+	b := backend.NewFileBackend(root)
+	ctx := context.Background()
+	pubkeyPath := "repo/public-keys/ed25519.pub"
+	cosignSeed := "AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI="
+	cosignKey, _ := sign.SeedToPubKey(cosignSeed)
+	cosignFp := sign.SHA256(cosignKey)
+
+	writeManifest(t, root, "apps/app1/channels/stable.json", "repo1", "app1", "stable", "1.0.0")
+	writeManifest(t, root, "apps/app1/channels/beta.json", "repo1", "app1", "beta", "1.1.0")
+	writeManifest(t, root, "apps/app1/releases/v1.0.0/artifacts.json", "repo1", "app1", "stable", "1.0.0")
+
+	policy := SignaturePolicy{CosignerKeys: []string{cosignFp}, Threshold: 2}
+	if _, _, err := FetchAndVerifyManifest(ctx, b, "repo1", "app1", "stable", "", pubkeyPath, repoFp, policy); err == nil {
+		t.Fatal("Expected error before the release is cosigned")
+	}
+
+	cosigned, err := CosignRelease(ctx, b, "repo1", "app1", "1.0.0", pubkeyPath, repoFp, cosignSeed)
+	if err != nil {
+		t.Fatalf("CosignRelease failed: %v", err)
+	}
+	if len(cosigned) != 2 {
+		t.Errorf("Expected release and stable channel to be cosigned, got %v", cosigned)
+	}
+
+	for _, version := range []string{"", "1.0.0"} {
+		if _, _, err := FetchAndVerifyManifest(ctx, b, "repo1", "app1", "stable", version, pubkeyPath, repoFp, policy); err != nil {
+			t.Errorf("Expected cosigned manifest to verify, got %v", err)
+		}
+		// Clients without a policy keep working.
+		if _, _, err := FetchAndVerifyManifest(ctx, b, "repo1", "app1", "stable", version, pubkeyPath, repoFp, SignaturePolicy{}); err != nil {
+			t.Errorf("Expected cosigned manifest to verify with the repository key only, got %v", err)
+		}
+	}
+	if _, _, err := FetchAndVerifyManifest(ctx, b, "repo1", "app1", "beta", "", pubkeyPath, repoFp, policy); err == nil {
+		t.Error("Expected beta channel, pointing at another version, to lack the cosignature")
+	}
+
+	// A key rotation keeps the cosignature.
+	newSeed := "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="
+	oldPubKey, _ := sign.SeedToPubKey(testSeed)
+	if _, err := ResignManifests(ctx, b, oldPubKey, newSeed); err != nil {
+		t.Fatalf("ResignManifests failed: %v", err)
+	}
+	m, err := GetManifest(ctx, b, "apps/app1/channels/stable.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPubKey, _ := sign.SeedToPubKey(newSeed)
+	if err := m.VerifyThreshold([][]byte{newPubKey, cosignKey}, 2); err != nil {
+		t.Errorf("Expected cosignature to survive re-signing, got %v", err)
+	}
+}
+
+func TestSignaturePolicyFromConfig(t *testing.T) {
+	policy, err := SignaturePolicyFromConfig(config.Config{
+		"ITRUST_COSIGNER_PUBKEYS_SHA256": " AA , bb,",
+		"ITRUST_SIGNATURE_THRESHOLD":     "3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(policy.CosignerKeys, ",") != "aa,bb" || policy.Threshold != 3 {
+		t.Errorf("Unexpected policy %+v", policy)
+	}
+
+	if policy, err := SignaturePolicyFromConfig(config.Config{}); err != nil || policy.Threshold != 1 {
+		t.Errorf("Expected default threshold 1, got %+v, %v", policy, err)
+	}
+	for _, v := range []string{"0", "x", "2"} {
+		if _, err := SignaturePolicyFromConfig(config.Config{"ITRUST_SIGNATURE_THRESHOLD": v}); err == nil {
+			t.Errorf("Expected error for threshold %q without cosigners", v)
+		}
+	}
+}
+
+func TestRevokedCosignerIsNotTrusted(t *testing.T) {
+	root, repoFp := newTestRepo(t)
+	b := backend.NewFileBackend(root)
+	ctx := context.Background()
+	pubkeyPath := "repo/public-keys/ed25519.pub"
+	cosignSeed := "AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI="
+	cosignKey, _ := sign.SeedToPubKey(cosignSeed)
+	cosignFp := sign.SHA256(cosignKey)
+
+	writeManifest(t, root, "apps/app1/channels/stable.json", "repo1", "app1", "stable", "1.0.0")
+	writeManifest(t, root, "apps/app1/releases/v1.0.0/artifacts.json", "repo1", "app1", "stable", "1.0.0")
+	if _, err := CosignRelease(ctx, b, "repo1", "app1", "1.0.0", pubkeyPath, repoFp, cosignSeed); err != nil {
+		t.Fatalf("CosignRelease failed: %v", err)
+	}
+
+	policy := SignaturePolicy{CosignerKeys: []string{cosignFp}, Threshold: 1}
+	_, v, err := FetchAndVerifyManifest(ctx, b, "repo1", "app1", "stable", "", pubkeyPath, repoFp, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(v.Signers, ",") != repoFp+","+cosignFp || v.Revocations != nil {
+		t.Errorf("Expected both signers and no revocations, got %v / %v", v.Signers, v.Revocations)
+	}
+
+	if _, err := trust.PublishRevocation(ctx, b, "repo1", pubkeyPath, testSeed, "k", trust.RevokedKey{Fingerprint: cosignFp}); err != nil {
+		t.Fatalf("PublishRevocation failed: %v", err)
+	}
+	_, v, err = FetchAndVerifyManifest(ctx, b, "repo1", "app1", "stable", "", pubkeyPath, repoFp, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(v.Signers, ",") != repoFp || v.Revocations.Lookup(cosignFp) == nil {
+		t.Errorf("Expected revoked cosigner to be ignored, got %v", v.Signers)
+	}
+
+	policy.Threshold = 2
+	if _, _, err := FetchAndVerifyManifest(ctx, b, "repo1", "app1", "stable", "", pubkeyPath, repoFp, policy); err == nil {
+		t.Error("Expected threshold to fail with the cosigner revoked")
+	}
+}
@@ -1,52 +1,224 @@
 package support
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 
 	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/config"
 	"github.com/alapierre/itrust-updater/pkg/manifest"
 	"github.com/alapierre/itrust-updater/pkg/sign"
+	"github.com/alapierre/itrust-updater/pkg/trust"
 )
 
-func FetchAndVerifyManifest(ctx context.Context, b backend.Backend, appId, channel, version, pubkeyPath, expectedPubkeySha string) (*manifest.Manifest, []byte, error) {
-	// 1. Get and verify pubkey
-	pubKeyReader, err := b.Get(ctx, pubkeyPath)
+// SignaturePolicy requires Threshold distinct keys, out of the repository key
+// and the cosigner keys pinned by fingerprint, to have signed a manifest. The
+// zero value accepts a signature of the repository key alone.
+type SignaturePolicy struct {
+	CosignerKeys []string
+	Threshold    int
+}
+
+// SignaturePolicyFromConfig reads ITRUST_COSIGNER_PUBKEYS_SHA256 (comma
+// separated fingerprints) and ITRUST_SIGNATURE_THRESHOLD (default 1).
+func SignaturePolicyFromConfig(cfg config.Config) (SignaturePolicy, error) {
+	var policy SignaturePolicy
+	for _, fp := range strings.Split(cfg.Get("ITRUST_COSIGNER_PUBKEYS_SHA256", ""), ",") {
+		if fp = strings.ToLower(strings.TrimSpace(fp)); fp != "" {
+			policy.CosignerKeys = append(policy.CosignerKeys, fp)
+		}
+	}
+	policy.Threshold = 1
+	if v := cfg.Get("ITRUST_SIGNATURE_THRESHOLD", ""); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return policy, fmt.Errorf("invalid ITRUST_SIGNATURE_THRESHOLD %q", v)
+		}
+		policy.Threshold = n
+	}
+	if policy.Threshold > len(policy.CosignerKeys)+1 {
+		return policy, fmt.Errorf("ITRUST_SIGNATURE_THRESHOLD %d exceeds the %d trusted key(s)", policy.Threshold, len(policy.CosignerKeys)+1)
+	}
+	return policy, nil
+}
+
+// Verification describes how a manifest returned by FetchAndVerifyManifest
+// was verified.
+type Verification struct {
+	// RepoKey is the current repository key. It differs from the pinned one
+	// after a key rotation; callers should then persist the new pin with
+	// UpdatePinnedFingerprint.
+	RepoKey []byte
+	// Signers are the fingerprints of the trusted keys that signed the manifest.
+	Signers []string
+	// Revocations is the repository's revocation list, nil if none is published.
+	Revocations *trust.RevocationList
+}
+
+// FetchAndVerifyManifest fetches the channel manifest, or the manifest of the
+// given version, verifies its signatures against the pinned repository key and
+// the signature policy, and checks that it is bound to the requested
+// repository, app and channel/version. Keys on the repository's revocation
+// list are not trusted.
+func FetchAndVerifyManifest(ctx context.Context, b backend.Backend, repoID, appId, channel, version, pubkeyPath, expectedPubkeySha string, policy SignaturePolicy) (*manifest.Manifest, *Verification, error) {
+	// 1. Get the repository key, following key rotations from the pinned one
+	pubKey, err := trust.ResolveKey(ctx, b, repoID, pubkeyPath, expectedPubkeySha)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get repository public key: %v", err)
+		return nil, nil, err
 	}
-	pubKey, err := io.ReadAll(pubKeyReader)
-	pubKeyReader.Close()
+	revocations, err := trust.FetchRevocations(ctx, b, repoID, pubkeyPath, pubKey)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read public key: %v", err)
+		return nil, nil, err
 	}
-
-	if err := sign.VerifyFingerprint(pubKey, expectedPubkeySha); err != nil {
-		return nil, nil, fmt.Errorf("public key verification failed: %v", err)
+	if r := revocations.Lookup(sign.SHA256(pubKey)); r != nil {
+		return nil, nil, fmt.Errorf("repository key %s has been revoked", r.Fingerprint)
 	}
 
 	// 2. Get manifest
 	manifestPath := fmt.Sprintf("apps/%s/channels/%s.json", appId, channel)
+	expectedChannel, expectedVersion := channel, ""
 	if version != "" && version != "latest" {
 		manifestPath = fmt.Sprintf("apps/%s/releases/v%s/artifacts.json", appId, version)
+		// Version manifests carry the channel they were pushed to, which
+		// need not be the profile's channel; the version is what binds them.
+		expectedChannel, expectedVersion = "", version
+	}
+
+	m, err := GetManifest(ctx, b, manifestPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	trusted := [][]byte{pubKey}
+	for _, fp := range policy.CosignerKeys {
+		if r := revocations.Lookup(fp); r != nil {
+			logger.Warnf("Cosigner key %s has been revoked, ignoring it", fp)
+			continue
+		}
+		key, err := trust.FetchKey(ctx, b, pubkeyPath, fp)
+		if err != nil {
+			logger.Warnf("Cosigner key %s is not available: %v", fp, err)
+			continue
+		}
+		trusted = append(trusted, key)
+	}
+	if err := m.VerifyThreshold(trusted, policy.Threshold); err != nil {
+		return nil, nil, fmt.Errorf("manifest signature verification failed: %v", err)
+	}
+
+	if err := m.Payload.VerifyBinding(repoID, appId, expectedChannel, expectedVersion); err != nil {
+		return nil, nil, fmt.Errorf("manifest %s rejected: %v", manifestPath, err)
+	}
+
+	return m, &Verification{RepoKey: pubKey, Signers: m.Signers(trusted), Revocations: revocations}, nil
+}
+
+// FetchAndVerifyIndex fetches the release index of appID and verifies it
+// against the pinned repository key, following key rotations. It returns the
+// current repository key along with the index.
+func FetchAndVerifyIndex(ctx context.Context, b backend.Backend, repoID, appID, pubkeyPath, expectedPubkeySha string) (*manifest.Index, []byte, error) {
+	pubKey, err := trust.ResolveKey(ctx, b, repoID, pubkeyPath, expectedPubkeySha)
+	if err != nil {
+		return nil, nil, err
+	}
+	revocations, err := trust.FetchRevocations(ctx, b, repoID, pubkeyPath, pubKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r := revocations.Lookup(sign.SHA256(pubKey)); r != nil {
+		return nil, nil, fmt.Errorf("repository key %s has been revoked", r.Fingerprint)
 	}
 
-	manifestReader, err := b.Get(ctx, manifestPath)
+	index, err := FetchIndex(ctx, b, repoID, appID, pubKey)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get manifest: %v", err)
+		return nil, nil, err
 	}
+	if index == nil {
+		return nil, nil, fmt.Errorf("no release index is published for %s", appID)
+	}
+	return index, pubKey, nil
+}
+
+// FetchIndex returns the release index of appID verified against repoKey, the
+// current repository key, or nil if none is published (releases pushed
+// before the index existed). Callers check it against the installed state
+// with CheckIndex, as a missing or older index could hide a yank.
+func FetchIndex(ctx context.Context, b backend.Backend, repoID, appID string, repoKey []byte) (*manifest.Index, error) {
+	path := manifest.IndexPath(appID)
+	exists, err := b.Exists(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check release index: %v", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	index, err := GetIndex(ctx, b, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := index.Verify(repoKey); err != nil {
+		return nil, fmt.Errorf("release index signature verification failed: %v", err)
+	}
+	if (repoID != "" && index.Payload.Repo.ID != repoID) || index.Payload.App.ID != appID {
+		return nil, fmt.Errorf("release index %s belongs to %s/%s, expected %s/%s", path, index.Payload.Repo.ID, index.Payload.App.ID, repoID, appID)
+	}
+	return index, nil
+}
+
+// GetIndex downloads and decodes the release index at path without
+// verifying it.
+func GetIndex(ctx context.Context, b backend.Backend, path string) (*manifest.Index, error) {
+	rc, err := b.Get(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get release index: %v", err)
+	}
+	defer rc.Close()
+	var index manifest.Index
+	if err := json.NewDecoder(rc).Decode(&index); err != nil {
+		return nil, fmt.Errorf("failed to decode release index: %v", err)
+	}
+	return &index, nil
+}
+
+// GetManifest downloads and decodes the manifest at path without verifying it.
+func GetManifest(ctx context.Context, b backend.Backend, path string) (*manifest.Manifest, error) {
+	rc, err := b.Get(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %v", err)
+	}
+	defer rc.Close()
 	var m manifest.Manifest
-	if err := json.NewDecoder(manifestReader).Decode(&m); err != nil {
-		manifestReader.Close()
-		return nil, nil, fmt.Errorf("failed to decode manifest: %v", err)
+	if err := json.NewDecoder(rc).Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %v", err)
 	}
-	manifestReader.Close()
+	return &m, nil
+}
 
-	if err := m.Verify(pubKey); err != nil {
-		return nil, nil, fmt.Errorf("manifest signature verification failed: %v", err)
+// PutManifest uploads m as indented JSON to path.
+func PutManifest(ctx context.Context, b backend.Backend, path string, m *manifest.Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	openManifest := func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
 	}
+	return b.Put(ctx, path, openManifest, "application/json")
+}
 
-	return &m, pubKey, nil
+// PutIndex uploads index as indented JSON to path.
+func PutIndex(ctx context.Context, b backend.Backend, path string, index *manifest.Index) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	openIndex := func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return b.Put(ctx, path, openIndex, "application/json")
 }
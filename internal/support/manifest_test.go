@@ -0,0 +1,124 @@
+package support
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+const testSeed = "tG8Y/V8NOnR5i/YkO9uH0WlG6G6fR5e7uI9oP9kI9mI="
+
+// writeRepoFile stores data under the repository root, creating parent directories.
+func writeRepoFile(t *testing.T, root, path string, data []byte) {
+	t.Helper()
+	full := filepath.Join(root, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeManifest signs a manifest for the given binding and stores it at path.
+func writeManifest(t *testing.T, root, path, repoID, appID, channel, version string) {
+	t.Helper()
+	writeSignedManifest(t, root, path, manifest.Payload{
+		SchemaVersion: 1,
+		Repo:          manifest.RepoInfo{ID: repoID},
+		App:           manifest.AppInfo{ID: appID},
+		Channel:       channel,
+		GeneratedAt:   time.Now().UTC(),
+		Latest:        manifest.Release{Version: version},
+	}, testSeed)
+}
+
+func writeSignedManifest(t *testing.T, root, path string, payload manifest.Payload, seed string) {
+	t.Helper()
+	m, err := manifest.SignManifest(payload, seed, "test-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(m)
+	writeRepoFile(t, root, path, data)
+}
+
+func newTestRepo(t *testing.T) (string, string) {
+	t.Helper()
+	root := t.TempDir()
+	pubKey, err := sign.SeedToPubKey(testSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRepoFile(t, root, "repo/public-keys/ed25519.pub", pubKey)
+	return root, sign.SHA256(pubKey)
+}
+
+func TestFetchAndVerifyManifestBinding(t *testing.T) {
+	root, pubSha := newTestRepo(t)
+	b := backend.NewFileBackend(root)
+	ctx := context.Background()
+	pubkeyPath := "repo/public-keys/ed25519.pub"
+
+	// Genuine manifests.
+	writeManifest(t, root, "apps/app1/channels/stable.json", "repo1", "app1", "stable", "1.0.0")
+	writeManifest(t, root, "apps/app1/releases/v0.9.0/artifacts.json", "repo1", "app1", "beta", "0.9.0")
+	// Validly signed manifests swapped into the wrong place.
+	writeManifest(t, root, "apps/app1/channels/beta.json", "repo1", "app1", "stable", "1.0.0")
+	writeManifest(t, root, "apps/app2/channels/stable.json", "repo1", "app1", "stable", "1.0.0")
+	writeManifest(t, root, "apps/app1/releases/v0.8.0/artifacts.json", "repo1", "app1", "stable", "1.0.0")
+
+	tests := []struct {
+		name             string
+		repoID, appID    string
+		channel, version string
+		wantErr          string
+	}{
+		{"channel manifest", "repo1", "app1", "stable", "", ""},
+		{"pinned version from another channel", "repo1", "app1", "stable", "0.9.0", ""},
+		{"wrong repository", "repo2", "app1", "stable", "", `repository "repo1", expected "repo2"`},
+		{"swapped channel", "repo1", "app1", "beta", "", `channel "stable", expected "beta"`},
+		{"swapped app", "repo1", "app2", "stable", "", `app "app1", expected "app2"`},
+		{"swapped version", "repo1", "app1", "stable", "0.8.0", `version "1.0.0", expected "0.8.0"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := FetchAndVerifyManifest(ctx, b, tt.repoID, tt.appID, tt.channel, tt.version, pubkeyPath, pubSha, SignaturePolicy{})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Expected success, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestFetchAndVerifyManifestExpired(t *testing.T) {
+	root, pubSha := newTestRepo(t)
+	b := backend.NewFileBackend(root)
+
+	writeSignedManifest(t, root, "apps/app1/channels/stable.json", manifest.Payload{
+		Repo:      manifest.RepoInfo{ID: "repo1"},
+		App:       manifest.AppInfo{ID: "app1"},
+		Channel:   "stable",
+		ExpiresAt: time.Now().Add(-time.Minute).UTC(),
+		Latest:    manifest.Release{Version: "1.0.0"},
+	}, testSeed)
+
+	_, _, err := FetchAndVerifyManifest(context.Background(), b, "repo1", "app1", "stable", "", "repo/public-keys/ed25519.pub", pubSha, SignaturePolicy{})
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Expected expiry error, got %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package support
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+// RefreshChannelManifests re-signs the channel manifests of appID (all apps
+// if empty) with expiresAt as their new expiry. Only manifests carrying a
+// valid signature of the seed's key are re-signed, so content planted in the
+// storage never gets a fresh signature. Cosigned manifests are skipped unless
+// force is set, as their cosignatures would be dropped. It returns the
+// refreshed paths.
+func RefreshChannelManifests(ctx context.Context, b backend.Backend, appID, seed string, expiresAt time.Time, force bool) ([]string, error) {
+	pubKey, err := sign.SeedToPubKey(seed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive public key: %v", err)
+	}
+	prefix := "apps/"
+	if appID != "" {
+		prefix = "apps/" + appID + "/channels/"
+	}
+	return resignManifests(ctx, b, prefix, isChannelManifest, [][]byte{pubKey}, func(path string, m *manifest.Manifest) error {
+		if m.HasCosignatures(pubKey) {
+			if !force {
+				return fmt.Errorf("manifest is cosigned and refreshing would drop the cosignatures (use --force to refresh it anyway)")
+			}
+			logger.Warnf("Dropping cosignatures of %s; cosigners must sign the refreshed manifest again", path)
+		}
+		m.Payload.ExpiresAt = expiresAt.UTC()
+		signed, err := manifest.SignManifest(m.Payload, seed, SigningKeyID())
+		if err != nil {
+			return err
+		}
+		*m = *signed
+		return nil
+	})
+}
+
+// AddRotationSignatures adds a signature of the key of newSeed to every
+// channel and release manifest signed by oldPubKey, keeping the old one. It is
+// the first step of a key rotation: the manifests then verify with either key,
+// so the published key can be switched without a window where clients refuse
+// them.
+func AddRotationSignatures(ctx context.Context, b backend.Backend, oldPubKey []byte, newSeed string) ([]string, error) {
+	newPubKey, err := sign.SeedToPubKey(newSeed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive public key: %v", err)
+	}
+	return resignManifests(ctx, b, "apps/", isSignedManifest, [][]byte{oldPubKey}, func(path string, m *manifest.Manifest) error {
+		sig, err := manifest.SignPayload(m.Payload, newSeed, SigningKeyID())
+		if err != nil {
+			return err
+		}
+		m.RemoveSignatures(newPubKey)
+		m.SetSignatures(append(m.AllSignatures(), *sig))
+		return nil
+	})
+}
+
+// ResignManifests replaces the signature of oldPubKey on every channel and
+// release manifest with one of the key of newSeed, leaving the payloads and
+// any cosignatures unchanged, and re-signs the release indexes. It is used
+// when rotating the repository key and can be run again if interrupted.
+func ResignManifests(ctx context.Context, b backend.Backend, oldPubKey []byte, newSeed string) ([]string, error) {
+	newPubKey, err := sign.SeedToPubKey(newSeed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive public key: %v", err)
+	}
+	resigned, err := resignManifests(ctx, b, "apps/", isSignedManifest, [][]byte{oldPubKey, newPubKey}, func(path string, m *manifest.Manifest) error {
+		sig, err := manifest.SignPayload(m.Payload, newSeed, SigningKeyID())
+		if err != nil {
+			return err
+		}
+		m.RemoveSignatures(oldPubKey)
+		m.RemoveSignatures(newPubKey)
+		m.SetSignatures(append([]manifest.Signature{*sig}, m.AllSignatures()...))
+		return nil
+	})
+	if err != nil {
+		return resigned, err
+	}
+	indexes, err := resignIndexes(ctx, b, oldPubKey, newSeed)
+	return append(resigned, indexes...), err
+}
+
+// resignIndexes re-signs every release index signed by oldPubKey with the key
+// of newSeed. Indexes already signed by the new key are skipped.
+func resignIndexes(ctx context.Context, b backend.Backend, oldPubKey []byte, newSeed string) ([]string, error) {
+	newPubKey, err := sign.SeedToPubKey(newSeed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive public key: %v", err)
+	}
+	objects, err := b.List(ctx, "apps/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list release indexes: %v", err)
+	}
+
+	var resigned []string
+	for _, obj := range objects {
+		if !isIndex(obj.Path) {
+			continue
+		}
+		index, err := GetIndex(ctx, b, obj.Path)
+		if err != nil {
+			return resigned, err
+		}
+		if index.Verify(newPubKey) == nil {
+			continue
+		}
+		if err := index.Verify(oldPubKey); err != nil {
+			return resigned, fmt.Errorf("existing signature of %s is not valid for the signing key: %v", obj.Path, err)
+		}
+		signed, err := manifest.SignIndex(index.Payload, newSeed, SigningKeyID())
+		if err != nil {
+			return resigned, fmt.Errorf("failed to sign %s: %v", obj.Path, err)
+		}
+		if err := PutIndex(ctx, b, obj.Path, signed); err != nil {
+			return resigned, fmt.Errorf("failed to upload %s: %v", obj.Path, err)
+		}
+		logger.Infof("Re-signed %s", obj.Path)
+		resigned = append(resigned, obj.Path)
+	}
+	return resigned, nil
+}
+
+// resignManifests applies resign to every manifest under prefix accepted by
+// match that carries a valid signature of one of verifyKeys, and uploads the
+// result.
+func resignManifests(ctx context.Context, b backend.Backend, prefix string, match func(string) bool, verifyKeys [][]byte, resign func(path string, m *manifest.Manifest) error) ([]string, error) {
+	objects, err := b.List(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list manifests: %v", err)
+	}
+
+	var resigned []string
+	var failed int
+	for _, obj := range objects {
+		if !match(obj.Path) {
+			continue
+		}
+		m, err := GetManifest(ctx, b, obj.Path)
+		if err != nil {
+			logger.Errorf("Skipping %s: %v", obj.Path, err)
+			failed++
+			continue
+		}
+		if err := verifyAnySignature(m, verifyKeys); err != nil {
+			logger.Errorf("Skipping %s: existing signature is not valid for the signing key: %v", obj.Path, err)
+			failed++
+			continue
+		}
+
+		if err := resign(obj.Path, m); err != nil {
+			logger.Errorf("Skipping %s: %v", obj.Path, err)
+			failed++
+			continue
+		}
+		if err := PutManifest(ctx, b, obj.Path, m); err != nil {
+			return resigned, fmt.Errorf("failed to upload %s: %v", obj.Path, err)
+		}
+		logger.Infof("Re-signed %s", obj.Path)
+		resigned = append(resigned, obj.Path)
+	}
+
+	if failed > 0 {
+		return resigned, fmt.Errorf("%d manifest(s) could not be re-signed", failed)
+	}
+	return resigned, nil
+}
+
+func verifyAnySignature(m *manifest.Manifest, keys [][]byte) error {
+	var err error
+	for _, key := range keys {
+		if err = m.VerifySignature(key); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// isSignedManifest reports whether path is a channel or release manifest.
+func isSignedManifest(path string) bool {
+	return isChannelManifest(path) || isReleaseManifest(path)
+}
+
+// isChannelManifest reports whether path has the form apps/<app>/channels/<channel>.json.
+func isChannelManifest(path string) bool {
+	parts := strings.Split(path, "/")
+	return len(parts) == 4 && parts[0] == "apps" && parts[2] == "channels" && strings.HasSuffix(parts[3], ".json")
+}
+
+// isReleaseManifest reports whether path has the form apps/<app>/releases/v<version>/artifacts.json.
+func isReleaseManifest(path string) bool {
+	parts := strings.Split(path, "/")
+	return len(parts) == 5 && parts[0] == "apps" && parts[2] == "releases" && strings.HasPrefix(parts[3], "v") && parts[4] == "artifacts.json"
+}
+
+// isIndex reports whether path has the form apps/<app>/index.json.
+func isIndex(path string) bool {
+	parts := strings.Split(path, "/")
+	return len(parts) == 3 && parts[0] == "apps" && parts[2] == "index.json"
+}
@@ -0,0 +1,121 @@
+package support
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+func TestRefreshChannelManifests(t *testing.T) {
+	root, pubSha := newTestRepo(t)
+	b := backend.NewFileBackend(root)
+	ctx := context.Background()
+	pubkeyPath := "repo/public-keys/ed25519.pub"
+
+	expired := manifest.Payload{
+		Repo:        manifest.RepoInfo{ID: "repo1"},
+		App:         manifest.AppInfo{ID: "app1"},
+		Channel:     "stable",
+		GeneratedAt: time.Now().Add(-48 * time.Hour).UTC(),
+		ExpiresAt:   time.Now().Add(-time.Hour).UTC(),
+		Latest:      manifest.Release{Version: "1.0.0"},
+	}
+	writeSignedManifest(t, root, "apps/app1/channels/stable.json", expired, testSeed)
+	writeSignedManifest(t, root, "apps/app1/releases/v1.0.0/artifacts.json", expired, testSeed)
+	// Planted by someone without the signing key.
+	forged := expired
+	forged.Channel = "beta"
+	writeSignedManifest(t, root, "apps/app1/channels/beta.json", forged, "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+
+	expiresAt := time.Now().Add(30 * 24 * time.Hour)
+	refreshed, err := RefreshChannelManifests(ctx, b, "", testSeed, expiresAt, false)
+	if err == nil || !strings.Contains(err.Error(), "1 manifest(s)") {
+		t.Errorf("Expected error for the forged manifest, got %v", err)
+	}
+	if len(refreshed) != 1 || refreshed[0] != "apps/app1/channels/stable.json" {
+		t.Fatalf("Expected only the stable channel to be refreshed, got %v", refreshed)
+	}
+
+	m, _, err := FetchAndVerifyManifest(ctx, b, "repo1", "app1", "stable", "", pubkeyPath, pubSha, SignaturePolicy{})
+	if err != nil {
+		t.Fatalf("Expected refreshed manifest to verify, got %v", err)
+	}
+	if !m.Payload.ExpiresAt.Equal(expiresAt.UTC()) {
+		t.Errorf("Expected expiry %s, got %s", expiresAt.UTC(), m.Payload.ExpiresAt)
+	}
+	if !m.Payload.GeneratedAt.Equal(expired.GeneratedAt) {
+		t.Errorf("Expected GeneratedAt to be kept, got %s", m.Payload.GeneratedAt)
+	}
+
+	// Release manifests are not touched.
+	rel, err := GetManifest(ctx, b, "apps/app1/releases/v1.0.0/artifacts.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rel.Payload.ExpiresAt.Equal(expired.ExpiresAt) {
+		t.Errorf("Expected release manifest to be unchanged, got expiry %s", rel.Payload.ExpiresAt)
+	}
+}
+
+func TestRefreshKeepsCosignedManifests(t *testing.T) {
+	root, repoFp := newTestRepo(t)
+	b := backend.NewFileBackend(root)
+	ctx := context.Background()
+	pubkeyPath := "repo/public-keys/ed25519.pub"
+	cosignSeed := "AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI="
+	cosignKey, _ := sign.SeedToPubKey(cosignSeed)
+	policy := SignaturePolicy{CosignerKeys: []string{sign.SHA256(cosignKey)}, Threshold: 2}
+
+	writeManifest(t, root, "apps/app1/channels/stable.json", "repo1", "app1", "stable", "1.0.0")
+	writeManifest(t, root, "apps/app1/releases/v1.0.0/artifacts.json", "repo1", "app1", "stable", "1.0.0")
+	if _, err := CosignRelease(ctx, b, "repo1", "app1", "1.0.0", pubkeyPath, repoFp, cosignSeed); err != nil {
+		t.Fatalf("CosignRelease failed: %v", err)
+	}
+
+	expiresAt := time.Now().Add(30 * 24 * time.Hour)
+	refreshed, err := RefreshChannelManifests(ctx, b, "app1", testSeed, expiresAt, false)
+	if err == nil || len(refreshed) != 0 {
+		t.Errorf("Expected cosigned manifest to be skipped, got %v, %v", refreshed, err)
+	}
+	if _, _, err := FetchAndVerifyManifest(ctx, b, "repo1", "app1", "stable", "", pubkeyPath, repoFp, policy); err != nil {
+		t.Errorf("Expected cosigned manifest to still satisfy the threshold, got %v", err)
+	}
+
+	refreshed, err = RefreshChannelManifests(ctx, b, "app1", testSeed, expiresAt, true)
+	if err != nil || len(refreshed) != 1 {
+		t.Fatalf("Expected forced refresh to re-sign the manifest, got %v, %v", refreshed, err)
+	}
+	if _, _, err := FetchAndVerifyManifest(ctx, b, "repo1", "app1", "stable", "", pubkeyPath, repoFp, policy); err == nil {
+		t.Error("Expected forced refresh to drop the cosignature")
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"30d", 30 * 24 * time.Hour},
+		{"12h", 12 * time.Hour},
+	}
+	for _, tt := range tests {
+		got, err := ParseTTL(tt.in)
+		if err != nil {
+			t.Errorf("ParseTTL(%q) failed: %v", tt.in, err)
+		} else if got != tt.expected {
+			t.Errorf("ParseTTL(%q): expected %v, got %v", tt.in, tt.expected, got)
+		}
+	}
+	for _, bad := range []string{"soon", "-1h", "xd"} {
+		if _, err := ParseTTL(bad); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package support
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+	"github.com/alapierre/itrust-updater/pkg/trust"
+)
+
+func TestKeyRotation(t *testing.T) {
+	root, oldFp := newTestRepo(t)
+	b := backend.NewFileBackend(root)
+	ctx := context.Background()
+	pubkeyPath := "repo/public-keys/ed25519.pub"
+	newSeed := "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="
+
+	writeManifest(t, root, "apps/app1/channels/stable.json", "repo1", "app1", "stable", "1.0.0")
+	writeManifest(t, root, "apps/app1/releases/v1.0.0/artifacts.json", "repo1", "app1", "stable", "1.0.0")
+	index, err := manifest.SignIndex(manifest.IndexPayload{
+		SchemaVersion: 1,
+		Repo:          manifest.RepoInfo{ID: "repo1"},
+		App:           manifest.AppInfo{ID: "app1"},
+		Releases:      []manifest.IndexEntry{{Version: "1.0.0", Channels: []string{"stable"}}},
+	}, testSeed, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PutIndex(ctx, b, manifest.IndexPath("app1"), index); err != nil {
+		t.Fatal(err)
+	}
+
+	oldPubKey, _ := sign.SeedToPubKey(testSeed)
+	newPubKey, _ := sign.SeedToPubKey(newSeed)
+	newFp := sign.SHA256(newPubKey)
+	if _, err := trust.PublishTransition(ctx, b, "repo1", pubkeyPath, testSeed, newPubKey, "k"); err != nil {
+		t.Fatalf("PublishTransition failed: %v", err)
+	}
+	cosigned, err := AddRotationSignatures(ctx, b, oldPubKey, newSeed)
+	if err != nil {
+		t.Fatalf("AddRotationSignatures failed: %v", err)
+	}
+	if len(cosigned) != 2 {
+		t.Errorf("Expected 2 manifests signed with the new key, got %v", cosigned)
+	}
+
+	// Manifests verify on both sides of the switch of the published key.
+	for _, activate := range []bool{false, true} {
+		if activate {
+			if err := trust.ActivateKey(ctx, b, "repo1", pubkeyPath, oldPubKey, newSeed, "k"); err != nil {
+				t.Fatalf("ActivateKey failed: %v", err)
+			}
+		}
+		for _, version := range []string{"", "1.0.0"} {
+			if _, _, err := FetchAndVerifyManifest(ctx, b, "repo1", "app1", "stable", version, pubkeyPath, oldFp, SignaturePolicy{}); err != nil {
+				t.Errorf("Expected manifest to verify during the rotation (activated %v), got %v", activate, err)
+			}
+		}
+	}
+
+	resigned, err := ResignManifests(ctx, b, oldPubKey, newSeed)
+	if err != nil {
+		t.Fatalf("ResignManifests failed: %v", err)
+	}
+	if len(resigned) != 3 {
+		t.Errorf("Expected 2 re-signed manifests and the index, got %v", resigned)
+	}
+	// An interrupted rotation is finished by running it again.
+	if resigned, err := ResignManifests(ctx, b, oldPubKey, newSeed); err != nil || len(resigned) != 2 {
+		t.Errorf("Expected re-running to re-sign only the manifests, got %v, %v", resigned, err)
+	}
+	m, err := GetManifest(ctx, b, "apps/app1/channels/stable.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.AllSignatures()) != 1 || m.VerifySignature(newPubKey) != nil {
+		t.Errorf("Expected only the new key's signature, got %+v", m.AllSignatures())
+	}
+	for _, version := range []string{"", "1.0.0"} {
+		_, v, err := FetchAndVerifyManifest(ctx, b, "repo1", "app1", "stable", version, pubkeyPath, oldFp, SignaturePolicy{})
+		if err != nil {
+			t.Fatalf("Expected client pinned to the old key to follow the rotation, got %v", err)
+		}
+		if sign.SHA256(v.RepoKey) != newFp {
+			t.Errorf("Expected new key, got %s", sign.SHA256(v.RepoKey))
+		}
+	}
+
+	if _, _, err := FetchAndVerifyIndex(ctx, b, "repo1", "app1", pubkeyPath, oldFp); err != nil {
+		t.Errorf("Expected re-signed index to verify, got %v", err)
+	}
+
+	// The retired seed can no longer publish.
+	if err := CheckSigningSeed(ctx, b, pubkeyPath, testSeed); err == nil || !strings.Contains(err.Error(), "ITRUST_REPO_SIGNING_ED25519_SEED_B64") {
+		t.Errorf("Expected the retired seed to be refused, got %v", err)
+	}
+	if err := CheckSigningSeed(ctx, b, pubkeyPath, newSeed); err != nil {
+		t.Errorf("Expected the new seed to be accepted, got %v", err)
+	}
+
+	// Client config pins are updated in place.
+	configDir := t.TempDir()
+	profilePath := filepath.Join(configDir, "apps", "app1.env")
+	repoPath := filepath.Join(configDir, "repos", "repo1.env")
+	for _, p := range []string{profilePath, repoPath} {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(profilePath, []byte("ITRUST_APP_ID=app1\nITRUST_REPO_ID=repo1\n"), 0600)
+	os.WriteFile(repoPath, []byte("ITRUST_REPO_ID=repo1\nITRUST_REPO_PUBKEY_SHA256="+oldFp+"\n"), 0600)
+
+	updated, err := UpdatePinnedFingerprint(configDir, "app1", "repo1", oldFp, newFp)
+	if err != nil {
+		t.Fatalf("UpdatePinnedFingerprint failed: %v", err)
+	}
+	if len(updated) != 1 || updated[0] != repoPath {
+		t.Errorf("Expected only the repo config to be updated, got %v", updated)
+	}
+	data, _ := os.ReadFile(repoPath)
+	if !strings.Contains(string(data), "ITRUST_REPO_PUBKEY_SHA256="+newFp) {
+		t.Errorf("Expected new pin in repo config, got %s", data)
+	}
+}
@@ -0,0 +1,82 @@
+package support
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/config"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+	"github.com/zalando/go-keyring"
+)
+
+// ResolveSigningSeed returns the repository signing seed using the hierarchy
+// ENV/config > OS keyring (for repoID) > legacy keyring entry.
+func ResolveSigningSeed(cfg config.Config, repoID string, useKeyring bool) (string, error) {
+	seed := cfg.Get("ITRUST_REPO_SIGNING_ED25519_SEED_B64", os.Getenv("ITRUST_REPO_SIGNING_ED25519_SEED_B64"))
+	if seed == "" && useKeyring && repoID != "" {
+		logger.Debug("Attempting to get signing seed from keyring")
+		seed, _ = secretStore.Get("itrust-updater", "signing:"+repoID+":ed25519-seed-b64")
+	}
+	if seed == "" && useKeyring {
+		seed, _ = keyring.Get("itrust-updater-sign", repoID)
+	}
+	if seed == "" {
+		return "", fmt.Errorf("repository signing seed missing (ITRUST_REPO_SIGNING_ED25519_SEED_B64)")
+	}
+	return seed, nil
+}
+
+// CheckSigningSeed returns an error unless seed belongs to the repository key
+// published at pubkeyPath. After a key rotation a stale seed, e.g. one still
+// set in CI, would otherwise sign manifests that no client accepts.
+func CheckSigningSeed(ctx context.Context, b backend.Backend, pubkeyPath, seed string) error {
+	pubKey, err := sign.SeedToPubKey(seed)
+	if err != nil {
+		return fmt.Errorf("failed to derive public key: %w", err)
+	}
+	rc, err := b.Get(ctx, pubkeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to get repository public key: %w", err)
+	}
+	published, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read repository public key: %w", err)
+	}
+	if fp := sign.SHA256(pubKey); fp != sign.SHA256(published) {
+		return fmt.Errorf("signing seed belongs to key %s, but the repository publishes key %s; if the key was rotated, replace ITRUST_REPO_SIGNING_ED25519_SEED_B64 with the new seed", fp, sign.SHA256(published))
+	}
+	return nil
+}
+
+// SigningKeyID returns the key ID recorded in new signatures.
+func SigningKeyID() string {
+	return "repo-key-" + time.Now().Format("2006-01")
+}
+
+// ParseTTL parses a manifest lifetime such as "720h" or "30d". An empty
+// string or "0" means no expiry.
+func ParseTTL(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid TTL %q (expected e.g. 30d or 720h)", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid TTL %q (expected e.g. 30d or 720h)", s)
+	}
+	return d, nil
+}
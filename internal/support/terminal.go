@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/alapierre/itrust-updater/pkg/progress"
 	"golang.org/x/term"
 )
 
@@ -17,3 +19,15 @@ func ReadPassword(prompt string) (string, error) {
 	fmt.Println() // Add a newline after the password entry
 	return strings.TrimSpace(string(bytePassword)), nil
 }
+
+// NewProgressReporter renders a progress bar when stdout is a terminal and
+// prints periodic progress lines (also logged) otherwise.
+func NewProgressReporter() progress.Reporter {
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		return progress.NewBar(os.Stdout)
+	}
+	return progress.NewLineReporter(func(line string) {
+		fmt.Println(line)
+		logger.Info(line)
+	}, 10*time.Second)
+}
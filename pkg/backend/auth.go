@@ -0,0 +1,100 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/config"
+)
+
+// AuthProvider returns the Authorization header value for a request, or an
+// empty string for anonymous access. It is called for every request, so
+// providers can refresh short-lived tokens.
+type AuthProvider func(ctx context.Context) (string, error)
+
+// BasicAuth authenticates with a username and password. Nexus user tokens are
+// used the same way, with the name code as username and the pass code as password.
+func BasicAuth(username, password string) AuthProvider {
+	value := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	return func(context.Context) (string, error) {
+		return value, nil
+	}
+}
+
+// BearerAuth authenticates with a bearer token, e.g. one obtained from a CI OIDC exchange.
+func BearerAuth(token string) AuthProvider {
+	return func(context.Context) (string, error) {
+		return "Bearer " + token, nil
+	}
+}
+
+// authCommandTTL is how long the output of an auth command is reused.
+const authCommandTTL = 5 * time.Minute
+
+// CommandAuth runs command (split on whitespace, like ITRUST_PREPUSH_HOOK) and
+// uses its trimmed standard output as the Authorization header. Output
+// without a scheme (no space) is treated as a bearer token. The result is
+// cached for a few minutes so the command does not run for every request.
+func CommandAuth(command string) AuthProvider {
+	var mu sync.Mutex
+	var value string
+	var fetched time.Time
+	return func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if value != "" && time.Since(fetched) < authCommandTTL {
+			return value, nil
+		}
+		parts := strings.Fields(command)
+		if len(parts) == 0 {
+			return "", fmt.Errorf("auth command is empty")
+		}
+		var stdout bytes.Buffer
+		cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("auth command failed: %v", err)
+		}
+		out := strings.TrimSpace(stdout.String())
+		if out == "" {
+			return "", fmt.Errorf("auth command printed no credentials")
+		}
+		if !strings.Contains(out, " ") {
+			out = "Bearer " + out
+		}
+		value, fetched = out, time.Now()
+		return value, nil
+	}
+}
+
+// NexusAuthFromConfig selects the Nexus authentication method from cfg, in
+// order of precedence: ITRUST_NEXUS_AUTH_COMMAND, ITRUST_NEXUS_TOKEN (bearer),
+// ITRUST_NEXUS_USER_TOKEN ("namecode:passcode") and finally
+// ITRUST_NEXUS_USERNAME/ITRUST_NEXUS_PASSWORD. It returns nil for anonymous access.
+func NexusAuthFromConfig(cfg config.Config) (AuthProvider, error) {
+	if command := cfg.Get("ITRUST_NEXUS_AUTH_COMMAND", ""); command != "" {
+		return CommandAuth(command), nil
+	}
+	if token := cfg.Get("ITRUST_NEXUS_TOKEN", ""); token != "" {
+		return BearerAuth(token), nil
+	}
+	if userToken := cfg.Get("ITRUST_NEXUS_USER_TOKEN", ""); userToken != "" {
+		nameCode, passCode, ok := strings.Cut(userToken, ":")
+		if !ok || nameCode == "" || passCode == "" {
+			return nil, fmt.Errorf("ITRUST_NEXUS_USER_TOKEN must have the form <name code>:<pass code>")
+		}
+		return BasicAuth(nameCode, passCode), nil
+	}
+	if username := cfg.Get("ITRUST_NEXUS_USERNAME", ""); username != "" {
+		return BasicAuth(username, cfg.Get("ITRUST_NEXUS_PASSWORD", "")), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"testing"
+
+	"github.com/alapierre/itrust-updater/pkg/config"
+)
+
+func TestNexusAuthFromConfig(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		cfg      config.Config
+		expected string
+	}{
+		{"anonymous", config.Config{}, ""},
+		{"password", config.Config{"ITRUST_NEXUS_USERNAME": "user", "ITRUST_NEXUS_PASSWORD": "pass"}, "Basic dXNlcjpwYXNz"},
+		{"user token", config.Config{"ITRUST_NEXUS_USER_TOKEN": "name:code", "ITRUST_NEXUS_USERNAME": "user"}, "Basic bmFtZTpjb2Rl"},
+		{"bearer wins", config.Config{"ITRUST_NEXUS_TOKEN": "abc", "ITRUST_NEXUS_USER_TOKEN": "name:code"}, "Bearer abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg["ITRUST_BASE_URL"] = srv.URL + "/repository/raw"
+			b, err := Open(tt.cfg)
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			got = "unset"
+			if _, err := b.Exists(context.Background(), "x"); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("Expected Authorization %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	if _, err := NexusAuthFromConfig(config.Config{"ITRUST_NEXUS_USER_TOKEN": "no-passcode"}); err == nil {
+		t.Error("Expected error for malformed user token")
+	}
+}
+
+func TestCommandAuth(t *testing.T) {
+	if _, err := exec.LookPath("echo"); err != nil {
+		t.Skip("echo not available")
+	}
+
+	value, err := CommandAuth("echo token-from-ci")(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "Bearer token-from-ci" {
+		t.Errorf("Expected bearer token, got %q", value)
+	}
+
+	value, err = CommandAuth("echo Basic Zm9vOmJhcg==")(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "Basic Zm9vOmJhcg==" {
+		t.Errorf("Expected full header value, got %q", value)
+	}
+
+	if _, err := CommandAuth("false")(context.Background()); err == nil {
+		t.Error("Expected error for failing command")
+	}
+}
@@ -3,10 +3,29 @@ package backend
 import (
 	"context"
 	"io"
+	"time"
 )
 
+// ObjectInfo describes an object stored in a backend.
+type ObjectInfo struct {
+	Path         string
+	Size         int64
+	LastModified time.Time
+}
+
 type Backend interface {
 	Get(ctx context.Context, path string) (io.ReadCloser, error)
 	Put(ctx context.Context, path string, openBody func() (io.ReadCloser, error), contentType string) error
 	Exists(ctx context.Context, path string) (bool, error)
+	// List returns all objects whose path starts with prefix, sorted by path.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Delete removes the object at path. Deleting a missing object is not an error.
+	Delete(ctx context.Context, path string) error
+}
+
+// RangeGetter is implemented by backends able to resume interrupted downloads.
+type RangeGetter interface {
+	// GetRange returns the object content starting at offset. partial reports
+	// whether the range was honoured; when false the reader starts at byte zero.
+	GetRange(ctx context.Context, path string, offset int64) (rc io.ReadCloser, partial bool, err error)
 }
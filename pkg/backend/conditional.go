@@ -0,0 +1,261 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrPreconditionFailed is returned by PutIfMatch when the object was modified
+// since its version was read.
+var ErrPreconditionFailed = errors.New("object was modified concurrently")
+
+// ConditionalPutter is implemented by backends able to detect concurrent
+// modification of an object, e.g. with ETag and If-Match.
+type ConditionalPutter interface {
+	// GetVersion returns the object content together with an opaque version.
+	// For a missing object it returns a nil reader and an empty version.
+	GetVersion(ctx context.Context, path string) (rc io.ReadCloser, version string, err error)
+	// PutIfMatch stores the object only if its current version equals
+	// version; an empty version requires that the object does not exist.
+	// Otherwise it returns ErrPreconditionFailed.
+	PutIfMatch(ctx context.Context, path string, openBody func() (io.ReadCloser, error), contentType, version string) error
+}
+
+// ConditionalProber is implemented by ConditionalPutters that depend on the
+// server to honour conditional requests. Many S3-compatible stores accept
+// If-Match and If-None-Match but ignore them, so PutIfMatch must not be relied
+// upon unless ConditionalWritesHonoured reports true.
+type ConditionalProber interface {
+	ConditionalWritesHonoured(ctx context.Context) (bool, error)
+}
+
+// s3ProbePath is the object written to find out whether an S3 server honours
+// conditional writes.
+const s3ProbePath = ".itrust-conditional-probe"
+
+// fileLockTimeout bounds how long PutIfMatch waits for another writer; a lock
+// older than fileLockStale is considered abandoned.
+var (
+	fileLockTimeout = 10 * time.Second
+	fileLockStale   = time.Minute
+)
+
+// GetVersion implements ConditionalPutter; the version is the SHA256 of the
+// content.
+func (f *FileBackend) GetVersion(ctx context.Context, path string) (io.ReadCloser, string, error) {
+	full, err := f.resolve(path)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(full)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", nil
+		}
+		return nil, "", fmt.Errorf("failed to get %s: %v", full, err)
+	}
+	return io.NopCloser(bytes.NewReader(data)), contentVersion(data), nil
+}
+
+// PutIfMatch implements ConditionalPutter. The check and the write are
+// serialized with a lock file next to the object, so it also holds between
+// processes sharing the directory.
+func (f *FileBackend) PutIfMatch(ctx context.Context, path string, openBody func() (io.ReadCloser, error), contentType, version string) error {
+	full, err := f.resolve(path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return fmt.Errorf("failed to put %s: %v", full, err)
+	}
+	unlock, err := lockFile(ctx, filepath.Join(filepath.Dir(full), filePutTempPrefix+filepath.Base(full)+".lock"))
+	if err != nil {
+		return fmt.Errorf("failed to put %s: %v", full, err)
+	}
+	defer unlock()
+
+	current := ""
+	data, err := os.ReadFile(full)
+	if err == nil {
+		current = contentVersion(data)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to put %s: %v", full, err)
+	}
+	if current != version {
+		return ErrPreconditionFailed
+	}
+	return f.Put(ctx, path, openBody, contentType)
+}
+
+func contentVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func lockFile(ctx context.Context, lockPath string) (func(), error) {
+	// The token identifies this holder's lock file; file identity alone is not
+	// enough, as inode numbers are reused.
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(buf[:])
+
+	deadline := time.Now().Add(fileLockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = f.WriteString(token)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(lockPath)
+				return nil, err
+			}
+			return func() {
+				// A lock taken over as stale belongs to someone else now.
+				if data, err := os.ReadFile(lockPath); err == nil && string(data) == token {
+					os.Remove(lockPath)
+				}
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if holder, err := os.ReadFile(lockPath); err == nil {
+			if fi, err := os.Stat(lockPath); err == nil && time.Since(fi.ModTime()) > fileLockStale {
+				removeStaleLock(lockPath, fi, string(holder))
+				continue
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock %s", lockPath)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// removeStaleLock removes the lock file stale of holder, as found at
+// lockPath. Another writer may have replaced it in the meantime, so it is
+// first moved aside, which only one of several writers can do, and put back
+// if it turns out not to be the stale one.
+func removeStaleLock(lockPath string, stale os.FileInfo, holder string) {
+	aside := fmt.Sprintf("%s.stale-%d-%d", lockPath, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(lockPath, aside); err != nil {
+		return
+	}
+	defer os.Remove(aside)
+	fi, err := os.Stat(aside)
+	data, rerr := os.ReadFile(aside)
+	if err != nil || rerr != nil || !os.SameFile(fi, stale) || string(data) != holder {
+		// A fresh lock: restore it unless yet another writer got in.
+		os.Link(aside, lockPath)
+		return
+	}
+	logger.Warnf("Removed stale lock %s", lockPath)
+}
+
+// GetVersion implements ConditionalPutter using the object's ETag.
+func (s *S3Backend) GetVersion(ctx context.Context, path string) (io.ReadCloser, string, error) {
+	resp, err := s.execute(ctx, "GET", s.objectURL(path), nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, resp.Header.Get("ETag"), nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, "", nil
+	default:
+		resp.Body.Close()
+		return nil, "", fmt.Errorf("failed to get %s: %s", s.objectURL(path), resp.Status)
+	}
+}
+
+// PutIfMatch implements ConditionalPutter with S3 conditional writes
+// (If-Match, or If-None-Match: * for new objects).
+func (s *S3Backend) PutIfMatch(ctx context.Context, path string, openBody func() (io.ReadCloser, error), contentType, version string) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if version == "" {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", version)
+	}
+	resp, err := s.execute(ctx, "PUT", s.objectURL(path), openBody, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		// 409 signals a conflicting conditional write in progress.
+		return ErrPreconditionFailed
+	default:
+		return fmt.Errorf("failed to put %s: %s", s.objectURL(path), resp.Status)
+	}
+}
+
+// ConditionalWritesHonoured implements ConditionalProber. Unless
+// ConditionalWrites says otherwise, it writes a probe object and checks that
+// conditional writes which must fail (If-None-Match: * on an existing object,
+// If-Match with a wrong ETag) are refused. The answer is cached.
+func (s *S3Backend) ConditionalWritesHonoured(ctx context.Context) (bool, error) {
+	switch s.ConditionalWrites {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	s.probeMu.Lock()
+	defer s.probeMu.Unlock()
+	if s.honoured != nil {
+		return *s.honoured, nil
+	}
+
+	probe := func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("itrust-updater conditional write probe\n")), nil
+	}
+	if err := s.Put(ctx, s3ProbePath, probe, "text/plain"); err != nil {
+		return false, fmt.Errorf("failed to probe conditional writes: %v", err)
+	}
+	honoured := true
+	for _, version := range []string{"", `"itrust-probe-mismatch"`} {
+		err := s.PutIfMatch(ctx, s3ProbePath, probe, "text/plain", version)
+		if errors.Is(err, ErrPreconditionFailed) {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to probe conditional writes: %v", err)
+		}
+		honoured = false
+		break
+	}
+	if !honoured {
+		logger.Warnf("%s ignores conditional writes, updates are serialized with a lock object instead", s.bucketURL())
+	}
+	s.honoured = &honoured
+	return honoured, nil
+}
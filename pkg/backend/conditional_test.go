@@ -0,0 +1,191 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func body(s string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(s)), nil
+	}
+}
+
+func testConditionalPut(t *testing.T, b ConditionalPutter) {
+	t.Helper()
+	ctx := context.Background()
+
+	rc, version, err := b.GetVersion(ctx, "apps/a/artifacts.json")
+	if err != nil || rc != nil || version != "" {
+		t.Fatalf("Expected missing object, got %v, %q, %v", rc, version, err)
+	}
+	if err := b.PutIfMatch(ctx, "apps/a/artifacts.json", body("one"), "application/json", ""); err != nil {
+		t.Fatalf("PutIfMatch failed: %v", err)
+	}
+	if err := b.PutIfMatch(ctx, "apps/a/artifacts.json", body("two"), "application/json", ""); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed creating an existing object, got %v", err)
+	}
+
+	rc, version, err = b.GetVersion(ctx, "apps/a/artifacts.json")
+	if err != nil || rc == nil {
+		t.Fatalf("GetVersion failed: %v", err)
+	}
+	content, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(content) != "one" || version == "" {
+		t.Errorf("Expected content 'one' with a version, got %q, %q", content, version)
+	}
+
+	if err := b.PutIfMatch(ctx, "apps/a/artifacts.json", body("two"), "application/json", version); err != nil {
+		t.Fatalf("PutIfMatch failed: %v", err)
+	}
+	if err := b.PutIfMatch(ctx, "apps/a/artifacts.json", body("three"), "application/json", version); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed for a stale version, got %v", err)
+	}
+}
+
+func TestFileBackend_PutIfMatch(t *testing.T) {
+	b := NewFileBackend(t.TempDir())
+	testConditionalPut(t, b)
+
+	objects, err := b.List(context.Background(), "")
+	if err != nil || len(objects) != 1 {
+		t.Errorf("Expected the lock file to be gone, got %v (err: %v)", objects, err)
+	}
+}
+
+// newConditionalS3 returns an S3 backend talking to an in-memory server that
+// honours or ignores conditional writes. puts counts the PUT requests.
+func newConditionalS3(t *testing.T, honour bool, puts *atomic.Int32) *S3Backend {
+	t.Helper()
+	var mu sync.Mutex
+	objects := map[string]string{}
+	etag := func(v string) string {
+		sum := sha256.Sum256([]byte(v))
+		return `"` + hex.EncodeToString(sum[:8]) + `"`
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		v, ok := objects[r.URL.Path]
+		switch r.Method {
+		case "PUT":
+			puts.Add(1)
+			if honour && ((r.Header.Get("If-None-Match") == "*" && ok) || (r.Header.Get("If-Match") != "" && (!ok || r.Header.Get("If-Match") != etag(v)))) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			data, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = string(data)
+			w.WriteHeader(http.StatusOK)
+		case "GET":
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("ETag", etag(v))
+			w.Write([]byte(v))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	b, err := NewS3Backend(S3Options{Endpoint: ts.URL, Bucket: "updates", AccessKey: "minio", SecretKey: "minio123", PathStyle: true})
+	if err != nil {
+		t.Fatalf("NewS3Backend failed: %v", err)
+	}
+	return b
+}
+
+func TestS3Backend_PutIfMatch(t *testing.T) {
+	var puts atomic.Int32
+	testConditionalPut(t, newConditionalS3(t, true, &puts))
+}
+
+func TestS3Backend_ConditionalWritesHonoured(t *testing.T) {
+	ctx := context.Background()
+	for _, honour := range []bool{true, false} {
+		var puts atomic.Int32
+		b := newConditionalS3(t, honour, &puts)
+		for i := 0; i < 2; i++ {
+			got, err := b.ConditionalWritesHonoured(ctx)
+			if err != nil {
+				t.Fatalf("ConditionalWritesHonoured failed: %v", err)
+			}
+			if got != honour {
+				t.Errorf("Expected %v, got %v", honour, got)
+			}
+		}
+		if n := puts.Load(); n > 3 {
+			t.Errorf("Expected the probe to run once, got %d PUT requests", n)
+		}
+
+		b.ConditionalWrites = "false"
+		if got, _ := b.ConditionalWritesHonoured(ctx); got {
+			t.Error("Expected ConditionalWrites=false to disable conditional writes")
+		}
+	}
+}
+
+func TestLockFileTakesOverStaleLockOnce(t *testing.T) {
+	ctx := context.Background()
+	lockPath := filepath.Join(t.TempDir(), "artifacts.json.lock")
+	if err := os.WriteFile(lockPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * fileLockStale)
+	if err := os.Chtimes(lockPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writers racing for the stale lock must still hold it one at a time.
+	var holders, overlaps atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := lockFile(ctx, lockPath)
+			if err != nil {
+				t.Errorf("lockFile failed: %v", err)
+				return
+			}
+			if holders.Add(1) > 1 {
+				overlaps.Add(1)
+			}
+			time.Sleep(5 * time.Millisecond)
+			holders.Add(-1)
+			unlock()
+		}()
+	}
+	wg.Wait()
+	if overlaps.Load() != 0 {
+		t.Errorf("Expected the lock to be held by one writer at a time, got %d overlaps", overlaps.Load())
+	}
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the lock to be released, got %v", err)
+	}
+
+	// Releasing a lock that was taken over leaves the new holder's lock alone.
+	unlock, err := lockFile(ctx, lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(lockPath)
+	os.WriteFile(lockPath, nil, 0644)
+	unlock()
+	if _, err := os.Stat(lockPath); err != nil {
+		t.Errorf("Expected the other writer's lock to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const filePutTempPrefix = ".itrust-put-"
+
+// FileBackend serves a repository from a plain directory tree, e.g. a USB drive
+// or a network share used for air-gapped installations.
+type FileBackend struct {
+	Root string
+}
+
+func NewFileBackend(root string) *FileBackend {
+	return &FileBackend{Root: root}
+}
+
+// FileRootFromURL converts a file:// URL into a local directory path.
+// file:///mnt/updates, file:///C:/updates and, on Windows, file://server/share
+// are supported.
+func FileRootFromURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid file URL %s: %v", rawURL, err)
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("invalid file URL %s: scheme must be file", rawURL)
+	}
+
+	p := u.Path
+	if u.Host != "" && u.Host != "localhost" {
+		// UNC path: file://server/share/dir
+		if runtime.GOOS != "windows" {
+			return "", fmt.Errorf("invalid file URL %s: network shares are only supported on Windows, use the path the share is mounted at", rawURL)
+		}
+		return `\\` + u.Host + filepath.FromSlash(p), nil
+	}
+	if runtime.GOOS == "windows" && len(p) >= 3 && p[0] == '/' && p[2] == ':' {
+		p = p[1:]
+	}
+	if p == "" {
+		return "", fmt.Errorf("invalid file URL %s: missing path", rawURL)
+	}
+	return filepath.FromSlash(p), nil
+}
+
+func (f *FileBackend) resolve(p string) (string, error) {
+	clean := path.Clean("/" + strings.TrimPrefix(p, "/"))
+	rel := strings.TrimPrefix(clean, "/")
+	if rel == "" || !filepath.IsLocal(filepath.FromSlash(rel)) {
+		return "", fmt.Errorf("invalid repository path: %s", p)
+	}
+	return filepath.Join(f.Root, filepath.FromSlash(rel)), nil
+}
+
+func (f *FileBackend) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	full, err := f.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(full)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %v", full, err)
+	}
+	return file, nil
+}
+
+// GetRange implements RangeGetter by seeking in the local file.
+func (f *FileBackend) GetRange(ctx context.Context, path string, offset int64) (io.ReadCloser, bool, error) {
+	rc, err := f.Get(ctx, path)
+	if err != nil {
+		return nil, false, err
+	}
+	file := rc.(*os.File)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, false, fmt.Errorf("failed to seek in %s: %v", file.Name(), err)
+	}
+	return file, true, nil
+}
+
+func (f *FileBackend) Put(ctx context.Context, path string, openBody func() (io.ReadCloser, error), contentType string) error {
+	full, err := f.resolve(path)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(full)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to put %s: %v", full, err)
+	}
+
+	body, err := openBody()
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	tempFile, err := os.CreateTemp(dir, filePutTempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to put %s: %v", full, err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := io.Copy(tempFile, body); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("failed to put %s: %v", full, err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to put %s: %v", full, err)
+	}
+	if err := os.Chmod(tempFile.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to put %s: %v", full, err)
+	}
+
+	if err := os.Rename(tempFile.Name(), full); err != nil {
+		return fmt.Errorf("failed to put %s: %v", full, err)
+	}
+	return nil
+}
+
+func (f *FileBackend) Exists(ctx context.Context, path string) (bool, error) {
+	full, err := f.resolve(path)
+	if err != nil {
+		return false, err
+	}
+	fi, err := os.Stat(full)
+	if err == nil {
+		return fi.Mode().IsRegular(), nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check existence of %s: %v", full, err)
+}
+
+func (f *FileBackend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	// Only the directory holding the prefix needs to be walked.
+	dir := prefix
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir)
+	}
+	start := f.Root
+	if dir = strings.Trim(path.Clean(dir), "/"); dir != "." && dir != "" {
+		if !filepath.IsLocal(filepath.FromSlash(dir)) {
+			return nil, fmt.Errorf("invalid repository prefix: %s", prefix)
+		}
+		start = filepath.Join(f.Root, filepath.FromSlash(dir))
+	}
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == start {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), filePutTempPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(f.Root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !strings.HasPrefix(rel, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Path: rel, Size: fi.Size(), LastModified: fi.ModTime().UTC()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %v", f.Root, err)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Path < objects[j].Path })
+	return objects, nil
+}
+
+func (f *FileBackend) Delete(ctx context.Context, path string) error {
+	full, err := f.resolve(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(full); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete %s: %v", full, err)
+	}
+	return nil
+}
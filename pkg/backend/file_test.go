@@ -0,0 +1,142 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestFileBackend(t *testing.T) {
+	root := t.TempDir()
+	ctx := context.Background()
+	b := NewFileBackend(root)
+
+	openBody := func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("data")), nil
+	}
+	if err := b.Put(ctx, "apps/app1/channels/stable.json", openBody, "application/json"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "apps", "app1", "channels", "stable.json")); err != nil {
+		t.Fatalf("File not written to repository layout: %v", err)
+	}
+
+	r, err := b.Get(ctx, "/apps/app1/channels/stable.json")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	content, _ := io.ReadAll(r)
+	r.Close()
+	if string(content) != "data" {
+		t.Errorf("Expected 'data', got %s", string(content))
+	}
+
+	exists, err := b.Exists(ctx, "apps/app1/channels/stable.json")
+	if err != nil || !exists {
+		t.Errorf("Expected file to exist, got %v (err: %v)", exists, err)
+	}
+	exists, err = b.Exists(ctx, "apps/app1/channels/beta.json")
+	if err != nil || exists {
+		t.Errorf("Expected file to be missing, got %v (err: %v)", exists, err)
+	}
+	if _, err := b.Get(ctx, "apps/app1/channels/beta.json"); err == nil {
+		t.Error("Get should fail for missing file")
+	}
+
+	if err := b.Put(ctx, "apps/app2/channels/stable.json", openBody, "application/json"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	objects, err := b.List(ctx, "apps/app1/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(objects) != 1 || objects[0].Path != "apps/app1/channels/stable.json" || objects[0].Size != 4 {
+		t.Errorf("Unexpected list result: %+v", objects)
+	}
+	for prefix, want := range map[string]int{"apps/app": 2, "apps/app2/chan": 1, "": 2, "apps/app3/": 0} {
+		objects, err := b.List(ctx, prefix)
+		if err != nil || len(objects) != want {
+			t.Errorf("List(%q): expected %d objects, got %+v (err: %v)", prefix, want, objects, err)
+		}
+	}
+	if _, err := b.List(ctx, "../"); err == nil {
+		t.Error("Expected error for a prefix outside the root")
+	}
+
+	if err := b.Delete(ctx, "apps/app1/channels/stable.json"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := b.Delete(ctx, "apps/app1/channels/stable.json"); err != nil {
+		t.Errorf("Delete of missing file should succeed, got %v", err)
+	}
+	exists, _ = b.Exists(ctx, "apps/app1/channels/stable.json")
+	if exists {
+		t.Error("Expected deleted file to be gone")
+	}
+}
+
+func TestFileBackend_PathEscape(t *testing.T) {
+	root := t.TempDir()
+	b := NewFileBackend(filepath.Join(root, "repo"))
+	os.WriteFile(filepath.Join(root, "secret.txt"), []byte("secret"), 0644)
+
+	r, err := b.Get(context.Background(), "../secret.txt")
+	if err == nil {
+		content, _ := io.ReadAll(r)
+		r.Close()
+		if string(content) == "secret" {
+			t.Fatal("Get escaped the repository root")
+		}
+	}
+}
+
+func TestFileRootFromURL(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix paths only")
+	}
+	tests := map[string]string{
+		"file:///mnt/updates":           "/mnt/updates",
+		"file://localhost/mnt/updates/": "/mnt/updates/",
+	}
+	for in, expected := range tests {
+		got, err := FileRootFromURL(in)
+		if err != nil {
+			t.Errorf("FileRootFromURL(%s) failed: %v", in, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("FileRootFromURL(%s): expected %s, got %s", in, expected, got)
+		}
+	}
+
+	if _, err := FileRootFromURL("https://nexus.example.com"); err == nil {
+		t.Error("Expected error for non-file URL")
+	}
+	if _, err := FileRootFromURL("file://server/share/updates"); err == nil {
+		t.Error("Expected error for a network share outside Windows")
+	}
+}
+
+func TestFileRootFromURLWindows(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("Windows paths only")
+	}
+	tests := map[string]string{
+		"file:///C:/updates":          `C:\updates`,
+		"file://server/share/updates": `\\server\share\updates`,
+	}
+	for in, expected := range tests {
+		got, err := FileRootFromURL(in)
+		if err != nil {
+			t.Errorf("FileRootFromURL(%s) failed: %v", in, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("FileRootFromURL(%s): expected %s, got %s", in, expected, got)
+		}
+	}
+}
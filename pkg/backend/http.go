@@ -0,0 +1,96 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// doWithRetry sends the request built by newRequest, retrying transient network
+// errors and retryable HTTP statuses with exponential backoff. newRequest is
+// called once per attempt so request bodies can be reopened.
+func doWithRetry(ctx context.Context, client *http.Client, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = 30 * time.Second
+
+	var attempt int
+	var resp *http.Response
+
+	operation := func() error {
+		attempt++
+		req, err := newRequest()
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+
+		resp, err = client.Do(req)
+		if err != nil {
+			if isRetryableError(err) {
+				logger.Debugf("Retrying %s %s, attempt %d, error: %v", req.Method, req.URL, attempt, err)
+				return err
+			}
+			return backoff.Permanent(err)
+		}
+
+		if isRetryableStatus(resp.StatusCode) {
+			logger.Debugf("Retrying %s %s, attempt %d, status: %d", req.Method, req.URL, attempt, resp.StatusCode)
+			resp.Body.Close()
+			return fmt.Errorf("server error: %d", resp.StatusCode)
+		}
+
+		return nil
+	}
+
+	err := backoff.Retry(operation, backoff.WithContext(expBackoff, ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func isRetryableError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if strings.Contains(err.Error(), "connection refused") ||
+		strings.Contains(err.Error(), "connection reset") ||
+		strings.Contains(err.Error(), "EOF") {
+		return true
+	}
+	return false
+}
+
+func isRetryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+}
+
+func rangeHeader(offset int64) http.Header {
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	return header
+}
+
+// rangeResponse interprets the response to a Range request. Servers ignoring
+// the Range header answer 200 with the full content.
+func rangeResponse(resp *http.Response, url string) (io.ReadCloser, bool, error) {
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, true, nil
+	case http.StatusOK:
+		return resp.Body, false, nil
+	default:
+		resp.Body.Close()
+		return nil, false, fmt.Errorf("failed to get %s: %s", url, resp.Status)
+	}
+}
@@ -0,0 +1,160 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/config"
+)
+
+const defaultHTTPTimeout = 30 * time.Second
+
+// NewHTTPClient returns the HTTP client shared by all HTTP-based backends,
+// configured from the profile/repo settings:
+//
+//	ITRUST_TLS_CA_FILE      PEM bundle trusted in addition to the system roots
+//	ITRUST_TLS_CLIENT_CERT  PEM client certificate for mutual TLS
+//	ITRUST_TLS_CLIENT_KEY   PEM private key of the client certificate
+//	ITRUST_TLS_PIN_SHA256   comma-separated SHA256 (hex) of certificate public keys (SPKI);
+//	                        one of them must appear in the server chain
+//	ITRUST_HTTP_PROXY       proxy URL, or "direct" to bypass HTTP(S)_PROXY
+//	ITRUST_HTTP_TIMEOUT     connect, TLS handshake, response header and idle
+//	                        read/write timeout, e.g. 30s or 5m (0 disables it)
+//
+// There is no limit on the whole request: a large artifact downloaded at a
+// limited rate takes as long as it takes, as long as data keeps flowing.
+func NewHTTPClient(cfg config.Config) (*http.Client, error) {
+	timeout := defaultHTTPTimeout
+	if v := cfg.Get("ITRUST_HTTP_TIMEOUT", ""); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid ITRUST_HTTP_TIMEOUT %q (expected e.g. 30s or 5m)", v)
+		}
+		timeout = d
+	}
+
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil || timeout == 0 {
+			return conn, err
+		}
+		return &idleTimeoutConn{Conn: conn, timeout: timeout}, nil
+	}
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+	switch proxy := cfg.Get("ITRUST_HTTP_PROXY", ""); proxy {
+	case "":
+		transport.Proxy = http.ProxyFromEnvironment
+	case "direct", "none":
+		transport.Proxy = nil
+	default:
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid ITRUST_HTTP_PROXY %q", proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// idleTimeoutConn fails a read or write that makes no progress for timeout.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *idleTimeoutConn) Write(p []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+func newTLSConfig(cfg config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile := cfg.Get("ITRUST_TLS_CA_FILE", ""); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ITRUST_TLS_CA_FILE: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	certFile := cfg.Get("ITRUST_TLS_CLIENT_CERT", "")
+	keyFile := cfg.Get("ITRUST_TLS_CLIENT_KEY", "")
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("both ITRUST_TLS_CLIENT_CERT and ITRUST_TLS_CLIENT_KEY are required for client certificates")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if pins := cfg.Get("ITRUST_TLS_PIN_SHA256", ""); pins != "" {
+		allowed := make(map[string]bool)
+		for _, pin := range strings.Split(pins, ",") {
+			pin = strings.ToLower(strings.TrimSpace(pin))
+			if pin != "" {
+				allowed[pin] = true
+			}
+		}
+		// Only certificates of a verified chain count: the server can send
+		// any certificate along with its own.
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, chain := range cs.VerifiedChains {
+				for _, cert := range chain {
+					if allowed[SPKIFingerprint(cert)] {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("server certificate for %s does not match ITRUST_TLS_PIN_SHA256", cs.ServerName)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// SPKIFingerprint returns the hex SHA256 of the certificate's public key info,
+// the value expected by ITRUST_TLS_PIN_SHA256.
+func SPKIFingerprint(cert *x509.Certificate) string {
+	h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(h[:])
+}
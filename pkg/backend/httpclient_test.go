@@ -0,0 +1,299 @@
+package backend
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/config"
+)
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func get(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestNewHTTPClientCustomCA(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	client, err := NewHTTPClient(config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := get(client, srv.URL); err == nil {
+		t.Fatal("Expected error for untrusted server certificate")
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", srv.Certificate().Raw)
+	client, err = NewHTTPClient(config.Config{"ITRUST_TLS_CA_FILE": caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := get(client, srv.URL); err != nil {
+		t.Errorf("Expected trusted connection, got %v", err)
+	}
+}
+
+func TestNewHTTPClientPinning(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", srv.Certificate().Raw)
+	pin := SPKIFingerprint(srv.Certificate())
+
+	tests := []struct {
+		name    string
+		pins    string
+		wantErr bool
+	}{
+		{"matching pin", pin, false},
+		{"one of several", "00ff, " + pin, false},
+		{"no match", "0000000000000000000000000000000000000000000000000000000000000000", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewHTTPClient(config.Config{"ITRUST_TLS_CA_FILE": caFile, "ITRUST_TLS_PIN_SHA256": tt.pins})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = get(client, srv.URL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error: %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// newTestCert creates a certificate for 127.0.0.1 signed by parent, or
+// self-signed if parent is nil.
+func newTestCert(t *testing.T, name string, isCA bool, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	issuer, signer := tmpl, any(key)
+	if parent != nil {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestNewHTTPClientPinIgnoresUnverifiedCertificates(t *testing.T) {
+	ca := newTestCert(t, "ca", true, nil)
+	leaf := newTestCert(t, "server", false, &ca)
+	pinned := newTestCert(t, "pinned", true, nil)
+
+	// The server appends the pinned certificate to its valid, unpinned chain.
+	served := leaf
+	served.Certificate = append(served.Certificate, pinned.Certificate[0])
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{served}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", ca.Leaf.Raw)
+	for pin, wantErr := range map[string]bool{SPKIFingerprint(pinned.Leaf): true, SPKIFingerprint(ca.Leaf): false} {
+		client, err := NewHTTPClient(config.Config{"ITRUST_TLS_CA_FILE": caFile, "ITRUST_TLS_PIN_SHA256": pin})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := get(client, srv.URL); (err != nil) != wantErr {
+			t.Errorf("Pin %s: expected error: %v, got %v", pin, wantErr, err)
+		}
+	}
+}
+
+func TestNewHTTPClientClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "itrust-client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "PRIVATE KEY", keyDER)
+
+	clientCert, _ := x509.ParseCertificate(der)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", srv.Certificate().Raw)
+
+	client, err := NewHTTPClient(config.Config{"ITRUST_TLS_CA_FILE": caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := get(client, srv.URL); err == nil {
+		t.Error("Expected error without client certificate")
+	}
+
+	client, err = NewHTTPClient(config.Config{
+		"ITRUST_TLS_CA_FILE":     caFile,
+		"ITRUST_TLS_CLIENT_CERT": certFile,
+		"ITRUST_TLS_CLIENT_KEY":  keyFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := get(client, srv.URL); err != nil {
+		t.Errorf("Expected mutual TLS connection, got %v", err)
+	}
+
+	if _, err := NewHTTPClient(config.Config{"ITRUST_TLS_CLIENT_CERT": certFile}); err == nil {
+		t.Error("Expected error for client certificate without key")
+	}
+}
+
+func TestNewHTTPClientProxyAndTimeout(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	client, err := NewHTTPClient(config.Config{"ITRUST_HTTP_PROXY": proxy.URL, "ITRUST_HTTP_TIMEOUT": "5m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt := client.Transport.(*http.Transport).ResponseHeaderTimeout; rt != 5*time.Minute {
+		t.Errorf("Expected timeout 5m, got %v", rt)
+	}
+	if err := get(client, "http://nexus.internal/repository/raw/x"); err != nil {
+		t.Fatal(err)
+	}
+	if proxied != "http://nexus.internal/repository/raw/x" {
+		t.Errorf("Expected request through proxy, got %q", proxied)
+	}
+
+	client, err = NewHTTPClient(config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt := client.Transport.(*http.Transport).ResponseHeaderTimeout; rt != defaultHTTPTimeout {
+		t.Errorf("Expected default timeout, got %v", rt)
+	}
+
+	for _, bad := range []config.Config{
+		{"ITRUST_HTTP_TIMEOUT": "soon"},
+		{"ITRUST_HTTP_PROXY": "::"},
+		{"ITRUST_TLS_CA_FILE": "/nonexistent/ca.pem"},
+	} {
+		if _, err := NewHTTPClient(bad); err == nil {
+			t.Errorf("Expected error for %v", bad)
+		}
+	}
+}
+
+func TestNewHTTPClientSlowBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stall := r.URL.Path == "/stall"
+		for i := 0; i < 8; i++ {
+			w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			delay := 50 * time.Millisecond
+			if stall && i == 1 {
+				delay = time.Second
+			}
+			time.Sleep(delay)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewHTTPClient(config.Config{"ITRUST_HTTP_TIMEOUT": "200ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A body that keeps flowing may take longer than the timeout.
+	resp, err := client.Get(srv.URL + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || len(data) != 40 {
+		t.Errorf("Expected complete body, got %d bytes (%v)", len(data), err)
+	}
+
+	// A body that stalls for longer than the timeout fails.
+	resp, err = client.Get(srv.URL + "/stall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err == nil {
+		t.Error("Expected stalled body to time out")
+	}
+}
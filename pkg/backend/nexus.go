@@ -2,16 +2,16 @@ package backend
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/alapierre/itrust-updater/pkg/logging"
-	"github.com/cenkalti/backoff/v4"
 )
 
 var logger = logging.Component("pkg/backend")
@@ -20,7 +20,10 @@ type NexusBackend struct {
 	BaseURL  string
 	Username string
 	Password string
-	Client   *http.Client
+	// Auth, when set, supplies the Authorization header instead of
+	// Username/Password.
+	Auth   AuthProvider
+	Client *http.Client
 }
 
 func NewNexusBackend(baseURL, username, password string) *NexusBackend {
@@ -34,83 +37,49 @@ func NewNexusBackend(baseURL, username, password string) *NexusBackend {
 	}
 }
 
-func (n *NexusBackend) executeWithRetry(ctx context.Context, method, url string, openBody func() (io.ReadCloser, error), contentType string) (*http.Response, error) {
-	expBackoff := backoff.NewExponentialBackOff()
-	expBackoff.MaxElapsedTime = 30 * time.Second
-
-	var attempt int
-	var resp *http.Response
-
-	operation := func() error {
-		attempt++
+func (n *NexusBackend) executeWithRetry(ctx context.Context, method, url string, openBody func() (io.ReadCloser, error), header http.Header) (*http.Response, error) {
+	return doWithRetry(ctx, n.Client, func() (*http.Request, error) {
 		var body io.ReadCloser
-		var err error
 		if openBody != nil {
+			var err error
 			body, err = openBody()
 			if err != nil {
-				return backoff.Permanent(err)
+				return nil, err
 			}
-			defer body.Close()
 		}
 
 		req, err := http.NewRequestWithContext(ctx, method, url, body)
 		if err != nil {
-			return backoff.Permanent(err)
+			if body != nil {
+				body.Close()
+			}
+			return nil, err
 		}
 
-		if n.Username != "" {
-			req.SetBasicAuth(n.Username, n.Password)
-		}
-		if contentType != "" {
-			req.Header.Set("Content-Type", contentType)
+		for k, v := range header {
+			req.Header[k] = v
 		}
-
-		resp, err = n.Client.Do(req)
-		if err != nil {
-			if isRetryableError(err) {
-				logger.Debugf("Retrying %s %s, attempt %d, error: %v", method, url, attempt, err)
-				return err
+		if n.Auth != nil {
+			authorization, err := n.Auth(ctx)
+			if err != nil {
+				if body != nil {
+					body.Close()
+				}
+				return nil, err
 			}
-			return backoff.Permanent(err)
-		}
-
-		if isRetryableStatus(resp.StatusCode) {
-			logger.Debugf("Retrying %s %s, attempt %d, status: %d", method, url, attempt, resp.StatusCode)
-			resp.Body.Close()
-			return fmt.Errorf("server error: %d", resp.StatusCode)
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+		} else if n.Username != "" {
+			req.SetBasicAuth(n.Username, n.Password)
 		}
-
-		return nil
-	}
-
-	err := backoff.Retry(operation, backoff.WithContext(expBackoff, ctx))
-	if err != nil {
-		return nil, err
-	}
-
-	return resp, nil
-}
-
-func isRetryableError(err error) bool {
-	var netErr net.Error
-	if errors.As(err, &netErr) && netErr.Timeout() {
-		return true
-	}
-	if strings.Contains(err.Error(), "connection refused") ||
-		strings.Contains(err.Error(), "connection reset") ||
-		strings.Contains(err.Error(), "EOF") {
-		return true
-	}
-	return false
-}
-
-func isRetryableStatus(code int) bool {
-	return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+		return req, nil
+	})
 }
 
 func (n *NexusBackend) Get(ctx context.Context, path string) (io.ReadCloser, error) {
 	url := n.BaseURL + "/" + strings.TrimPrefix(path, "/")
-	resp, err := n.executeWithRetry(ctx, "GET", url, nil, "")
+	resp, err := n.executeWithRetry(ctx, "GET", url, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -123,9 +92,23 @@ func (n *NexusBackend) Get(ctx context.Context, path string) (io.ReadCloser, err
 	return resp.Body, nil
 }
 
+// GetRange implements RangeGetter using an HTTP Range request.
+func (n *NexusBackend) GetRange(ctx context.Context, path string, offset int64) (io.ReadCloser, bool, error) {
+	url := n.BaseURL + "/" + strings.TrimPrefix(path, "/")
+	resp, err := n.executeWithRetry(ctx, "GET", url, nil, rangeHeader(offset))
+	if err != nil {
+		return nil, false, err
+	}
+	return rangeResponse(resp, url)
+}
+
 func (n *NexusBackend) Put(ctx context.Context, path string, openBody func() (io.ReadCloser, error), contentType string) error {
 	url := n.BaseURL + "/" + strings.TrimPrefix(path, "/")
-	resp, err := n.executeWithRetry(ctx, "PUT", url, openBody, contentType)
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := n.executeWithRetry(ctx, "PUT", url, openBody, header)
 	if err != nil {
 		return err
 	}
@@ -140,7 +123,7 @@ func (n *NexusBackend) Put(ctx context.Context, path string, openBody func() (io
 
 func (n *NexusBackend) Exists(ctx context.Context, path string) (bool, error) {
 	url := n.BaseURL + "/" + strings.TrimPrefix(path, "/")
-	resp, err := n.executeWithRetry(ctx, "HEAD", url, nil, "")
+	resp, err := n.executeWithRetry(ctx, "HEAD", url, nil, nil)
 	if err != nil {
 		return false, err
 	}
@@ -154,3 +137,149 @@ func (n *NexusBackend) Exists(ctx context.Context, path string) (bool, error) {
 	}
 	return false, fmt.Errorf("failed to check existence of %s: %s", url, resp.Status)
 }
+
+// nexusAsset is an item returned by the Nexus REST search API.
+type nexusAsset struct {
+	ID           string    `json:"id"`
+	Path         string    `json:"path"`
+	FileSize     int64     `json:"fileSize"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+type nexusSearchResult struct {
+	Items             []nexusAsset `json:"items"`
+	ContinuationToken string       `json:"continuationToken"`
+}
+
+// restEndpoint splits a RAW repository URL (https://host/repository/<name>)
+// into the Nexus server URL and the repository name used by the REST API.
+func (n *NexusBackend) restEndpoint() (string, string, error) {
+	idx := strings.LastIndex(n.BaseURL, "/repository/")
+	if idx < 0 {
+		return "", "", fmt.Errorf("cannot derive Nexus repository from base URL %s (expected .../repository/<name>)", n.BaseURL)
+	}
+	repoName := strings.Trim(n.BaseURL[idx+len("/repository/"):], "/")
+	if repoName == "" || strings.Contains(repoName, "/") {
+		return "", "", fmt.Errorf("cannot derive Nexus repository from base URL %s (expected .../repository/<name>)", n.BaseURL)
+	}
+	return n.BaseURL[:idx], repoName, nil
+}
+
+// searchAssets returns all assets of the repository matching the Nexus name
+// expression (which may end with a * wildcard), following continuation tokens.
+func (n *NexusBackend) searchAssets(ctx context.Context, name string) ([]nexusAsset, error) {
+	server, repoName, err := n.restEndpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	var assets []nexusAsset
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("repository", repoName)
+		query.Set("name", name)
+		if token != "" {
+			query.Set("continuationToken", token)
+		}
+		searchURL := server + "/service/rest/v1/search/assets?" + query.Encode()
+
+		resp, err := n.executeWithRetry(ctx, "GET", searchURL, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to search assets %s: %s", searchURL, resp.Status)
+		}
+		var result nexusSearchResult
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode search result: %v", err)
+		}
+
+		for _, a := range result.Items {
+			a.Path = strings.TrimPrefix(a.Path, "/")
+			assets = append(assets, a)
+		}
+		if result.ContinuationToken == "" {
+			return assets, nil
+		}
+		token = result.ContinuationToken
+	}
+}
+
+func (n *NexusBackend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	assets, err := n.searchAssets(ctx, prefix+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]ObjectInfo, 0, len(assets))
+	for _, a := range assets {
+		if !strings.HasPrefix(a.Path, prefix) {
+			continue
+		}
+		objects = append(objects, ObjectInfo{Path: a.Path, Size: a.FileSize, LastModified: a.LastModified})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Path < objects[j].Path })
+	return objects, nil
+}
+
+// Delete removes the asset by its repository path. Repositories that do not
+// accept DELETE there are handled through the REST API, looking the asset up
+// with a search; the search index lags behind writes, so an asset that exists
+// but is not found yet is reported as an error rather than silently kept.
+func (n *NexusBackend) Delete(ctx context.Context, path string) error {
+	path = strings.TrimPrefix(path, "/")
+	resp, err := n.executeWithRetry(ctx, "DELETE", n.BaseURL+"/"+path, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	case http.StatusMethodNotAllowed:
+	default:
+		return fmt.Errorf("failed to delete %s: %s", path, resp.Status)
+	}
+
+	assets, err := n.searchAssets(ctx, path)
+	if err != nil {
+		return err
+	}
+	server, _, err := n.restEndpoint()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, a := range assets {
+		if a.Path != path {
+			continue
+		}
+		found = true
+		deleteURL := server + "/service/rest/v1/assets/" + url.PathEscape(a.ID)
+		resp, err := n.executeWithRetry(ctx, "DELETE", deleteURL, nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("failed to delete %s: %s", path, resp.Status)
+		}
+	}
+	if !found {
+		exists, err := n.Exists(ctx, path)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("failed to delete %s: not found by the Nexus search yet", path)
+		}
+	}
+	return nil
+}
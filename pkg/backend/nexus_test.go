@@ -2,11 +2,17 @@ package backend
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestNexusBackend_PutRetry(t *testing.T) {
@@ -130,3 +136,231 @@ func TestNexusBackend(t *testing.T) {
 		t.Error("Expected exists.txt to exist")
 	}
 }
+
+// fakeNexus emulates a Nexus RAW repository named "raw" together with the
+// REST search and asset delete endpoints. Paths in unindexed are not found by
+// the search yet; with noPathDelete the repository rejects DELETE requests.
+type fakeNexus struct {
+	mu           sync.Mutex
+	assets       map[string]string
+	ids          map[string]string
+	unindexed    map[string]bool
+	noPathDelete bool
+	pageSize     int
+}
+
+func newFakeNexus() *fakeNexus {
+	return &fakeNexus{assets: map[string]string{}, ids: map[string]string{}, unindexed: map[string]bool{}, pageSize: 2}
+}
+
+func (f *fakeNexus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/repository/raw/") {
+		p := strings.TrimPrefix(r.URL.Path, "/repository/raw/")
+		switch r.Method {
+		case "PUT":
+			body, _ := io.ReadAll(r.Body)
+			f.assets[p] = string(body)
+			f.ids[p] = fmt.Sprintf("id-%d", len(f.ids)+1)
+			w.WriteHeader(http.StatusCreated)
+		case "GET", "HEAD":
+			v, ok := f.assets[p]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(v))
+		case "DELETE":
+			if f.noPathDelete {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			if _, ok := f.assets[p]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			delete(f.assets, p)
+			delete(f.ids, p)
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	if r.Method == "GET" && r.URL.Path == "/service/rest/v1/search/assets" {
+		if r.URL.Query().Get("repository") != "raw" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		name := r.URL.Query().Get("name")
+		var paths []string
+		for p := range f.assets {
+			if f.unindexed[p] {
+				continue
+			}
+			if strings.HasSuffix(name, "*") && strings.HasPrefix(p, strings.TrimSuffix(name, "*")) || p == name {
+				paths = append(paths, p)
+			}
+		}
+		sort.Strings(paths)
+		start, _ := strconv.Atoi(r.URL.Query().Get("continuationToken"))
+		end := start + f.pageSize
+		token := ""
+		if end < len(paths) {
+			token = strconv.Itoa(end)
+		} else {
+			end = len(paths)
+		}
+		var items []map[string]interface{}
+		for _, p := range paths[start:end] {
+			items = append(items, map[string]interface{}{
+				"id":           f.ids[p],
+				"path":         "/" + p,
+				"fileSize":     len(f.assets[p]),
+				"lastModified": "2026-01-02T03:04:05.000+00:00",
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items, "continuationToken": token})
+		return
+	}
+
+	if r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/service/rest/v1/assets/") {
+		id := strings.TrimPrefix(r.URL.Path, "/service/rest/v1/assets/")
+		for p, pid := range f.ids {
+			if pid == id {
+				delete(f.assets, p)
+				delete(f.ids, p)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func TestNexusBackend_ListDelete(t *testing.T) {
+	fake := newFakeNexus()
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	ctx := context.Background()
+	b := NewNexusBackend(ts.URL+"/repository/raw", "user", "pass")
+
+	for _, p := range []string{
+		"apps/app1/channels/stable.json",
+		"apps/app1/releases/v1.0.0/artifacts.json",
+		"apps/app1/releases/v1.1.0/artifacts.json",
+		"apps/app2/channels/stable.json",
+	} {
+		openBody := func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("data")), nil
+		}
+		if err := b.Put(ctx, p, openBody, "application/json"); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	objects, err := b.List(ctx, "apps/app1/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(objects) != 3 {
+		t.Fatalf("Expected 3 objects across pages, got %d: %+v", len(objects), objects)
+	}
+	if objects[0].Path != "apps/app1/channels/stable.json" || objects[0].Size != 4 || objects[0].LastModified.IsZero() {
+		t.Errorf("Unexpected first object: %+v", objects[0])
+	}
+
+	if err := b.Delete(ctx, "apps/app1/releases/v1.0.0/artifacts.json"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := b.Delete(ctx, "apps/app1/releases/v9.9.9/artifacts.json"); err != nil {
+		t.Errorf("Delete of missing asset should succeed, got %v", err)
+	}
+	exists, _ := b.Exists(ctx, "apps/app1/releases/v1.0.0/artifacts.json")
+	if exists {
+		t.Error("Expected deleted asset to be gone")
+	}
+	if _, ok := fake.assets["apps/app1/releases/v1.1.0/artifacts.json"]; !ok {
+		t.Error("Delete removed an unrelated asset")
+	}
+}
+
+func TestNexusBackend_DeleteThroughSearch(t *testing.T) {
+	fake := newFakeNexus()
+	fake.noPathDelete = true
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	ctx := context.Background()
+	b := NewNexusBackend(ts.URL+"/repository/raw", "user", "pass")
+	for _, p := range []string{"indexed.json", "fresh.json"} {
+		openBody := func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("data")), nil
+		}
+		if err := b.Put(ctx, p, openBody, "application/json"); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	fake.unindexed["fresh.json"] = true
+
+	if err := b.Delete(ctx, "indexed.json"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, ok := fake.assets["indexed.json"]; ok {
+		t.Error("Expected indexed.json to be deleted")
+	}
+	if err := b.Delete(ctx, "fresh.json"); err == nil {
+		t.Error("Expected error deleting an asset the search does not find yet")
+	}
+	if err := b.Delete(ctx, "missing.json"); err != nil {
+		t.Errorf("Delete of missing asset should succeed, got %v", err)
+	}
+}
+
+func TestNexusBackend_ListRequiresRepositoryURL(t *testing.T) {
+	b := NewNexusBackend("https://nexus.example.com/updates", "", "")
+	if _, err := b.List(context.Background(), "apps/"); err == nil {
+		t.Error("Expected error for base URL without /repository/<name>")
+	}
+}
+
+func TestNexusBackend_GetRange(t *testing.T) {
+	ignoreRange := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ignoreRange {
+			w.Write([]byte("0123456789"))
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	b := NewNexusBackend(ts.URL, "", "")
+
+	r, partial, err := b.GetRange(ctx, "file.bin", 4)
+	if err != nil {
+		t.Fatalf("GetRange failed: %v", err)
+	}
+	content, _ := io.ReadAll(r)
+	r.Close()
+	if !partial || string(content) != "456789" {
+		t.Errorf("Expected partial '456789', got %v %q", partial, content)
+	}
+
+	ignoreRange = true
+	r, partial, err = b.GetRange(ctx, "file.bin", 4)
+	if err != nil {
+		t.Fatalf("GetRange failed: %v", err)
+	}
+	content, _ = io.ReadAll(r)
+	r.Close()
+	if partial || string(content) != "0123456789" {
+		t.Errorf("Expected full content when range is ignored, got %v %q", partial, content)
+	}
+}
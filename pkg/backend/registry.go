@@ -0,0 +1,135 @@
+package backend
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/alapierre/itrust-updater/pkg/config"
+)
+
+// Factory creates a backend from the merged ITRUST_* configuration. Credentials
+// are expected to be already resolved into the configuration.
+type Factory func(cfg config.Config) (Backend, error)
+
+var (
+	registryMu sync.RWMutex
+	factories  = make(map[string]Factory)
+	schemes    = make(map[string]string)
+)
+
+func init() {
+	Register("nexus", openNexus)
+	Register("s3", openS3)
+	Register("file", openFile, "file")
+}
+
+// Register makes a backend available to Open under the given type name
+// (ITRUST_BACKEND). Base URLs using one of the given schemes select the backend
+// regardless of ITRUST_BACKEND. Registering an existing name replaces it.
+func Register(name string, factory Factory, urlSchemes ...string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	factories[name] = factory
+	for _, s := range urlSchemes {
+		schemes[s] = name
+	}
+}
+
+// Registered returns the sorted names of all registered backends.
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TypeOf returns the backend type selected by cfg: the backend registered for
+// the ITRUST_BASE_URL scheme, otherwise ITRUST_BACKEND (default "nexus").
+func TypeOf(cfg config.Config) string {
+	if u, err := url.Parse(cfg.Get("ITRUST_BASE_URL", "")); err == nil && u.Scheme != "" {
+		registryMu.RLock()
+		name, ok := schemes[u.Scheme]
+		registryMu.RUnlock()
+		if ok {
+			return name
+		}
+	}
+	return cfg.Get("ITRUST_BACKEND", "nexus")
+}
+
+// Open creates the backend selected by cfg.
+func Open(cfg config.Config) (Backend, error) {
+	name := TypeOf(cfg)
+	registryMu.RLock()
+	factory, ok := factories[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported backend: %s", name)
+	}
+	logger.Debugf("Opening %s backend at %s", name, cfg.Get("ITRUST_BASE_URL", ""))
+	return factory(cfg)
+}
+
+func openNexus(cfg config.Config) (Backend, error) {
+	baseURL := cfg.Get("ITRUST_BASE_URL", "")
+	if baseURL == "" {
+		return nil, fmt.Errorf("ITRUST_BASE_URL is required for the nexus backend")
+	}
+	client, err := NewHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := NexusAuthFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	n := NewNexusBackend(baseURL, cfg.Get("ITRUST_NEXUS_USERNAME", ""), cfg.Get("ITRUST_NEXUS_PASSWORD", ""))
+	n.Auth = auth
+	n.Client = client
+	return n, nil
+}
+
+func openS3(cfg config.Config) (Backend, error) {
+	// A custom endpoint is usually MinIO or Ceph, often reached by IP address
+	// or localhost where virtual-host style cannot work.
+	endpoint := cfg.Get("ITRUST_BASE_URL", "")
+	pathStyle := "false"
+	if endpoint != "" {
+		pathStyle = "true"
+	}
+	s, err := NewS3Backend(S3Options{
+		Endpoint:          endpoint,
+		Bucket:            cfg.Get("ITRUST_S3_BUCKET", ""),
+		Region:            cfg.Get("ITRUST_S3_REGION", os.Getenv("AWS_REGION")),
+		Prefix:            cfg.Get("ITRUST_S3_PREFIX", ""),
+		AccessKey:         cfg.Get("ITRUST_S3_ACCESS_KEY_ID", ""),
+		SecretKey:         cfg.Get("ITRUST_S3_SECRET_ACCESS_KEY", ""),
+		SessionToken:      cfg.Get("ITRUST_S3_SESSION_TOKEN", ""),
+		PathStyle:         cfg.Get("ITRUST_S3_PATH_STYLE", pathStyle) == "true",
+		ConditionalWrites: cfg.Get("ITRUST_S3_CONDITIONAL_WRITES", "auto"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.Client = client
+	return s, nil
+}
+
+func openFile(cfg config.Config) (Backend, error) {
+	root, err := FileRootFromURL(cfg.Get("ITRUST_BASE_URL", ""))
+	if err != nil {
+		return nil, err
+	}
+	return NewFileBackend(root), nil
+}
@@ -0,0 +1,95 @@
+package backend
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/alapierre/itrust-updater/pkg/config"
+)
+
+// dummyBackend stands in for a third-party backend; only Open is exercised.
+type dummyBackend struct {
+	Backend
+	baseURL string
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.Config
+		expected string
+	}{
+		{"default nexus", config.Config{"ITRUST_BASE_URL": "https://nexus.example.com/repository/raw"}, "*backend.NexusBackend"},
+		{"s3", config.Config{"ITRUST_BACKEND": "s3", "ITRUST_BASE_URL": "http://minio:9000", "ITRUST_S3_BUCKET": "updates"}, "*backend.S3Backend"},
+		{"file scheme wins", config.Config{"ITRUST_BACKEND": "nexus", "ITRUST_BASE_URL": "file:///mnt/updates"}, "*backend.FileBackend"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := Open(tt.cfg)
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			if got := fmt.Sprintf("%T", b); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	if _, err := Open(config.Config{"ITRUST_BACKEND": "ftp", "ITRUST_BASE_URL": "ftp://host"}); err == nil {
+		t.Error("Expected error for unsupported backend")
+	}
+	if _, err := Open(config.Config{"ITRUST_BACKEND": "s3", "ITRUST_BASE_URL": "http://minio:9000"}); err == nil {
+		t.Error("Expected error for S3 backend without bucket")
+	}
+}
+
+func TestOpenS3PathStyle(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.Config
+		expected bool
+	}{
+		{"aws default", config.Config{}, false},
+		{"custom endpoint", config.Config{"ITRUST_BASE_URL": "http://127.0.0.1:9000"}, true},
+		{"override", config.Config{"ITRUST_BASE_URL": "https://s3.eu-central-1.amazonaws.com", "ITRUST_S3_PATH_STYLE": "false"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg["ITRUST_S3_BUCKET"] = "updates"
+			b, err := openS3(tt.cfg)
+			if err != nil {
+				t.Fatalf("openS3 failed: %v", err)
+			}
+			if got := b.(*S3Backend).PathStyle; got != tt.expected {
+				t.Errorf("Expected PathStyle %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("dummy", func(cfg config.Config) (Backend, error) {
+		return &dummyBackend{baseURL: cfg.Get("ITRUST_BASE_URL", "")}, nil
+	}, "dummy")
+
+	b, err := Open(config.Config{"ITRUST_BASE_URL": "dummy://repo"})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	d, ok := b.(*dummyBackend)
+	if !ok || d.baseURL != "dummy://repo" {
+		t.Errorf("Expected registered dummy backend, got %#v", b)
+	}
+
+	found := false
+	for _, name := range Registered() {
+		if name == "dummy" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Registered() does not list dummy backend")
+	}
+}
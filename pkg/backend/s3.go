@@ -0,0 +1,420 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3Service         = "s3"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3EmptyPayload    = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Options configures an S3-compatible backend (AWS S3, MinIO, Ceph RGW, ...).
+type S3Options struct {
+	Endpoint     string
+	Bucket       string
+	Region       string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	PathStyle    bool
+	// ConditionalWrites is "auto" (probe the server, the default), "true" or
+	// "false", see ConditionalWritesHonoured.
+	ConditionalWrites string
+}
+
+type S3Backend struct {
+	Endpoint     *url.URL
+	Bucket       string
+	Region       string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	PathStyle    bool
+	Client       *http.Client
+	// ConditionalWrites is "auto", "true" or "false", see S3Options.
+	ConditionalWrites string
+
+	now      func() time.Time
+	probeMu  sync.Mutex
+	honoured *bool
+}
+
+func NewS3Backend(opts S3Options) (*S3Backend, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("S3 bucket is required")
+	}
+	region := opts.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %s: %v", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid S3 endpoint %s: scheme must be http or https", endpoint)
+	}
+	switch opts.ConditionalWrites {
+	case "", "auto", "true", "false":
+	default:
+		return nil, fmt.Errorf("invalid S3 conditional writes setting %q (expected auto, true or false)", opts.ConditionalWrites)
+	}
+
+	return &S3Backend{
+		Endpoint:          u,
+		Bucket:            opts.Bucket,
+		Region:            region,
+		Prefix:            strings.Trim(opts.Prefix, "/"),
+		AccessKey:         opts.AccessKey,
+		SecretKey:         opts.SecretKey,
+		SessionToken:      opts.SessionToken,
+		PathStyle:         opts.PathStyle,
+		ConditionalWrites: opts.ConditionalWrites,
+		Client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		now: time.Now,
+	}, nil
+}
+
+func (s *S3Backend) objectKey(path string) string {
+	key := strings.TrimPrefix(path, "/")
+	if s.Prefix != "" {
+		key = s.Prefix + "/" + key
+	}
+	return key
+}
+
+// objectURL returns the URL of the object addressed either path-style
+// (https://host/bucket/key) or virtual-host style (https://bucket.host/key).
+func (s *S3Backend) objectURL(path string) string {
+	basePath := strings.TrimSuffix(s.Endpoint.EscapedPath(), "/")
+	key := s3EscapePath(s.objectKey(path))
+	if s.PathStyle {
+		return fmt.Sprintf("%s://%s%s/%s/%s", s.Endpoint.Scheme, s.Endpoint.Host, basePath, s3EscapePath(s.Bucket), key)
+	}
+	return fmt.Sprintf("%s://%s.%s%s/%s", s.Endpoint.Scheme, s.Bucket, s.Endpoint.Host, basePath, key)
+}
+
+// bucketURL returns the URL of the bucket itself, used for listing.
+func (s *S3Backend) bucketURL() string {
+	basePath := strings.TrimSuffix(s.Endpoint.EscapedPath(), "/")
+	if s.PathStyle {
+		return fmt.Sprintf("%s://%s%s/%s/", s.Endpoint.Scheme, s.Endpoint.Host, basePath, s3EscapePath(s.Bucket))
+	}
+	return fmt.Sprintf("%s://%s.%s%s/", s.Endpoint.Scheme, s.Bucket, s.Endpoint.Host, basePath)
+}
+
+func (s *S3Backend) execute(ctx context.Context, method, objectURL string, openBody func() (io.ReadCloser, error), header http.Header) (*http.Response, error) {
+	return doWithRetry(ctx, s.Client, func() (*http.Request, error) {
+		var body io.ReadCloser
+		var size int64
+		payloadHash := s3EmptyPayload
+		if openBody != nil {
+			rc, err := openBody()
+			if err != nil {
+				return nil, err
+			}
+			// S3 rejects chunked uploads without a Content-Length.
+			body, size, err = sizedBody(rc)
+			if err != nil {
+				return nil, err
+			}
+			if size == 0 {
+				body.Close()
+				body = http.NoBody
+			}
+			payloadHash = s3UnsignedPayload
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, objectURL, body)
+		if err != nil {
+			if body != nil {
+				body.Close()
+			}
+			return nil, err
+		}
+		if body != nil {
+			req.ContentLength = size
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		s.sign(req, payloadHash, s.now().UTC())
+		return req, nil
+	})
+}
+
+func (s *S3Backend) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	resp, err := s.execute(ctx, "GET", s.objectURL(path), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to get %s: %s", s.objectURL(path), resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// GetRange implements RangeGetter using an HTTP Range request.
+func (s *S3Backend) GetRange(ctx context.Context, path string, offset int64) (io.ReadCloser, bool, error) {
+	resp, err := s.execute(ctx, "GET", s.objectURL(path), nil, rangeHeader(offset))
+	if err != nil {
+		return nil, false, err
+	}
+	return rangeResponse(resp, s.objectURL(path))
+}
+
+func (s *S3Backend) Put(ctx context.Context, path string, openBody func() (io.ReadCloser, error), contentType string) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.execute(ctx, "PUT", s.objectURL(path), openBody, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to put %s: %s", s.objectURL(path), resp.Status)
+	}
+
+	return nil
+}
+
+func (s *S3Backend) Exists(ctx context.Context, path string) (bool, error) {
+	resp, err := s.execute(ctx, "HEAD", s.objectURL(path), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return true, nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check existence of %s: %s", s.objectURL(path), resp.Status)
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Backend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	keyPrefix := s.objectKey(prefix)
+	var objects []ObjectInfo
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", keyPrefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		listURL := s.bucketURL() + "?" + s3CanonicalQuery(query)
+
+		resp, err := s.execute(ctx, "GET", listURL, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to list %s: %s", listURL, resp.Status)
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode list result: %v", err)
+		}
+
+		for _, c := range result.Contents {
+			path := c.Key
+			if s.Prefix != "" {
+				path = strings.TrimPrefix(path, s.Prefix+"/")
+			}
+			objects = append(objects, ObjectInfo{Path: path, Size: c.Size, LastModified: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Path < objects[j].Path })
+	return objects, nil
+}
+
+func (s *S3Backend) Delete(ctx context.Context, path string) error {
+	resp, err := s.execute(ctx, "DELETE", s.objectURL(path), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to delete %s: %s", s.objectURL(path), resp.Status)
+	}
+	return nil
+}
+
+// sign adds AWS Signature Version 4 headers to req. Requests are sent
+// anonymously when no access key is configured.
+func (s *S3Backend) sign(req *http.Request, payloadHash string, t time.Time) {
+	if s.AccessKey == "" {
+		return
+	}
+
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.Join(strings.Fields(strings.Join(v, ",")), " ")
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, s.Region, s3Service)
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.AccessKey, scope, signedHeaders, signature))
+}
+
+func s3CanonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), values[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape percent-encodes everything except the RFC 3986 unreserved characters.
+func s3Escape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		segments[i] = s3Escape(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// sizedBody returns the body together with its length, buffering it in memory
+// when the length cannot be determined from the underlying file.
+func sizedBody(rc io.ReadCloser) (io.ReadCloser, int64, error) {
+	if f, ok := rc.(*os.File); ok {
+		fi, err := f.Stat()
+		if err == nil && fi.Mode().IsRegular() {
+			return f, fi.Size(), nil
+		}
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, 0, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/alapierre/itrust-updater/pkg/logging"
@@ -45,6 +46,75 @@ func LoadFile(path string) (Config, error) {
 	return Parse(f)
 }
 
+// ReplaceValue rewrites the file at path, setting key to newValue on every line
+// where it currently has oldValue, quoted or not; a quoted value stays quoted.
+// Comments, ordering and other settings are kept and the file is replaced
+// atomically. It reports whether anything changed;
+// a missing file is not an error.
+func ReplaceValue(path, key, oldValue, newValue string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	changed := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		parts := strings.SplitN(trimmed, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != key {
+			continue
+		}
+		value, quote := unquote(strings.TrimSpace(parts[1]))
+		if value == oldValue {
+			lines[i] = key + "=" + quote + newValue + quote
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	dir, name := filepath.Split(path)
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strings.Join(lines, "\n")); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	logger.Debugf("Updating %s in %s", key, path)
+	return true, os.Rename(tmp.Name(), path)
+}
+
+// unquote strips matching double or single quotes around value and returns
+// them separately, or "" if value is not quoted.
+func unquote(value string) (string, string) {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1], value[:1]
+	}
+	return value, ""
+}
+
 func (c Config) Merge(other Config) {
 	for k, v := range other {
 		c[k] = v
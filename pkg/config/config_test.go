@@ -1,6 +1,8 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -47,3 +49,48 @@ func TestMergeConfigs(t *testing.T) {
 		t.Errorf("Expected %v, got %v", expected, res)
 	}
 }
+
+func TestReplaceValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.env")
+	input := "# pinned key\nITRUST_APP_ID=app1\nITRUST_REPO_PUBKEY_SHA256 = aaa\nITRUST_DEST=/opt/app\n"
+	if err := os.WriteFile(path, []byte(input), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := ReplaceValue(path, "ITRUST_REPO_PUBKEY_SHA256", "bbb", "ccc")
+	if err != nil || changed {
+		t.Errorf("Expected no change for other old value, got %v, %v", changed, err)
+	}
+
+	changed, err = ReplaceValue(path, "ITRUST_REPO_PUBKEY_SHA256", "aaa", "bbb")
+	if err != nil || !changed {
+		t.Fatalf("Expected change, got %v, %v", changed, err)
+	}
+	data, _ := os.ReadFile(path)
+	expected := "# pinned key\nITRUST_APP_ID=app1\nITRUST_REPO_PUBKEY_SHA256=bbb\nITRUST_DEST=/opt/app\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q", expected, data)
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", fi.Mode().Perm())
+	}
+
+	if err := os.WriteFile(path, []byte("A=\"aaa\"\nB = 'aaa'\nC=\"aaa'\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"A", "B", "C"} {
+		if _, err := ReplaceValue(path, key, "aaa", "bbb"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, _ = os.ReadFile(path)
+	expected = "A=\"bbb\"\nB='bbb'\nC=\"aaa'\n"
+	if string(data) != expected {
+		t.Errorf("Expected quoted values replaced in their quotes, got %q", data)
+	}
+
+	changed, err = ReplaceValue(filepath.Join(t.TempDir(), "missing.env"), "K", "a", "b")
+	if err != nil || changed {
+		t.Errorf("Expected no change for missing file, got %v, %v", changed, err)
+	}
+}
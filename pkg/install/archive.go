@@ -0,0 +1,523 @@
+package install
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alapierre/itrust-updater/pkg/sign"
+	"github.com/klauspost/compress/zstd"
+)
+
+// IsArchive reports whether artifacts of artifactType are extracted into a
+// directory rather than installed as a single file.
+func IsArchive(artifactType string) bool {
+	switch artifactType {
+	case "zip", "tar.gz", "tar.zst":
+		return true
+	}
+	return false
+}
+
+// ExtractLimits caps what extracting an archive may write. The SHA256 only
+// proves who published an archive, so a compressed bomb in a compromised or
+// broken release would otherwise fill the disk before the install is swapped
+// in. Zero limits are unset.
+type ExtractLimits struct {
+	// MaxBytes caps the total size of the extracted files.
+	MaxBytes int64
+	// MaxEntries caps the number of entries in the archive.
+	MaxEntries int
+}
+
+// DefaultExtractLimits apply unless the profile sets its own.
+var DefaultExtractLimits = ExtractLimits{MaxBytes: 4 << 30, MaxEntries: 100000}
+
+// extractBudget tracks an extraction against its limits.
+type extractBudget struct {
+	limits  ExtractLimits
+	bytes   int64
+	entries int
+}
+
+// entry counts the archive entry name.
+func (b *extractBudget) entry(name string) error {
+	b.entries++
+	if b.limits.MaxEntries > 0 && b.entries > b.limits.MaxEntries {
+		return fmt.Errorf("%s: archive has more than %d entries", name, b.limits.MaxEntries)
+	}
+	return nil
+}
+
+// copy writes the content of the entry name from r to w.
+func (b *extractBudget) copy(name string, w io.Writer, r io.Reader) error {
+	if b.limits.MaxBytes > 0 {
+		// One byte over the budget is enough to tell it was exceeded.
+		r = io.LimitReader(r, b.limits.MaxBytes-b.bytes+1)
+	}
+	n, err := io.Copy(w, r)
+	b.bytes += n
+	if b.limits.MaxBytes > 0 && b.bytes > b.limits.MaxBytes {
+		return fmt.Errorf("%s: archive expands to more than %d bytes", name, b.limits.MaxBytes)
+	}
+	return err
+}
+
+// InstallArchive verifies the archive against expectedSha256, extracts it
+// into a staging directory next to dest, within limits, and swaps that with
+// dest. With backup set, the replaced directory is kept as a backup of
+// profile; otherwise it is removed. It returns the SHA256 of the archive.
+func InstallArchive(archive, dest, expectedSha256, stateDir, profile, artifactType string, backup bool, limits ExtractLimits) (string, error) {
+	actualSha256, err := sign.FileSHA256(archive)
+	if err != nil {
+		return "", err
+	}
+	if actualSha256 != expectedSha256 {
+		return "", fmt.Errorf("SHA256 mismatch: expected %s, got %s", expectedSha256, actualSha256)
+	}
+
+	parent := filepath.Dir(dest)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", fmt.Errorf("failed to create destination directory: %v", err)
+	}
+	staging, err := os.MkdirTemp(parent, ".itrust-staging-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(staging)
+
+	if err := ExtractArchive(archive, artifactType, staging, limits); err != nil {
+		return "", fmt.Errorf("failed to extract archive: %v", err)
+	}
+	if err := os.Chmod(staging, 0755); err != nil {
+		return "", err
+	}
+
+	if fi, err := os.Lstat(dest); err == nil && !fi.IsDir() {
+		return "", fmt.Errorf("%s exists and is not a directory", dest)
+	}
+	replaced, err := swapDir(staging, dest)
+	if err != nil {
+		return "", err
+	}
+	if replaced {
+		// staging now holds the previous installation.
+		if backup {
+			if _, err := createDirBackup(stateDir, profile, dest, staging); err != nil {
+				logger.Warnf("Failed to backup the previous installation: %v", err)
+			}
+		}
+	}
+	return actualSha256, nil
+}
+
+// swapDir moves the directory staging to dest. If dest exists the two are
+// exchanged, so that staging then holds the previous dest; replaced reports
+// whether that happened.
+func swapDir(staging, dest string) (replaced bool, err error) {
+	if _, err := os.Lstat(dest); os.IsNotExist(err) {
+		return false, os.Rename(staging, dest)
+	}
+	if err := exchange(staging, dest); err != nil {
+		return false, fmt.Errorf("failed to replace %s: %v", dest, err)
+	}
+	return true, nil
+}
+
+func exchangeByRename(a, b string) error {
+	old := b + ".itrust-old"
+	os.RemoveAll(old)
+	if err := os.Rename(b, old); err != nil {
+		return err
+	}
+	if err := os.Rename(a, b); err != nil {
+		os.Rename(old, b)
+		return err
+	}
+	return os.Rename(old, a)
+}
+
+// ExtractArchive extracts the archive of artifactType into dir. Entries that
+// would end up outside dir, directly or through a symlink, are refused, as
+// are special files and archives exceeding limits. File modes are preserved.
+func ExtractArchive(archive, artifactType, dir string, limits ExtractLimits) error {
+	if err := extractArchive(archive, artifactType, dir, &extractBudget{limits: limits}); err != nil {
+		return err
+	}
+	// Links are checked once all are in place: a link may only escape
+	// through another one, which may come later in the archive.
+	return checkSymlinks(dir)
+}
+
+func extractArchive(archive, artifactType, dir string, budget *extractBudget) error {
+	switch artifactType {
+	case "zip":
+		return extractZip(archive, dir, budget)
+	case "tar.gz", "tar.zst":
+		f, err := os.Open(archive)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		var r io.Reader
+		if artifactType == "tar.gz" {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				return err
+			}
+			defer gz.Close()
+			r = gz
+		} else {
+			zr, err := zstd.NewReader(f)
+			if err != nil {
+				return err
+			}
+			defer zr.Close()
+			r = zr
+		}
+		return extractTar(r, dir, budget)
+	}
+	return fmt.Errorf("unsupported archive type %q", artifactType)
+}
+
+func extractZip(archive, dir string, budget *extractBudget) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if err := budget.entry(f.Name); err != nil {
+			return err
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := makeDir(dir, f.Name, mode); err != nil {
+				return err
+			}
+		case mode&fs.ModeSymlink != 0:
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			target, err := io.ReadAll(io.LimitReader(rc, 4096))
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			if err := makeSymlink(dir, f.Name, string(target)); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = writeFile(dir, f.Name, mode, rc, budget)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: unsupported file type %v", f.Name, mode.Type())
+		}
+	}
+	return nil
+}
+
+func extractTar(r io.Reader, dir string, budget *extractBudget) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := budget.entry(h.Name); err != nil {
+			return err
+		}
+		mode := h.FileInfo().Mode()
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = makeDir(dir, h.Name, mode)
+		case tar.TypeSymlink:
+			err = makeSymlink(dir, h.Name, h.Linkname)
+		case tar.TypeReg:
+			err = writeFile(dir, h.Name, mode, tr, budget)
+		case tar.TypeXGlobalHeader:
+		default:
+			err = fmt.Errorf("%s: unsupported entry type %q", h.Name, h.Typeflag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// entryPath returns where the archive entry name goes in dir. It refuses
+// names leaving dir and names at or below a symlink extracted earlier, which
+// could point anywhere.
+func entryPath(dir, name string) (string, error) {
+	name = filepath.FromSlash(strings.ReplaceAll(name, `\`, "/"))
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("%s: absolute path in archive", name)
+	}
+	rel := filepath.Clean(name)
+	if rel == "." {
+		return "", fmt.Errorf("%q: empty path in archive", name)
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: path escapes the install directory", name)
+	}
+
+	path := dir
+	parts := strings.Split(rel, string(filepath.Separator))
+	for _, p := range parts[:len(parts)-1] {
+		path = filepath.Join(path, p)
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("%s: path goes through a symlink", name)
+		}
+	}
+	path = filepath.Join(dir, rel)
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+		return "", fmt.Errorf("%s: path is a symlink", name)
+	}
+	return path, nil
+}
+
+func makeDir(dir, name string, mode fs.FileMode) error {
+	if filepath.Clean(filepath.FromSlash(name)) == "." {
+		// The archive root, as in "./" written by tar -C dir .
+		return nil
+	}
+	path, err := entryPath(dir, strings.TrimSuffix(name, "/"))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	// Keep directories writable by the owner so they can be replaced later.
+	return os.Chmod(path, mode.Perm()|0700)
+}
+
+func writeFile(dir, name string, mode fs.FileMode, r io.Reader, budget *extractBudget) error {
+	path, err := entryPath(dir, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := budget.copy(name, f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(path, mode.Perm())
+}
+
+// makeSymlink creates a symlink whose target must be relative and stay
+// within dir, also when resolved through the links extracted so far.
+func makeSymlink(dir, name, target string) error {
+	path, err := entryPath(dir, name)
+	if err != nil {
+		return err
+	}
+	if filepath.IsAbs(target) || filepath.VolumeName(target) != "" || strings.HasPrefix(target, "/") {
+		return fmt.Errorf("%s: symlink to absolute path %s", name, target)
+	}
+	resolved, err := filepath.Rel(dir, filepath.Join(filepath.Dir(path), filepath.FromSlash(target)))
+	if err != nil || resolved == ".." || strings.HasPrefix(resolved, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s: symlink to %s escapes the install directory", name, target)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Symlink(target, path); err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return err
+	}
+	if err := resolveInDir(dir, rel); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// checkSymlinks refuses symlinks in dir that resolve to a path outside it,
+// following the other symlinks in dir on the way.
+func checkSymlinks(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.Type()&fs.ModeSymlink == 0 {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if err := resolveInDir(dir, rel); err != nil {
+			return fmt.Errorf("%s: %v", filepath.ToSlash(rel), err)
+		}
+		return nil
+	})
+}
+
+// resolveInDir resolves the path rel within dir like the kernel would,
+// following symlinks, and fails if it leaves dir at any point. Missing
+// components are taken as they are.
+func resolveInDir(dir, rel string) error {
+	var resolved []string
+	pending := strings.Split(filepath.ToSlash(rel), "/")
+	for hops := 0; len(pending) > 0; {
+		c := pending[0]
+		pending = pending[1:]
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return fmt.Errorf("symlink escapes the install directory")
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		path := filepath.Join(append([]string{dir}, append(resolved, c)...)...)
+		fi, err := os.Lstat(path)
+		if err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+			resolved = append(resolved, c)
+			continue
+		}
+		if hops++; hops > 40 {
+			return fmt.Errorf("too many levels of symlinks")
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if filepath.IsAbs(target) || strings.HasPrefix(target, "/") {
+			return fmt.Errorf("symlink to absolute path %s", target)
+		}
+		// The target is relative to the directory holding the link.
+		pending = append(strings.Split(filepath.ToSlash(target), "/"), pending...)
+	}
+	return nil
+}
+
+// DirSHA256 returns a digest of the directory tree: the paths, types, modes
+// and contents of its entries. It identifies an extracted installation the
+// way a file SHA256 identifies a single file.
+func DirSHA256(dir string) (string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, path := range paths {
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return "", err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		fmt.Fprintf(h, "%s\x00%v\x00", filepath.ToSlash(rel), fi.Mode())
+		switch {
+		case fi.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "%s\x00", target)
+		case fi.Mode().IsRegular():
+			sum, err := sign.FileSHA256(path)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "%s\x00", sum)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyTree copies the directory src to dst, keeping modes and symlinks.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		switch {
+		case fi.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			return os.Chmod(target, fi.Mode().Perm()|0700)
+		case fi.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			if err := CopyFile(path, target); err != nil {
+				return err
+			}
+			return os.Chmod(target, fi.Mode().Perm())
+		}
+	})
+}
+
+// treeSize returns the total size of the regular files under dir.
+func treeSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if fi, err := d.Info(); err == nil {
+				size += fi.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,287 @@
+package install
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/alapierre/itrust-updater/pkg/sign"
+	"github.com/klauspost/compress/zstd"
+)
+
+type entry struct {
+	name string
+	mode fs.FileMode
+	body string // content, or the target of a symlink
+}
+
+var appEntries = []entry{
+	{"./", fs.ModeDir | 0755, ""},
+	{"bin/", fs.ModeDir | 0755, ""},
+	{"bin/launcher", 0755, "#!/bin/sh\n"},
+	{"lib/app.jar", 0644, "jar"},
+	{"config/app.conf.template", 0600, "key=value"},
+	{"launcher", fs.ModeSymlink | 0777, "bin/launcher"},
+}
+
+// writeArchive writes entries as an archive of artifactType and returns its
+// path and SHA256.
+func writeArchive(t *testing.T, artifactType string, entries []entry) (string, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "app."+artifactType)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if artifactType == "zip" {
+		zw := zip.NewWriter(f)
+		for _, e := range entries {
+			h := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+			h.SetMode(e.mode)
+			w, err := zw.CreateHeader(h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(w, e.body)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		var w io.WriteCloser
+		if artifactType == "tar.gz" {
+			w = gzip.NewWriter(f)
+		} else if w, err = zstd.NewWriter(f); err != nil {
+			t.Fatal(err)
+		}
+		tw := tar.NewWriter(w)
+		for _, e := range entries {
+			h := &tar.Header{Name: e.name, Mode: int64(e.mode.Perm()), Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+			switch {
+			case e.mode.IsDir():
+				h.Typeflag, h.Size = tar.TypeDir, 0
+			case e.mode&fs.ModeSymlink != 0:
+				h.Typeflag, h.Size, h.Linkname = tar.TypeSymlink, 0, e.body
+			}
+			if err := tw.WriteHeader(h); err != nil {
+				t.Fatal(err)
+			}
+			if h.Typeflag == tar.TypeReg {
+				io.WriteString(tw, e.body)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+	f.Close()
+	sha, err := sign.FileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, sha
+}
+
+func TestInstallArchive(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks and modes")
+	}
+	for _, typ := range []string{"zip", "tar.gz", "tar.zst"} {
+		t.Run(typ, func(t *testing.T) {
+			stateDir := t.TempDir()
+			dest := filepath.Join(t.TempDir(), "app")
+			archive, sha := writeArchive(t, typ, appEntries)
+
+			if _, err := InstallArchive(archive, dest, "0000", stateDir, "app", typ, true, DefaultExtractLimits); err == nil {
+				t.Error("Expected SHA256 mismatch")
+			}
+			if _, err := InstallArchive(archive, dest, sha, stateDir, "app", typ, true, DefaultExtractLimits); err != nil {
+				t.Fatalf("InstallArchive failed: %v", err)
+			}
+			for _, e := range appEntries {
+				fi, err := os.Lstat(filepath.Join(dest, e.name))
+				if err != nil {
+					t.Fatalf("Missing %s: %v", e.name, err)
+				}
+				if e.mode&fs.ModeSymlink == 0 && fi.Mode().Perm() != e.mode.Perm() {
+					t.Errorf("Expected mode %v for %s, got %v", e.mode.Perm(), e.name, fi.Mode().Perm())
+				}
+			}
+			if data, _ := os.ReadFile(filepath.Join(dest, "launcher")); string(data) != "#!/bin/sh\n" {
+				t.Errorf("Expected symlink to the launcher, got %q", data)
+			}
+			first, _ := DirSHA256(dest)
+
+			// A second install swaps the directory and keeps the old one.
+			update, updateSha := writeArchive(t, typ, []entry{{"bin/launcher", 0755, "v2"}})
+			if _, err := InstallArchive(update, dest, updateSha, stateDir, "app", typ, true, DefaultExtractLimits); err != nil {
+				t.Fatalf("InstallArchive failed: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(dest, "lib")); !os.IsNotExist(err) {
+				t.Error("Expected the old installation to be replaced, not merged")
+			}
+			matches, _ := filepath.Glob(filepath.Join(filepath.Dir(dest), ".itrust-*"))
+			if len(matches) != 0 {
+				t.Errorf("Expected no staging directories left, got %v", matches)
+			}
+
+			backups, _ := ListBackups(stateDir, "app")
+			if len(backups) != 1 || backups[0].Sha256 != first {
+				t.Fatalf("Expected a backup of the first installation, got %+v", backups)
+			}
+			if _, err := RestoreBackup(&backups[0], dest, stateDir, "app", true); err != nil {
+				t.Fatalf("RestoreBackup failed: %v", err)
+			}
+			if restored, _ := DirSHA256(dest); restored != first {
+				t.Error("Expected the first installation to be restored")
+			}
+		})
+	}
+}
+
+func TestExtractArchiveRejectsEscapes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks")
+	}
+	tests := map[string][]entry{
+		"parent path":           {{"../evil", 0644, "x"}},
+		"nested parent path":    {{"a/../../evil", 0644, "x"}},
+		"absolute path":         {{"/tmp/evil", 0644, "x"}},
+		"absolute symlink":      {{"link", fs.ModeSymlink | 0777, "/etc/passwd"}},
+		"escaping symlink":      {{"a/link", fs.ModeSymlink | 0777, "../../evil"}},
+		"write through link":    {{"link", fs.ModeSymlink | 0777, "."}, {"link/evil", 0644, "x"}},
+		"replace through dup":   {{"f", 0644, "x"}, {"f", 0644, "y"}},
+		"escape via link":       {{"sub/x", fs.ModeSymlink | 0777, ".."}, {"sub/y", fs.ModeSymlink | 0777, "x/../.."}},
+		"escape via later link": {{"sub/y", fs.ModeSymlink | 0777, "x/../.."}, {"sub/x", fs.ModeSymlink | 0777, ".."}},
+		"link loop":             {{"a", fs.ModeSymlink | 0777, "b"}, {"b", fs.ModeSymlink | 0777, "a"}},
+	}
+	for name, entries := range tests {
+		for _, typ := range []string{"zip", "tar.gz"} {
+			t.Run(name+"/"+typ, func(t *testing.T) {
+				archive, _ := writeArchive(t, typ, entries)
+				parent := t.TempDir()
+				dir := filepath.Join(parent, "dest")
+				os.Mkdir(dir, 0755)
+				if err := ExtractArchive(archive, typ, dir, DefaultExtractLimits); err == nil {
+					t.Error("Expected extraction to be refused")
+				}
+				if _, err := os.Lstat(filepath.Join(parent, "evil")); err == nil {
+					t.Error("Expected nothing written outside the directory")
+				}
+			})
+		}
+	}
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	bomb := []entry{{"zeros", 0644, strings.Repeat("\x00", 1<<20)}}
+	var many []entry
+	for i := 0; i < 10; i++ {
+		many = append(many, entry{fmt.Sprintf("f%d", i), 0644, "x"})
+	}
+	tests := []struct {
+		name    string
+		entries []entry
+		limits  ExtractLimits
+		ok      bool
+	}{
+		{"bytes within limit", bomb, ExtractLimits{MaxBytes: 1 << 20}, true},
+		{"bytes over limit", bomb, ExtractLimits{MaxBytes: 64 << 10}, false},
+		{"bytes over limit across files", many, ExtractLimits{MaxBytes: 9}, false},
+		{"entries within limit", many, ExtractLimits{MaxEntries: 10}, true},
+		{"entries over limit", many, ExtractLimits{MaxEntries: 5}, false},
+		{"no limits", bomb, ExtractLimits{}, true},
+	}
+	for _, tt := range tests {
+		for _, typ := range []string{"zip", "tar.gz", "tar.zst"} {
+			t.Run(tt.name+"/"+typ, func(t *testing.T) {
+				archive, _ := writeArchive(t, typ, tt.entries)
+				err := ExtractArchive(archive, typ, t.TempDir(), tt.limits)
+				if tt.ok && err != nil {
+					t.Errorf("Expected extraction to succeed, got %v", err)
+				}
+				if !tt.ok && err == nil {
+					t.Error("Expected extraction to be refused")
+				}
+			})
+		}
+	}
+
+	// A refused archive leaves the installation alone.
+	parent := t.TempDir()
+	dest := filepath.Join(parent, "app")
+	if err := os.Mkdir(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	archive, sha := writeArchive(t, "tar.zst", bomb)
+	if _, err := InstallArchive(archive, dest, sha, t.TempDir(), "app", "tar.zst", true, ExtractLimits{MaxBytes: 64 << 10}); err == nil {
+		t.Error("Expected installation to be refused")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(parent, ".itrust-*")); len(matches) != 0 {
+		t.Errorf("Expected no staging directories left, got %v", matches)
+	}
+}
+
+func TestInstallArchiveRejectsChmodThroughLink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks")
+	}
+	// y looks like it stays inside, but x/l leads back out of the staging
+	// directory; the later y/ entry must not chmod the victim.
+	archive, sha := writeArchive(t, "tar.gz", []entry{
+		{"x/", fs.ModeDir | 0755, ""},
+		{"x/l", fs.ModeSymlink | 0777, ".."},
+		{"y", fs.ModeSymlink | 0777, "x/l/../victim"},
+		{"y/", fs.ModeDir | 0777, ""},
+	})
+	parent := t.TempDir()
+	victim := filepath.Join(parent, "victim")
+	if err := os.Mkdir(victim, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InstallArchive(archive, filepath.Join(parent, "app"), sha, t.TempDir(), "app", "tar.gz", false, DefaultExtractLimits); err == nil {
+		t.Error("Expected installation to be refused")
+	}
+	if fi, err := os.Stat(victim); err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("Expected the directory outside to be untouched, got %v (err: %v)", fi.Mode(), err)
+	}
+}
+
+func TestVersionedArchiveInstall(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks")
+	}
+	root := t.TempDir()
+	archive, sha := writeArchive(t, "tar.gz", appEntries)
+	dest, _, err := InstallVersion(archive, root, "1.0.0", "app", sha, t.TempDir(), "app", "tar.gz", DefaultExtractLimits)
+	if err != nil {
+		t.Fatalf("InstallVersion failed: %v", err)
+	}
+	if dest != VersionDir(root, "1.0.0") {
+		t.Errorf("Expected archive extracted into the version directory, got %s", dest)
+	}
+	if _, err := os.Stat(filepath.Join(root, "current", "lib", "app.jar")); err != nil {
+		t.Errorf("Expected current to hold the extracted files: %v", err)
+	}
+
+	content, _ := DirSHA256(dest)
+	if err := SaveVersionState(root, &State{InstalledVersion: "1.0.0", InstalledSha256: sha, ContentSha256: content, Dest: dest}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadVersionState(root, "1.0.0"); err != nil {
+		t.Errorf("LoadVersionState failed: %v", err)
+	}
+	os.WriteFile(filepath.Join(dest, "lib", "app.jar"), []byte("tampered"), 0644)
+	if _, err := LoadVersionState(root, "1.0.0"); err == nil {
+		t.Error("Expected modified version to be refused")
+	}
+}
@@ -0,0 +1,313 @@
+package install
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+// backupTimeFormat names backup directories; it sorts chronologically.
+const backupTimeFormat = "20060102-150405"
+
+// backupMetaFile is the sidecar describing the file in a backup directory.
+const backupMetaFile = "backup.json"
+
+// Backup is a copy of an installed file taken before it was replaced, stored
+// in backups/<profile>/<ID>/ under the state directory.
+type Backup struct {
+	// ID is the name of the backup directory, the time it was taken.
+	ID string `json:"-"`
+	// Path is the location of the backed up file and Size its size.
+	Path string `json:"-"`
+	Size int64  `json:"-"`
+
+	Version   string    `json:"version,omitempty"`
+	Sha256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"createdAt"`
+	Dest      string    `json:"dest"`
+	SourceURL string    `json:"sourceURL,omitempty"`
+	SignedBy  []string  `json:"signedBy,omitempty"`
+}
+
+func backupRoot(stateDir, profile string) string {
+	return filepath.Join(stateDir, "backups", profile)
+}
+
+// createBackup copies dest, the installed file of profile, into a new backup
+// directory together with a sidecar recording its version (taken from the
+// profile's state) and SHA256.
+func createBackup(stateDir, profile, dest string) (*Backup, error) {
+	b, err := newBackup(stateDir, profile, dest)
+	if err != nil {
+		return nil, err
+	}
+	if err := CopyFile(dest, b.Path); err != nil {
+		return nil, err
+	}
+	sha, err := sign.FileSHA256(b.Path)
+	if err != nil {
+		return nil, err
+	}
+	b.Sha256 = sha
+	if fi, err := os.Stat(b.Path); err == nil {
+		b.Size = fi.Size()
+	}
+	return b, b.save()
+}
+
+// createDirBackup keeps old, the directory just replaced at dest by an
+// archive install, as a backup of profile. Its SHA256 is that of the tree
+// (see DirSHA256).
+func createDirBackup(stateDir, profile, dest, old string) (*Backup, error) {
+	b, err := newBackup(stateDir, profile, dest)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(old, b.Path); err != nil {
+		// Most likely on another filesystem than the state directory.
+		if err := copyTree(old, b.Path); err != nil {
+			return nil, err
+		}
+	}
+	sha, err := DirSHA256(b.Path)
+	if err != nil {
+		return nil, err
+	}
+	b.Sha256 = sha
+	b.Size = treeSize(b.Path)
+	return b, b.save()
+}
+
+// newBackup creates the directory of a new backup of dest and describes it.
+func newBackup(stateDir, profile, dest string) (*Backup, error) {
+	now := time.Now()
+	b := &Backup{ID: now.Format(backupTimeFormat), CreatedAt: now.UTC(), Dest: dest}
+	if st, err := LoadState(stateDir, profile); err == nil && st != nil && st.Dest == dest {
+		b.Version = st.InstalledVersion
+		b.SourceURL = st.SourceURL
+		b.SignedBy = st.SignedBy
+	}
+
+	backupDir := filepath.Join(backupRoot(stateDir, profile), b.ID)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backupDir); os.IsNotExist(err) {
+			break
+		}
+		b.ID = fmt.Sprintf("%s.%d", now.Format(backupTimeFormat), i)
+		backupDir = filepath.Join(backupRoot(stateDir, profile), b.ID)
+	}
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return nil, err
+	}
+	b.Path = filepath.Join(backupDir, filepath.Base(dest))
+	return b, nil
+}
+
+// save writes the sidecar of the backup.
+func (b *Backup) save() error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(filepath.Dir(b.Path), backupMetaFile), data, 0644); err != nil {
+		return err
+	}
+	logger.Infof("Backed up %s (version %s) to %s", b.Dest, b.Version, b.Path)
+	return nil
+}
+
+// ListBackups returns the backups of profile, newest first. Backups taken
+// before sidecars were written have no version and their SHA256 is computed.
+func ListBackups(stateDir, profile string) ([]Backup, error) {
+	entries, err := os.ReadDir(backupRoot(stateDir, profile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var backups []Backup
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		b, err := readBackup(filepath.Join(backupRoot(stateDir, profile), e.Name()))
+		if err != nil {
+			logger.Warnf("Skipping backup %s: %v", e.Name(), err)
+			continue
+		}
+		backups = append(backups, *b)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].CreatedAt.Equal(backups[j].CreatedAt) {
+			return backups[i].CreatedAt.After(backups[j].CreatedAt)
+		}
+		return backups[i].ID > backups[j].ID
+	})
+	return backups, nil
+}
+
+func readBackup(dir string) (*Backup, error) {
+	b := &Backup{ID: filepath.Base(dir)}
+	data, err := os.ReadFile(filepath.Join(dir, backupMetaFile))
+	if err == nil {
+		if err := json.Unmarshal(data, b); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", backupMetaFile, err)
+		}
+		b.Path = filepath.Join(dir, filepath.Base(b.Dest))
+		fi, err := os.Stat(b.Path)
+		if err != nil {
+			return nil, err
+		}
+		b.Size = fi.Size()
+		if fi.IsDir() {
+			b.Size = treeSize(b.Path)
+		}
+		return b, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Legacy backup: the only file in the directory.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			if b.Path != "" {
+				return nil, fmt.Errorf("several files and no %s", backupMetaFile)
+			}
+			b.Path = filepath.Join(dir, e.Name())
+			if fi, err := e.Info(); err == nil {
+				b.Size = fi.Size()
+			}
+		}
+	}
+	if b.Path == "" {
+		return nil, fmt.Errorf("no backed up file")
+	}
+	if t, err := time.ParseInLocation(backupTimeFormat, b.ID, time.Local); err == nil {
+		b.CreatedAt = t.UTC()
+	}
+	if b.Sha256, err = sign.FileSHA256(b.Path); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// FindBackup returns the backup with the given ID or, failing that, the
+// newest backup of the given version. An empty ref selects the newest backup.
+func FindBackup(backups []Backup, ref string) (*Backup, error) {
+	if len(backups) == 0 {
+		return nil, fmt.Errorf("no backups available")
+	}
+	if ref == "" {
+		return &backups[0], nil
+	}
+	for i := range backups {
+		if backups[i].ID == ref {
+			return &backups[i], nil
+		}
+	}
+	for i := range backups {
+		if backups[i].Version == ref || "v"+backups[i].Version == ref {
+			return &backups[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no backup %q (see 'backups list')", ref)
+}
+
+// RestoreBackup atomically replaces dest with the backed up file or
+// directory, after checking it against the SHA256 recorded when it was taken.
+// With backup the replaced installation is backed up itself, so a rollback
+// can be undone. It returns the SHA256 of what was restored.
+func RestoreBackup(b *Backup, dest, stateDir, profile string, backup bool) (string, error) {
+	if fi, err := os.Stat(b.Path); err == nil && fi.IsDir() {
+		return restoreDirBackup(b, dest, stateDir, profile, backup)
+	}
+	sha, err := sign.FileSHA256(b.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read backup: %v", err)
+	}
+	if sha != b.Sha256 {
+		return "", fmt.Errorf("backup %s is corrupted: expected SHA256 %s, got %s", b.ID, b.Sha256, sha)
+	}
+
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(b.Path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if fi, err := os.Stat(dest); err == nil {
+		// Same application; keep e.g. the executable bit of the install.
+		mode = fi.Mode().Perm()
+		if backup {
+			if _, err := createBackup(stateDir, profile, dest); err != nil {
+				return "", fmt.Errorf("failed to backup: %v", err)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(dest), "itrust-rollback-*")
+	if err != nil {
+		return "", err
+	}
+	tempName := tempFile.Name()
+	tempFile.Close()
+	defer os.Remove(tempName)
+	if err := CopyFile(b.Path, tempName); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tempName, mode); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tempName, dest); err != nil {
+		return "", err
+	}
+	return sha, nil
+}
+
+func restoreDirBackup(b *Backup, dest, stateDir, profile string, backup bool) (string, error) {
+	sha, err := DirSHA256(b.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read backup: %v", err)
+	}
+	if sha != b.Sha256 {
+		return "", fmt.Errorf("backup %s is corrupted: expected SHA256 %s, got %s", b.ID, b.Sha256, sha)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(dest), ".itrust-rollback-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(staging)
+	if err := copyTree(b.Path, staging); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(staging, 0755); err != nil {
+		return "", err
+	}
+	replaced, err := swapDir(staging, dest)
+	if err != nil {
+		return "", err
+	}
+	if replaced && backup {
+		if _, err := createDirBackup(stateDir, profile, dest, staging); err != nil {
+			logger.Warnf("Failed to backup the replaced installation: %v", err)
+		}
+	}
+	return sha, nil
+}
@@ -0,0 +1,107 @@
+package install
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+// installVersion installs content as version of profile the way get does.
+func installVersion(t *testing.T, stateDir, dest, version, content string) {
+	t.Helper()
+	sha := sign.SHA256([]byte(content))
+	if _, err := InstallArtifact(strings.NewReader(content), dest, sha, stateDir, "app", "binary", true); err != nil {
+		t.Fatalf("InstallArtifact failed: %v", err)
+	}
+	st := &State{Profile: "app", AppID: "app1", InstalledVersion: version, InstalledSha256: sha, Dest: dest}
+	if err := SaveState(stateDir, "app", st); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	stateDir := t.TempDir()
+	dest := filepath.Join(t.TempDir(), "app.bin")
+	installVersion(t, stateDir, dest, "1.0.0", "one")
+	installVersion(t, stateDir, dest, "1.1.0", "two")
+	installVersion(t, stateDir, dest, "1.2.0", "three")
+
+	backups, err := ListBackups(stateDir, "app")
+	if err != nil {
+		t.Fatalf("ListBackups failed: %v", err)
+	}
+	if len(backups) != 2 || backups[0].Version != "1.1.0" || backups[1].Version != "1.0.0" {
+		t.Fatalf("Expected backups of 1.1.0 and 1.0.0, got %+v", backups)
+	}
+	if backups[1].Sha256 != sign.SHA256([]byte("one")) {
+		t.Errorf("Expected SHA256 of the backed up file, got %s", backups[1].Sha256)
+	}
+
+	b, err := FindBackup(backups, "1.0.0")
+	if err != nil {
+		t.Fatalf("FindBackup failed: %v", err)
+	}
+	if byID, _ := FindBackup(backups, b.ID); byID == nil || byID.Version != "1.0.0" {
+		t.Errorf("Expected lookup by ID to find 1.0.0, got %+v", byID)
+	}
+	if _, err := FindBackup(backups, "0.9.0"); err == nil {
+		t.Error("Expected error for unknown backup")
+	}
+
+	sha, err := RestoreBackup(b, dest, stateDir, "app", true)
+	if err != nil {
+		t.Fatalf("RestoreBackup failed: %v", err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "one" || sha != sign.SHA256(data) {
+		t.Errorf("Expected restored content, got %q (%s)", data, sha)
+	}
+	if fi, _ := os.Stat(dest); fi.Mode().Perm()&0100 == 0 {
+		t.Errorf("Expected executable bit to be kept, got %v", fi.Mode())
+	}
+
+	// The replaced version is backed up, so the rollback can be undone.
+	backups, _ = ListBackups(stateDir, "app")
+	if len(backups) != 3 || backups[0].Version != "1.2.0" {
+		t.Errorf("Expected a backup of 1.2.0, got %+v", backups)
+	}
+}
+
+func TestRestoreRejectsCorruptedBackup(t *testing.T) {
+	stateDir := t.TempDir()
+	dest := filepath.Join(t.TempDir(), "app.bin")
+	installVersion(t, stateDir, dest, "1.0.0", "one")
+	installVersion(t, stateDir, dest, "1.1.0", "two")
+
+	backups, _ := ListBackups(stateDir, "app")
+	if err := os.WriteFile(backups[0].Path, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RestoreBackup(&backups[0], dest, stateDir, "app", true); err == nil {
+		t.Error("Expected corrupted backup to be refused")
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "two" {
+		t.Errorf("Expected installed file untouched, got %q", data)
+	}
+}
+
+func TestListLegacyBackup(t *testing.T) {
+	stateDir := t.TempDir()
+	dir := filepath.Join(stateDir, "backups", "app", "20250301-120000")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app.bin"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := ListBackups(stateDir, "app")
+	if err != nil {
+		t.Fatalf("ListBackups failed: %v", err)
+	}
+	if len(backups) != 1 || backups[0].Version != "" || backups[0].Sha256 != sign.SHA256([]byte("old")) || backups[0].CreatedAt.IsZero() {
+		t.Errorf("Unexpected legacy backup: %+v", backups)
+	}
+}
@@ -0,0 +1,137 @@
+package install
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/semver"
+)
+
+// CheckDowngrade returns an error describing why installing version from a
+// manifest generated at generatedAt would roll the profile back, or nil if it
+// would not. Any old signed manifest stays valid, so without this check an
+// attacker controlling the storage could serve an older, vulnerable release.
+// pinned marks an explicitly requested version, whose manifest is compared by
+// version only. A nil state (nothing installed yet) never refuses.
+func (s *State) CheckDowngrade(appID, channel, version string, generatedAt time.Time, pinned bool) error {
+	if s == nil || s.AppID != appID {
+		return nil
+	}
+
+	highest := s.highestVersion()
+	if highest != "" {
+		c, err := semver.Compare(version, highest)
+		if err != nil {
+			logger.Debugf("Skipping version comparison: %v", err)
+		} else if c < 0 {
+			return fmt.Errorf("version %s is lower than the highest installed version %s", version, highest)
+		}
+	}
+
+	if !pinned && s.Channel == channel && !s.NewestGeneratedAt.IsZero() && generatedAt.Before(s.NewestGeneratedAt) {
+		return fmt.Errorf("manifest generated at %s is older than the one already installed from (%s)",
+			generatedAt.UTC().Format(time.RFC3339), s.NewestGeneratedAt.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// CheckRevocations returns an error if the repository's revocation list,
+// generated at generatedAt (the zero time if none is published), is older
+// than one the profile has seen before. Otherwise an attacker controlling the
+// storage could bring a revoked key back by deleting the list or serving an
+// older copy.
+func (s *State) CheckRevocations(generatedAt time.Time) error {
+	if s == nil || s.RevocationsGeneratedAt.IsZero() {
+		return nil
+	}
+	if generatedAt.IsZero() {
+		return fmt.Errorf("revocation list is missing, but one generated at %s was seen before",
+			s.RevocationsGeneratedAt.UTC().Format(time.RFC3339))
+	}
+	if generatedAt.Before(s.RevocationsGeneratedAt) {
+		return fmt.Errorf("revocation list generated at %s is older than the one seen before (%s)",
+			generatedAt.UTC().Format(time.RFC3339), s.RevocationsGeneratedAt.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// SeeRevocations records generatedAt as the newest revocation list seen, if
+// it is. It reports whether the state changed.
+func (s *State) SeeRevocations(generatedAt time.Time) bool {
+	if !generatedAt.After(s.RevocationsGeneratedAt) {
+		return false
+	}
+	s.RevocationsGeneratedAt = generatedAt.UTC()
+	return true
+}
+
+// CheckIndex returns an error if the release index of appID, generated at
+// generatedAt (the zero time if none is published), is older than one the
+// profile has seen before. Otherwise an attacker controlling the storage
+// could hide a yank by deleting the index or serving an older copy.
+func (s *State) CheckIndex(appID string, generatedAt time.Time) error {
+	if s == nil || s.AppID != appID || s.IndexGeneratedAt.IsZero() {
+		return nil
+	}
+	if generatedAt.IsZero() {
+		return fmt.Errorf("release index is missing, but one generated at %s was seen before",
+			s.IndexGeneratedAt.UTC().Format(time.RFC3339))
+	}
+	if generatedAt.Before(s.IndexGeneratedAt) {
+		return fmt.Errorf("release index generated at %s is older than the one seen before (%s)",
+			generatedAt.UTC().Format(time.RFC3339), s.IndexGeneratedAt.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// SeeIndex records generatedAt as the newest release index seen, if it is.
+// It reports whether the state changed.
+func (s *State) SeeIndex(generatedAt time.Time) bool {
+	if !generatedAt.After(s.IndexGeneratedAt) {
+		return false
+	}
+	s.IndexGeneratedAt = generatedAt.UTC()
+	return true
+}
+
+// Advance carries the rollback protection marks of prev (which may be nil)
+// and the newest revocation list seen over to s, the state after installing
+// s.InstalledVersion from a manifest generated at generatedAt.
+func (s *State) Advance(prev *State, generatedAt time.Time, pinned bool) {
+	s.HighestVersion = s.InstalledVersion
+	if prev != nil {
+		s.RevocationsGeneratedAt = prev.RevocationsGeneratedAt
+	}
+	if prev != nil && prev.AppID == s.AppID {
+		s.IndexGeneratedAt = prev.IndexGeneratedAt
+		if highest := prev.highestVersion(); highest != "" {
+			if c, err := semver.Compare(highest, s.InstalledVersion); err == nil && c > 0 {
+				s.HighestVersion = highest
+			}
+		}
+		if prev.Channel == s.Channel {
+			s.NewestGeneratedAt = prev.NewestGeneratedAt
+		}
+	}
+	if !pinned && generatedAt.After(s.NewestGeneratedAt) {
+		s.NewestGeneratedAt = generatedAt.UTC()
+	}
+}
+
+// Withdraw lowers the highest installed version from version, which was
+// yanked, to replacement, the release its channel was rolled back to.
+// Otherwise the rollback protection would refuse to leave the yanked release.
+func (s *State) Withdraw(version, replacement string) {
+	if s != nil && s.highestVersion() == version {
+		s.HighestVersion = replacement
+	}
+}
+
+// highestVersion falls back to the installed version for states written
+// before rollback protection was recorded.
+func (s *State) highestVersion() string {
+	if s.HighestVersion != "" {
+		return s.HighestVersion
+	}
+	return s.InstalledVersion
+}
@@ -0,0 +1,192 @@
+package install
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckDowngrade(t *testing.T) {
+	installedAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	st := &State{
+		AppID:             "app1",
+		Channel:           "stable",
+		InstalledVersion:  "1.2.0",
+		HighestVersion:    "1.3.0",
+		NewestGeneratedAt: installedAt,
+	}
+
+	tests := []struct {
+		name        string
+		appID       string
+		channel     string
+		version     string
+		generatedAt time.Time
+		pinned      bool
+		wantErr     bool
+	}{
+		{"newer release", "app1", "stable", "1.4.0", installedAt.Add(time.Hour), false, false},
+		{"same manifest", "app1", "stable", "1.3.0", installedAt, false, false},
+		{"lower version", "app1", "stable", "1.2.5", installedAt.Add(time.Hour), false, true},
+		{"replayed manifest", "app1", "stable", "1.3.0", installedAt.Add(-time.Hour), false, true},
+		{"pinned older manifest", "app1", "stable", "1.3.0", installedAt.Add(-time.Hour), true, false},
+		{"pinned lower version", "app1", "stable", "1.0.0", installedAt, true, true},
+		{"other channel", "app1", "beta", "1.3.0", installedAt.Add(-time.Hour), false, false},
+		{"other app", "app2", "stable", "0.1.0", installedAt.Add(-time.Hour), false, false},
+		{"non-semver version", "app1", "stable", "nightly", installedAt, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := st.CheckDowngrade(tt.appID, tt.channel, tt.version, tt.generatedAt, tt.pinned)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error: %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	var none *State
+	if err := none.CheckDowngrade("app1", "stable", "0.0.1", time.Time{}, false); err != nil {
+		t.Errorf("Expected no error without state, got %v", err)
+	}
+
+	legacy := &State{AppID: "app1", Channel: "stable", InstalledVersion: "2.0.0"}
+	if err := legacy.CheckDowngrade("app1", "stable", "1.9.9", installedAt, false); err == nil {
+		t.Error("Expected installed version to act as highest version for legacy state")
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	t1 := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(24 * time.Hour)
+	prev := &State{AppID: "app1", Channel: "stable", InstalledVersion: "1.3.0", HighestVersion: "1.3.0", NewestGeneratedAt: t2}
+
+	// Allowed downgrade keeps the high-water marks.
+	st := &State{AppID: "app1", Channel: "stable", InstalledVersion: "1.2.0"}
+	st.Advance(prev, t1, true)
+	if st.HighestVersion != "1.3.0" || !st.NewestGeneratedAt.Equal(t2) {
+		t.Errorf("Expected marks to be kept, got %s / %s", st.HighestVersion, st.NewestGeneratedAt)
+	}
+
+	// An upgrade moves them forward.
+	st = &State{AppID: "app1", Channel: "stable", InstalledVersion: "1.4.0"}
+	t3 := t2.Add(time.Hour)
+	st.Advance(prev, t3, false)
+	if st.HighestVersion != "1.4.0" || !st.NewestGeneratedAt.Equal(t3) {
+		t.Errorf("Expected marks to advance, got %s / %s", st.HighestVersion, st.NewestGeneratedAt)
+	}
+
+	// Switching channels starts a new manifest timeline.
+	st = &State{AppID: "app1", Channel: "beta", InstalledVersion: "1.5.0-rc.1"}
+	st.Advance(prev, t1, false)
+	if !st.NewestGeneratedAt.Equal(t1) {
+		t.Errorf("Expected beta timeline to start at %s, got %s", t1, st.NewestGeneratedAt)
+	}
+}
+
+func TestWithdraw(t *testing.T) {
+	installedAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	st := &State{AppID: "app1", Channel: "stable", InstalledVersion: "1.4.1", NewestGeneratedAt: installedAt}
+	if err := st.CheckDowngrade("app1", "stable", "1.4.0", installedAt.Add(time.Hour), false); err == nil {
+		t.Fatal("Expected rollback to be refused before the yank")
+	}
+
+	st.Withdraw("1.3.0", "1.2.0")
+	if st.HighestVersion != "" {
+		t.Errorf("Expected withdrawing another version to change nothing, got %s", st.HighestVersion)
+	}
+	st.Withdraw("1.4.1", "1.4.0")
+	if err := st.CheckDowngrade("app1", "stable", "1.4.0", installedAt.Add(time.Hour), false); err != nil {
+		t.Errorf("Expected rollback from yanked version to be accepted, got %v", err)
+	}
+	if err := st.CheckDowngrade("app1", "stable", "1.4.0", installedAt.Add(-time.Hour), false); err == nil {
+		t.Error("Expected replayed manifest to still be refused")
+	}
+}
+
+func TestCheckRevocations(t *testing.T) {
+	seen := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	st := &State{AppID: "app1", RevocationsGeneratedAt: seen}
+
+	tests := []struct {
+		name        string
+		generatedAt time.Time
+		wantErr     bool
+	}{
+		{"same list", seen, false},
+		{"newer list", seen.Add(time.Hour), false},
+		{"older list", seen.Add(-time.Hour), true},
+		{"deleted list", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := st.CheckRevocations(tt.generatedAt)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	// Nothing seen yet: a repository without a list is fine.
+	if err := (&State{AppID: "app1"}).CheckRevocations(time.Time{}); err != nil {
+		t.Errorf("Expected no error without a list seen, got %v", err)
+	}
+	var none *State
+	if err := none.CheckRevocations(time.Time{}); err != nil {
+		t.Errorf("Expected no error without state, got %v", err)
+	}
+
+	// The mark survives installs of other apps and only moves forward.
+	next := &State{AppID: "app2", InstalledVersion: "1.0.0"}
+	next.Advance(st, seen, false)
+	if next.SeeRevocations(seen.Add(-time.Hour)) || !next.RevocationsGeneratedAt.Equal(seen) {
+		t.Errorf("Expected revocation mark %s to be kept, got %s", seen, next.RevocationsGeneratedAt)
+	}
+	if !next.SeeRevocations(seen.Add(time.Hour)) || !next.RevocationsGeneratedAt.Equal(seen.Add(time.Hour)) {
+		t.Errorf("Expected revocation mark to advance, got %s", next.RevocationsGeneratedAt)
+	}
+}
+
+func TestCheckIndex(t *testing.T) {
+	seen := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	st := &State{AppID: "app1", IndexGeneratedAt: seen}
+
+	tests := []struct {
+		name        string
+		appID       string
+		generatedAt time.Time
+		wantErr     bool
+	}{
+		{"same index", "app1", seen, false},
+		{"newer index", "app1", seen.Add(time.Hour), false},
+		{"older index", "app1", seen.Add(-time.Hour), true},
+		{"deleted index", "app1", time.Time{}, true},
+		{"other app", "app2", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := st.CheckIndex(tt.appID, tt.generatedAt)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	// Nothing seen yet: an app without an index is fine.
+	if err := (&State{AppID: "app1"}).CheckIndex("app1", time.Time{}); err != nil {
+		t.Errorf("Expected no error without an index seen, got %v", err)
+	}
+
+	// The mark survives updates of the same app only and only moves forward.
+	next := &State{AppID: "app1", InstalledVersion: "1.1.0"}
+	next.Advance(st, seen, false)
+	if next.SeeIndex(seen.Add(-time.Hour)) || !next.IndexGeneratedAt.Equal(seen) {
+		t.Errorf("Expected index mark %s to be kept, got %s", seen, next.IndexGeneratedAt)
+	}
+	if !next.SeeIndex(seen.Add(time.Hour)) || !next.IndexGeneratedAt.Equal(seen.Add(time.Hour)) {
+		t.Errorf("Expected index mark to advance, got %s", next.IndexGeneratedAt)
+	}
+	other := &State{AppID: "app2", InstalledVersion: "1.0.0"}
+	other.Advance(st, seen, false)
+	if !other.IndexGeneratedAt.IsZero() {
+		t.Errorf("Expected no index mark for another app, got %s", other.IndexGeneratedAt)
+	}
+}
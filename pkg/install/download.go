@@ -0,0 +1,164 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/progress"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+const downloadAttempts = 5
+
+// downloadRetryDelay is the base delay between attempts; it grows linearly.
+var downloadRetryDelay = 2 * time.Second
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// DownloadOptions tunes DownloadArtifact.
+type DownloadOptions struct {
+	// Progress receives transfer progress; nil disables reporting.
+	Progress progress.Reporter
+	// RateLimit caps the transfer rate in bytes per second; 0 means unlimited.
+	RateLimit int64
+}
+
+// PartialDownloadPath returns the file holding the (partial) download of the
+// artifact with the given SHA256. Keying by hash ensures a resumed download
+// never mixes bytes of two different artifacts.
+func PartialDownloadPath(stateDir, expectedSha256 string) string {
+	return filepath.Join(stateDir, "downloads", expectedSha256+".part")
+}
+
+// DownloadArtifact downloads remotePath into the state directory and returns the
+// path of the completed file, verified against expectedSha256. Interrupted
+// transfers are resumed with range requests when the backend implements
+// backend.RangeGetter; otherwise, or when the server ignores the range, the
+// download restarts from byte zero. size is the expected size (0 if unknown).
+func DownloadArtifact(ctx context.Context, b backend.Backend, remotePath, expectedSha256 string, size int64, stateDir string, opts DownloadOptions) (string, error) {
+	if !sha256Pattern.MatchString(expectedSha256) {
+		return "", fmt.Errorf("invalid artifact SHA256: %q", expectedSha256)
+	}
+	partPath := PartialDownloadPath(stateDir, expectedSha256)
+	if err := os.MkdirAll(filepath.Dir(partPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create download directory: %v", err)
+	}
+	if opts.Progress == nil {
+		opts.Progress = progress.Nop{}
+	}
+	opts.Progress.Start(size)
+	defer opts.Progress.Finish()
+
+	var lastErr error
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		if attempt > 1 {
+			logger.Infof("Retrying download of %s (attempt %d/%d) after error: %v", remotePath, attempt, downloadAttempts, lastErr)
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(time.Duration(attempt-1) * downloadRetryDelay):
+			}
+		}
+
+		offset, err := partialSize(partPath)
+		if err != nil {
+			return "", err
+		}
+		if offset > 0 && (size == 0 || offset >= size) {
+			// Possibly complete from an earlier run; only trust it once verified.
+			actual, err := sign.FileSHA256(partPath)
+			if err != nil {
+				return "", err
+			}
+			if actual == expectedSha256 {
+				return partPath, nil
+			}
+			if size > 0 {
+				logger.Warnf("Partial download %s does not match the expected SHA256, restarting", partPath)
+				offset = 0
+			}
+		}
+
+		offset, err = fetchInto(ctx, b, remotePath, partPath, offset, opts)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		actual, err := sign.FileSHA256(partPath)
+		if err != nil {
+			return "", err
+		}
+		if actual == expectedSha256 {
+			return partPath, nil
+		}
+
+		os.Remove(partPath)
+		lastErr = fmt.Errorf("SHA256 mismatch: expected %s, got %s", expectedSha256, actual)
+		if offset == 0 {
+			// A fresh download with a wrong hash will not get better by retrying.
+			return "", lastErr
+		}
+		logger.Warnf("Resumed download of %s failed verification, restarting from scratch", remotePath)
+	}
+	return "", fmt.Errorf("download of %s failed after %d attempts: %w", remotePath, downloadAttempts, lastErr)
+}
+
+// fetchInto appends the remote content starting at offset to partPath. It
+// returns the offset the transfer actually started from.
+func fetchInto(ctx context.Context, b backend.Backend, remotePath, partPath string, offset int64, opts DownloadOptions) (int64, error) {
+	var body io.ReadCloser
+	var err error
+	rg, canResume := b.(backend.RangeGetter)
+	if offset > 0 && canResume {
+		logger.Infof("Resuming download of %s at byte %d", remotePath, offset)
+		var partial bool
+		body, partial, err = rg.GetRange(ctx, remotePath, offset)
+		if err != nil {
+			return offset, err
+		}
+		if !partial {
+			logger.Infof("Server ignored range request, downloading %s from the beginning", remotePath)
+			offset = 0
+		}
+	} else {
+		offset = 0
+		body, err = b.Get(ctx, remotePath)
+		if err != nil {
+			return offset, err
+		}
+	}
+	defer body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return offset, err
+	}
+	src := progress.NewReader(progress.NewLimitedReader(ctx, body, opts.RateLimit), opts.Progress, offset)
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return offset, err
+	}
+	return offset, f.Close()
+}
+
+func partialSize(path string) (int64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return fi.Size(), nil
+}
@@ -0,0 +1,136 @@
+package install
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+// flakyBackend serves data, breaking the first transfer after failAfter bytes.
+type flakyBackend struct {
+	backend.Backend
+	data        []byte
+	failAfter   int
+	honourRange bool
+	offsets     []int64
+}
+
+type brokenReader struct {
+	r io.Reader
+}
+
+func (b *brokenReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset by peer")
+	}
+	return n, err
+}
+
+func (f *flakyBackend) body(offset int64) io.ReadCloser {
+	f.offsets = append(f.offsets, offset)
+	content := f.data[offset:]
+	if len(f.offsets) == 1 && f.failAfter > 0 {
+		return io.NopCloser(&brokenReader{r: bytes.NewReader(content[:f.failAfter])})
+	}
+	return io.NopCloser(bytes.NewReader(content))
+}
+
+func (f *flakyBackend) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	return f.body(0), nil
+}
+
+func (f *flakyBackend) GetRange(ctx context.Context, path string, offset int64) (io.ReadCloser, bool, error) {
+	if !f.honourRange {
+		return f.body(0), false, nil
+	}
+	return f.body(offset), true, nil
+}
+
+type recordingReporter struct {
+	total, last int64
+	finished    bool
+}
+
+func (r *recordingReporter) Start(total int64) { r.total = total }
+func (r *recordingReporter) Update(done int64) { r.last = done }
+func (r *recordingReporter) Finish()           { r.finished = true }
+
+func TestDownloadArtifact_Resume(t *testing.T) {
+	downloadRetryDelay = 0
+	stateDir := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	sha := sign.SHA256(data)
+
+	b := &flakyBackend{data: data, failAfter: 300, honourRange: true}
+	rep := &recordingReporter{}
+	path, err := DownloadArtifact(context.Background(), b, "app.bin", sha, int64(len(data)), stateDir, DownloadOptions{Progress: rep})
+	if err != nil {
+		t.Fatalf("DownloadArtifact failed: %v", err)
+	}
+
+	if len(b.offsets) != 2 || b.offsets[0] != 0 || b.offsets[1] != 300 {
+		t.Errorf("Expected a full request followed by a resume at 300, got %v", b.offsets)
+	}
+	got, _ := os.ReadFile(path)
+	if !bytes.Equal(got, data) {
+		t.Error("Downloaded content mismatch")
+	}
+	if rep.total != int64(len(data)) || rep.last != int64(len(data)) || !rep.finished {
+		t.Errorf("Unexpected progress reports: %+v", rep)
+	}
+}
+
+func TestDownloadArtifact_RangeIgnored(t *testing.T) {
+	downloadRetryDelay = 0
+	stateDir := t.TempDir()
+	data := bytes.Repeat([]byte("abcdef"), 50)
+	sha := sign.SHA256(data)
+
+	b := &flakyBackend{data: data, failAfter: 100, honourRange: false}
+	path, err := DownloadArtifact(context.Background(), b, "app.bin", sha, int64(len(data)), stateDir, DownloadOptions{})
+	if err != nil {
+		t.Fatalf("DownloadArtifact failed: %v", err)
+	}
+	got, _ := os.ReadFile(path)
+	if !bytes.Equal(got, data) {
+		t.Error("Downloaded content mismatch after falling back to full download")
+	}
+}
+
+func TestDownloadArtifact_CompletePartialReused(t *testing.T) {
+	stateDir := t.TempDir()
+	data := []byte("already downloaded")
+	sha := sign.SHA256(data)
+
+	partPath := PartialDownloadPath(stateDir, sha)
+	os.MkdirAll(stateDir+"/downloads", 0755)
+	os.WriteFile(partPath, data, 0644)
+
+	b := &flakyBackend{data: data}
+	if _, err := DownloadArtifact(context.Background(), b, "app.bin", sha, int64(len(data)), stateDir, DownloadOptions{}); err != nil {
+		t.Fatalf("DownloadArtifact failed: %v", err)
+	}
+	if len(b.offsets) != 0 {
+		t.Errorf("Expected no requests for a complete verified partial file, got %v", b.offsets)
+	}
+}
+
+func TestDownloadArtifact_ShaMismatch(t *testing.T) {
+	stateDir := t.TempDir()
+	b := &flakyBackend{data: []byte("tampered")}
+	sha := sign.SHA256([]byte("original"))
+
+	if _, err := DownloadArtifact(context.Background(), b, "app.bin", sha, 8, stateDir, DownloadOptions{}); err == nil {
+		t.Fatal("Expected SHA256 mismatch error")
+	}
+	if _, err := os.Stat(PartialDownloadPath(stateDir, sha)); !os.IsNotExist(err) {
+		t.Error("Mismatching download should be removed")
+	}
+}
@@ -0,0 +1,18 @@
+package install
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// exchange atomically swaps the directories a and b, so that a path never
+// points at a missing or partial installation.
+func exchange(a, b string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, a, unix.AT_FDCWD, b, unix.RENAME_EXCHANGE)
+	if errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EINVAL) {
+		// Kernel or filesystem without RENAME_EXCHANGE.
+		return exchangeByRename(a, b)
+	}
+	return err
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package install
+
+// exchange swaps the directories a and b. Without an atomic exchange there
+// is a short window in which b does not exist.
+func exchange(a, b string) error {
+	return exchangeByRename(a, b)
+}
@@ -9,9 +9,12 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/alapierre/itrust-updater/pkg/logging"
 	"github.com/alapierre/itrust-updater/pkg/sign"
 )
 
+var logger = logging.Component("pkg/install")
+
 type State struct {
 	Profile          string    `json:"profile"`
 	AppID            string    `json:"appId"`
@@ -24,10 +27,33 @@ type State struct {
 	Arch             string    `json:"arch"`
 	SourceURL        string    `json:"sourceURL"`
 	BackendInfo      string    `json:"backendInfo"`
+	// HighestVersion is the highest version ever installed for the profile and
+	// NewestGeneratedAt the generation time of the newest channel manifest
+	// installed from; together they guard against rollback attacks.
+	HighestVersion    string    `json:"highestVersion,omitempty"`
+	NewestGeneratedAt time.Time `json:"newestGeneratedAt,omitzero"`
+	// RevocationsGeneratedAt is the generation time of the newest revocation
+	// list of the repository seen by the profile.
+	RevocationsGeneratedAt time.Time `json:"revocationsGeneratedAt,omitzero"`
+	// IndexGeneratedAt is the generation time of the newest release index of
+	// the app seen by the profile.
+	IndexGeneratedAt time.Time `json:"indexGeneratedAt,omitzero"`
+	// SignedBy lists the fingerprints of the trusted keys that signed the
+	// manifest of the installed version.
+	SignedBy []string `json:"signedBy,omitempty"`
+	// InstallRoot is set for versioned installs (see InstallVersion): Dest is
+	// then the file in the versions directory of InstallRoot.
+	InstallRoot string `json:"installRoot,omitempty"`
+	// ContentSha256 is the digest of the directory an archive was extracted
+	// to (see DirSHA256); InstalledSha256 is that of the archive.
+	ContentSha256 string `json:"contentSha256,omitempty"`
 }
 
 func LoadState(stateDir, profile string) (*State, error) {
-	path := filepath.Join(stateDir, "state", profile+".json")
+	return loadState(filepath.Join(stateDir, "state", profile+".json"))
+}
+
+func loadState(path string) (*State, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -44,13 +70,17 @@ func LoadState(stateDir, profile string) (*State, error) {
 }
 
 func SaveState(stateDir, profile string, state *State) error {
-	path := filepath.Join(stateDir, "state", profile+".json")
+	return saveJSON(filepath.Join(stateDir, "state", profile+".json"), state)
+}
+
+// saveJSON atomically writes v as indented JSON to path.
+func saveJSON(path string, v any) error {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
 
-	tempFile, err := os.CreateTemp(dir, profile+".json.*.tmp")
+	tempFile, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
 	if err != nil {
 		return err
 	}
@@ -64,7 +94,7 @@ func SaveState(stateDir, profile string, state *State) error {
 
 	encoder := json.NewEncoder(tempFile)
 	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(state); err != nil {
+	if err := encoder.Encode(v); err != nil {
 		return err
 	}
 
@@ -83,7 +113,10 @@ func SaveState(stateDir, profile string, state *State) error {
 	return nil
 }
 
-func InstallArtifact(src io.Reader, dest string, expectedSha256 string, stateDir, profile string, artifactType string) (string, error) {
+// InstallArtifact verifies src against expectedSha256 and atomically replaces
+// dest with it. With backup set, the replaced file is kept as a backup of
+// profile first.
+func InstallArtifact(src io.Reader, dest string, expectedSha256 string, stateDir, profile string, artifactType string, backup bool) (string, error) {
 	destDir := filepath.Dir(dest)
 	if err := os.MkdirAll(destDir, 0755); err != nil {
 		return "", fmt.Errorf("failed to create destination directory: %v", err)
@@ -113,13 +146,8 @@ func InstallArtifact(src io.Reader, dest string, expectedSha256 string, stateDir
 	}
 
 	// Backup
-	if _, err := os.Stat(dest); err == nil {
-		backupDir := filepath.Join(stateDir, "backups", profile, time.Now().Format("20060102-150405"))
-		if err := os.MkdirAll(backupDir, 0755); err != nil {
-			return "", err
-		}
-		backupPath := filepath.Join(backupDir, filepath.Base(dest))
-		if err := CopyFile(dest, backupPath); err != nil {
+	if _, err := os.Stat(dest); err == nil && backup {
+		if _, err := createBackup(stateDir, profile, dest); err != nil {
 			return "", fmt.Errorf("failed to backup: %v", err)
 		}
 	}
@@ -0,0 +1,71 @@
+package install
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Retention limits the backups kept for a profile. Zero limits are unset.
+type Retention struct {
+	// Disabled turns off backups: installs replace the file without a copy.
+	Disabled bool
+	// Keep is the number of most recent backups to keep.
+	Keep int
+	// MaxAge removes backups older than this.
+	MaxAge time.Duration
+	// MaxSize caps the total size of the backups in bytes; the oldest go first.
+	MaxSize int64
+}
+
+// IsZero reports whether r keeps every backup.
+func (r Retention) IsZero() bool {
+	return r.Keep == 0 && r.MaxAge == 0 && r.MaxSize == 0
+}
+
+// PruneBackups removes the backups of profile that r does not keep and
+// returns them. With dryRun nothing is removed. The newest backups are kept
+// first: once one is dropped, every older one is dropped as well. The backup
+// with ID keepID, e.g. the one just restored, is never removed and does not
+// count against the limits.
+func PruneBackups(stateDir, profile string, r Retention, keepID string, dryRun bool) ([]Backup, error) {
+	if r.IsZero() {
+		return nil, nil
+	}
+	all, err := ListBackups(stateDir, profile)
+	if err != nil {
+		return nil, err
+	}
+	backups := all[:0]
+	for _, b := range all {
+		if b.ID != keepID {
+			backups = append(backups, b)
+		}
+	}
+
+	now := time.Now()
+	var total int64
+	var pruned []Backup
+	for i, b := range backups {
+		total += b.Size
+		keep := len(pruned) == 0 &&
+			(r.Keep == 0 || i < r.Keep) &&
+			(r.MaxAge == 0 || b.CreatedAt.IsZero() || now.Sub(b.CreatedAt) <= r.MaxAge) &&
+			(r.MaxSize == 0 || total <= r.MaxSize)
+		if !keep {
+			pruned = append(pruned, b)
+		}
+	}
+	if dryRun {
+		return pruned, nil
+	}
+
+	for i, b := range pruned {
+		logger.Infof("Removing backup %s (version %s) of %s", b.ID, b.Version, profile)
+		if err := os.RemoveAll(filepath.Dir(b.Path)); err != nil {
+			return pruned[:i], fmt.Errorf("failed to remove backup %s: %v", b.ID, err)
+		}
+	}
+	return pruned, nil
+}
@@ -0,0 +1,125 @@
+package install
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeBackup creates a legacy backup of profile taken at the given time.
+func writeBackup(t *testing.T, stateDir, profile string, at time.Time, size int) {
+	t.Helper()
+	dir := filepath.Join(backupRoot(stateDir, profile), at.Format(backupTimeFormat))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app.bin"), []byte(strings.Repeat("x", size)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	tests := []struct {
+		name      string
+		retention Retention
+		kept      int
+	}{
+		{"no limits", Retention{}, 4},
+		{"keep", Retention{Keep: 2}, 2},
+		{"max age", Retention{MaxAge: 36 * time.Hour}, 2},
+		{"max size", Retention{MaxSize: 250}, 2},
+		{"strictest wins", Retention{Keep: 3, MaxAge: 72 * time.Hour, MaxSize: 150}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateDir := t.TempDir()
+			for i := 0; i < 4; i++ {
+				writeBackup(t, stateDir, "app", now.Add(-time.Duration(i)*24*time.Hour), 100)
+			}
+
+			pruned, err := PruneBackups(stateDir, "app", tt.retention, "", true)
+			if err != nil {
+				t.Fatalf("PruneBackups failed: %v", err)
+			}
+			if len(pruned) != 4-tt.kept {
+				t.Errorf("Expected %d backups to be pruned, got %d", 4-tt.kept, len(pruned))
+			}
+			if backups, _ := ListBackups(stateDir, "app"); len(backups) != 4 {
+				t.Errorf("Expected dry run to keep all backups, got %d", len(backups))
+			}
+
+			if _, err := PruneBackups(stateDir, "app", tt.retention, "", false); err != nil {
+				t.Fatalf("PruneBackups failed: %v", err)
+			}
+			backups, _ := ListBackups(stateDir, "app")
+			if len(backups) != tt.kept {
+				t.Fatalf("Expected %d backups kept, got %d", tt.kept, len(backups))
+			}
+			if tt.kept > 0 && !backups[0].CreatedAt.Equal(now) {
+				t.Errorf("Expected the newest backup to be kept, got %s", backups[0].ID)
+			}
+		})
+	}
+}
+
+func TestInstallWithoutBackup(t *testing.T) {
+	stateDir := t.TempDir()
+	dest := filepath.Join(t.TempDir(), "app.bin")
+	installVersion(t, stateDir, dest, "1.0.0", "one")
+
+	if _, err := InstallArtifact(strings.NewReader("two"), dest, "3fc4ccfe745870e2c0d99f71f30ff0656c8dedd41cc1d7d3d376b0dbe685e2f3", stateDir, "app", "binary", false); err != nil {
+		t.Fatalf("InstallArtifact failed: %v", err)
+	}
+	if backups, _ := ListBackups(stateDir, "app"); len(backups) != 0 {
+		t.Errorf("Expected no backups, got %d", len(backups))
+	}
+}
+
+func TestPruneAfterRollbackKeepsRestoredBackup(t *testing.T) {
+	stateDir := t.TempDir()
+	dest := filepath.Join(t.TempDir(), "app.bin")
+	installVersion(t, stateDir, dest, "1.0.0", "one")
+	installVersion(t, stateDir, dest, "1.1.0", "two")
+
+	backups, _ := ListBackups(stateDir, "app")
+	restored, err := FindBackup(backups, "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RestoreBackup(restored, dest, stateDir, "app", true); err != nil {
+		t.Fatalf("RestoreBackup failed: %v", err)
+	}
+
+	pruned, err := PruneBackups(stateDir, "app", Retention{Keep: 1}, restored.ID, false)
+	if err != nil {
+		t.Fatalf("PruneBackups failed: %v", err)
+	}
+	if len(pruned) != 0 {
+		t.Errorf("Expected nothing to be pruned, got %+v", pruned)
+	}
+	backups, _ = ListBackups(stateDir, "app")
+	if len(backups) != 2 || backups[0].Version != "1.1.0" || backups[1].ID != restored.ID {
+		t.Errorf("Expected the backup of 1.1.0 and the restored backup, got %+v", backups)
+	}
+}
+
+func TestRestoreWithoutBackup(t *testing.T) {
+	stateDir := t.TempDir()
+	dest := filepath.Join(t.TempDir(), "app.bin")
+	installVersion(t, stateDir, dest, "1.0.0", "one")
+	installVersion(t, stateDir, dest, "1.1.0", "two")
+
+	backups, _ := ListBackups(stateDir, "app")
+	if _, err := RestoreBackup(&backups[0], dest, stateDir, "app", false); err != nil {
+		t.Fatalf("RestoreBackup failed: %v", err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "one" {
+		t.Errorf("Expected restored content, got %q", data)
+	}
+	if backups, _ := ListBackups(stateDir, "app"); len(backups) != 1 {
+		t.Errorf("Expected no backup of the replaced version, got %d backup(s)", len(backups))
+	}
+}
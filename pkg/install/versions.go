@@ -0,0 +1,252 @@
+package install
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alapierre/itrust-updater/pkg/semver"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+// In the versioned install mode every version of an application is installed
+// side by side under <root>/versions/<version>/ and <root>/current points at
+// the one in use. Switching versions only replaces the pointer, so a rollback
+// is instant and running processes keep their binary until restarted.
+const (
+	versionsDir = "versions"
+	// currentLink is a symlink to versions/<version>.
+	currentLink = "current"
+	// currentPointer holds the current version where symlinks cannot be
+	// created, e.g. on Windows without the privilege.
+	currentPointer = "current.version"
+)
+
+// VersionDir returns the directory version is installed into under root.
+func VersionDir(root, version string) string {
+	return filepath.Join(root, versionsDir, version)
+}
+
+// versionStatePath is the snapshot of the state recorded when version was
+// installed, kept next to (not inside) its directory.
+func versionStatePath(root, version string) string {
+	return VersionDir(root, version) + ".json"
+}
+
+func checkVersionName(version string) error {
+	// Dot names are staging directories and .itrust-old the leftovers of an
+	// interrupted directory swap (see exchangeByRename).
+	if version == "" || strings.HasPrefix(version, ".") || strings.HasSuffix(version, ".itrust-old") || strings.ContainsAny(version, `/\:`) {
+		return fmt.Errorf("invalid version %q for a versioned install", version)
+	}
+	return nil
+}
+
+// InstallVersion installs the downloaded artifact src as version under root
+// and makes it the current version. A single file is installed as name in
+// the version directory, an archive is extracted into it. It returns the
+// path of what was installed and the SHA256 of src. The previous version is
+// left in place, so no backup is taken. Archives are extracted within limits.
+func InstallVersion(src, root, version, name, expectedSha256, stateDir, profile, artifactType string, limits ExtractLimits) (string, string, error) {
+	if err := checkVersionName(version); err != nil {
+		return "", "", err
+	}
+	dest := VersionDir(root, version)
+	var sha string
+	var err error
+	if IsArchive(artifactType) {
+		sha, err = InstallArchive(src, dest, expectedSha256, stateDir, profile, artifactType, false, limits)
+	} else {
+		dest = filepath.Join(dest, name)
+		var f *os.File
+		if f, err = os.Open(src); err != nil {
+			return "", "", err
+		}
+		defer f.Close()
+		sha, err = InstallArtifact(f, dest, expectedSha256, stateDir, profile, artifactType, false)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if err := SwitchCurrent(root, version); err != nil {
+		return "", "", fmt.Errorf("failed to switch to version %s: %v", version, err)
+	}
+	return dest, sha, nil
+}
+
+// SaveVersionState records st, the state right after installing
+// st.InstalledVersion under root, so that a rollback can restore it.
+func SaveVersionState(root string, st *State) error {
+	return saveJSON(versionStatePath(root, st.InstalledVersion), st)
+}
+
+// LoadVersionState returns the state recorded when version was installed
+// under root, after checking that the installed file is unchanged.
+func LoadVersionState(root, version string) (*State, error) {
+	if err := checkVersionName(version); err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(VersionDir(root, version)); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("version %s is not installed in %s", version, root)
+	}
+	st, err := loadState(versionStatePath(root, version))
+	if err != nil {
+		return nil, err
+	}
+	if st == nil {
+		return nil, fmt.Errorf("no record of version %s in %s", version, root)
+	}
+	expected := st.InstalledSha256
+	hash := sign.FileSHA256
+	if st.ContentSha256 != "" {
+		expected, hash = st.ContentSha256, DirSHA256
+	}
+	sha, err := hash(st.Dest)
+	if err != nil {
+		return nil, err
+	}
+	if sha != expected {
+		return nil, fmt.Errorf("version %s is corrupted: expected SHA256 %s, got %s", version, expected, sha)
+	}
+	return st, nil
+}
+
+// SwitchCurrent atomically points root/current at the installed version.
+func SwitchCurrent(root, version string) error {
+	if err := checkVersionName(version); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(VersionDir(root, version)); err != nil || !fi.IsDir() {
+		return fmt.Errorf("version %s is not installed in %s", version, root)
+	}
+
+	tempLink := filepath.Join(root, fmt.Sprintf(".%s.%d.tmp", currentLink, os.Getpid()))
+	os.Remove(tempLink)
+	if err := os.Symlink(filepath.Join(versionsDir, version), tempLink); err != nil {
+		logger.Debugf("Cannot create symlink, using %s: %v", currentPointer, err)
+		return writePointer(root, version)
+	}
+	if err := os.Rename(tempLink, filepath.Join(root, currentLink)); err != nil {
+		os.Remove(tempLink)
+		return err
+	}
+	// A pointer left over from before symlinks worked would be stale.
+	os.Remove(filepath.Join(root, currentPointer))
+	return nil
+}
+
+func writePointer(root, version string) error {
+	tempFile, err := os.CreateTemp(root, "."+currentPointer+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tempName := tempFile.Name()
+	defer os.Remove(tempName)
+	if _, err := tempFile.WriteString(version + "\n"); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempName, filepath.Join(root, currentPointer))
+}
+
+// CurrentVersion returns the version root/current points at, or "" if none.
+func CurrentVersion(root string) (string, error) {
+	if target, err := os.Readlink(filepath.Join(root, currentLink)); err == nil {
+		return filepath.Base(target), nil
+	}
+	data, err := os.ReadFile(filepath.Join(root, currentPointer))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// ListVersions returns the versions installed under root, newest first.
+func ListVersions(root string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(root, versionsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var versions []string
+	for _, e := range entries {
+		if e.IsDir() && checkVersionName(e.Name()) == nil {
+			versions = append(versions, e.Name())
+		}
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		c, err := semver.Compare(versions[i], versions[j])
+		if err != nil {
+			return versions[i] > versions[j]
+		}
+		return c > 0
+	})
+	return versions, nil
+}
+
+// PreviousVersion returns the newest installed version older than the
+// current one, the default target of a rollback.
+func PreviousVersion(root string) (string, error) {
+	current, err := CurrentVersion(root)
+	if err != nil {
+		return "", err
+	}
+	versions, err := ListVersions(root)
+	if err != nil {
+		return "", err
+	}
+	for i, v := range versions {
+		if v == current && i+1 < len(versions) {
+			return versions[i+1], nil
+		}
+	}
+	return "", fmt.Errorf("no version older than %q installed in %s", current, root)
+}
+
+// PruneVersions removes all but the keep newest versions installed under
+// root and returns the removed ones. The current version is always kept. A
+// keep of 0 keeps everything.
+func PruneVersions(root string, keep int, dryRun bool) ([]string, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+	current, err := CurrentVersion(root)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := ListVersions(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []string
+	kept := 0
+	for _, v := range versions {
+		if v == current || kept < keep {
+			kept++
+			continue
+		}
+		pruned = append(pruned, v)
+	}
+	if dryRun {
+		return pruned, nil
+	}
+	for i, v := range pruned {
+		logger.Infof("Removing version %s from %s", v, root)
+		if err := os.RemoveAll(VersionDir(root, v)); err != nil {
+			return pruned[:i], fmt.Errorf("failed to remove version %s: %v", v, err)
+		}
+		os.Remove(versionStatePath(root, v))
+	}
+	return pruned, nil
+}
@@ -0,0 +1,122 @@
+package install
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+func installVersioned(t *testing.T, root, stateDir, version, content string) *State {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), "download")
+	if err := os.WriteFile(src, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest, actual, err := InstallVersion(src, root, version, "app.bin", sign.SHA256([]byte(content)), stateDir, "app", "binary", DefaultExtractLimits)
+	if err != nil {
+		t.Fatalf("InstallVersion failed: %v", err)
+	}
+	st := &State{Profile: "app", AppID: "app1", InstalledVersion: version, InstalledSha256: actual, Dest: dest, InstallRoot: root}
+	if err := SaveVersionState(root, st); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func TestVersionedInstall(t *testing.T) {
+	root := t.TempDir()
+	stateDir := t.TempDir()
+	installVersioned(t, root, stateDir, "1.0.0", "one")
+	installVersioned(t, root, stateDir, "1.1.0", "two")
+
+	if current, _ := CurrentVersion(root); current != "1.1.0" {
+		t.Errorf("Expected current version 1.1.0, got %q", current)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "current", "app.bin")); string(data) != "two" {
+		t.Errorf("Expected current to hold the new version, got %q", data)
+	}
+	if backups, _ := ListBackups(stateDir, "app"); len(backups) != 0 {
+		t.Errorf("Expected no backups for a versioned install, got %d", len(backups))
+	}
+
+	previous, err := PreviousVersion(root)
+	if err != nil || previous != "1.0.0" {
+		t.Fatalf("Expected previous version 1.0.0, got %q (%v)", previous, err)
+	}
+	st, err := LoadVersionState(root, previous)
+	if err != nil {
+		t.Fatalf("LoadVersionState failed: %v", err)
+	}
+	if st.InstalledSha256 != sign.SHA256([]byte("one")) {
+		t.Errorf("Unexpected recorded state: %+v", st)
+	}
+	if err := SwitchCurrent(root, previous); err != nil {
+		t.Fatalf("SwitchCurrent failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "current", "app.bin")); string(data) != "one" {
+		t.Errorf("Expected current to hold the old version, got %q", data)
+	}
+
+	if err := SwitchCurrent(root, "0.9.0"); err == nil {
+		t.Error("Expected error switching to a version that is not installed")
+	}
+	if _, _, err := InstallVersion(st.Dest, root, "../x", "app.bin", st.InstalledSha256, stateDir, "app", "binary", DefaultExtractLimits); err == nil {
+		t.Error("Expected invalid version to be refused")
+	}
+
+	if err := os.WriteFile(st.Dest, []byte("tampered"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadVersionState(root, "1.0.0"); err == nil {
+		t.Error("Expected modified version to be refused")
+	}
+}
+
+func TestCurrentPointerFile(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(VersionDir(root, "1.0.0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePointer(root, "1.0.0"); err != nil {
+		t.Fatalf("writePointer failed: %v", err)
+	}
+	if current, _ := CurrentVersion(root); current != "1.0.0" {
+		t.Errorf("Expected current version from pointer file, got %q", current)
+	}
+}
+
+func TestPruneVersions(t *testing.T) {
+	root := t.TempDir()
+	stateDir := t.TempDir()
+	for _, v := range []string{"1.0.0", "1.2.0", "1.10.0", "1.1.0"} {
+		installVersioned(t, root, stateDir, v, v)
+	}
+	// The current version survives even when it is not among the newest.
+	if err := SwitchCurrent(root, "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := PruneVersions(root, 2, false)
+	if err != nil {
+		t.Fatalf("PruneVersions failed: %v", err)
+	}
+	if strings.Join(pruned, ",") != "1.1.0" {
+		t.Errorf("Expected 1.1.0 to be pruned, got %v", pruned)
+	}
+	// Staging directories and swap leftovers are not versions.
+	for _, name := range []string{".itrust-staging-1", "1.2.0.itrust-old"} {
+		if err := os.MkdirAll(filepath.Join(root, versionsDir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	versions, _ := ListVersions(root)
+	if strings.Join(versions, ",") != "1.10.0,1.2.0,1.0.0" {
+		t.Errorf("Unexpected versions left: %v", versions)
+	}
+	if _, err := os.Stat(versionStatePath(root, "1.1.0")); !os.IsNotExist(err) {
+		t.Errorf("Expected the record of 1.1.0 to be removed")
+	}
+}
@@ -2,76 +2,158 @@ package jcs
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
+	"math"
 	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
 )
 
-// Transform implements JSON Canonicalization Scheme (RFC 8785)
+// Transform implements JSON Canonicalization Scheme (RFC 8785). The input must
+// be I-JSON (RFC 7493): valid UTF-8 without lone surrogates, no duplicate
+// property names and numbers representable as IEEE 754 doubles.
 func Transform(data []byte) ([]byte, error) {
-	var val interface{}
-	if err := json.Unmarshal(data, &val); err != nil {
+	p := &parser{data: data}
+	val, err := p.parse()
+	if err != nil {
 		return nil, err
 	}
-	return canonicalize(val)
+	var buf bytes.Buffer
+	if err := canonicalize(&buf, val); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
-func canonicalize(val interface{}) ([]byte, error) {
+func canonicalize(buf *bytes.Buffer, val interface{}) error {
 	switch v := val.(type) {
 	case nil:
-		return []byte("null"), nil
+		buf.WriteString("null")
 	case bool:
 		if v {
-			return []byte("true"), nil
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
 		}
-		return []byte("false"), nil
 	case float64:
-		// RFC 8785: numbers should be formatted in a specific way.
-		// For simple use cases, json.Marshal on float64 is close,
-		// but JCS has strict rules about exponential notation etc.
-		// However, Go's default json.Marshal for float64 is usually enough for non-extreme numbers.
-		return json.Marshal(v)
+		s, err := FormatNumber(v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(s)
 	case string:
-		return json.Marshal(v)
+		writeString(buf, v)
 	case []interface{}:
-		var buf bytes.Buffer
 		buf.WriteByte('[')
 		for i, item := range v {
 			if i > 0 {
 				buf.WriteByte(',')
 			}
-			b, err := canonicalize(item)
-			if err != nil {
-				return nil, err
+			if err := canonicalize(buf, item); err != nil {
+				return err
 			}
-			buf.Write(b)
 		}
 		buf.WriteByte(']')
-		return buf.Bytes(), nil
 	case map[string]interface{}:
-		keys := make([]string, 0, len(v))
+		// Properties are sorted by their UTF-16 code units, not by UTF-8 bytes.
+		type entry struct {
+			key   string
+			units []uint16
+		}
+		entries := make([]entry, 0, len(v))
 		for k := range v {
-			keys = append(keys, k)
+			entries = append(entries, entry{k, utf16.Encode([]rune(k))})
 		}
-		sort.Strings(keys)
-		var buf bytes.Buffer
+		sort.Slice(entries, func(i, j int) bool {
+			return lessUTF16(entries[i].units, entries[j].units)
+		})
 		buf.WriteByte('{')
-		for i, k := range keys {
+		for i, e := range entries {
 			if i > 0 {
 				buf.WriteByte(',')
 			}
-			keyBytes, _ := json.Marshal(k)
-			buf.Write(keyBytes)
+			writeString(buf, e.key)
 			buf.WriteByte(':')
-			b, err := canonicalize(v[k])
-			if err != nil {
-				return nil, err
+			if err := canonicalize(buf, v[e.key]); err != nil {
+				return err
 			}
-			buf.Write(b)
 		}
 		buf.WriteByte('}')
-		return buf.Bytes(), nil
 	default:
-		return nil, fmt.Errorf("unsupported type: %T", v)
+		return fmt.Errorf("unsupported type: %T", v)
+	}
+	return nil
+}
+
+func lessUTF16(a, b []uint16) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// FormatNumber serializes f like ECMAScript's Number.prototype.toString, as
+// RFC 8785 requires. NaN and infinities are not valid JSON.
+func FormatNumber(f float64) (string, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", fmt.Errorf("invalid JSON number: %v", f)
+	}
+	if f == 0 {
+		// Also covers -0.
+		return "0", nil
+	}
+	sign := ""
+	if f < 0 {
+		f, sign = -f, "-"
+	}
+	// Both Go and ECMAScript pick the shortest digits that round-trip; only
+	// the choice of notation and the exponent format differ.
+	format := byte('e')
+	if f >= 1e-6 && f < 1e21 {
+		format = 'f'
+	}
+	s := strconv.FormatFloat(f, format, -1, 64)
+	if i := strings.IndexByte(s, 'e'); i > 0 && s[i+2] == '0' {
+		// Go writes at least two exponent digits: 1e-07 becomes 1e-7.
+		s = s[:i+2] + s[i+3:]
+	}
+	return sign + s, nil
+}
+
+// writeString escapes only what RFC 8785 requires: quotation mark, reverse
+// solidus and control characters, using the short forms where JSON has them.
+func writeString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if c < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[c>>4])
+				buf.WriteByte(hex[c&0xf])
+			} else {
+				buf.WriteByte(c)
+			}
+		}
 	}
+	buf.WriteByte('"')
 }
@@ -2,7 +2,10 @@ package jcs
 
 import (
 	"bytes"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 )
 
@@ -61,3 +64,104 @@ func TestGolden(t *testing.T) {
 		t.Errorf("Golden test failed")
 	}
 }
+
+// TestRFC8785 runs the test vectors published with RFC 8785.
+func TestRFC8785(t *testing.T) {
+	inputs, err := filepath.Glob("../../testdata/jcs/rfc8785/input/*.json")
+	if err != nil || len(inputs) == 0 {
+		t.Fatalf("RFC 8785 test vectors missing")
+	}
+	for _, in := range inputs {
+		name := filepath.Base(in)
+		t.Run(name, func(t *testing.T) {
+			input, err := os.ReadFile(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, err := os.ReadFile(filepath.Join("../../testdata/jcs/rfc8785/output", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Transform(input)
+			if err != nil {
+				t.Fatalf("Transform failed: %v", err)
+			}
+			if !bytes.Equal(got, expected) {
+				t.Errorf("Expected %s, got %s", expected, got)
+			}
+		})
+	}
+}
+
+// TestFormatNumber uses the IEEE 754 samples of RFC 8785, Appendix B.
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		bits     string
+		expected string
+	}{
+		{"0000000000000000", "0"},
+		{"8000000000000000", "0"},
+		{"0000000000000001", "5e-324"},
+		{"8000000000000001", "-5e-324"},
+		{"7fefffffffffffff", "1.7976931348623157e+308"},
+		{"ffefffffffffffff", "-1.7976931348623157e+308"},
+		{"4340000000000000", "9007199254740992"},
+		{"c340000000000000", "-9007199254740992"},
+		{"4430000000000000", "295147905179352830000"},
+		{"44b52d02c7e14af5", "9.999999999999997e+22"},
+		{"44b52d02c7e14af6", "1e+23"},
+		{"44b52d02c7e14af7", "1.0000000000000001e+23"},
+		{"444b1ae4d6e2ef4e", "999999999999999700000"},
+		{"444b1ae4d6e2ef4f", "999999999999999900000"},
+		{"444b1ae4d6e2ef50", "1e+21"},
+		{"3eb0c6f7a0b5ed8c", "9.999999999999997e-7"},
+		{"3eb0c6f7a0b5ed8d", "0.000001"},
+		{"41b3de4355555553", "333333333.3333332"},
+		{"41b3de4355555554", "333333333.33333325"},
+		{"41b3de4355555555", "333333333.3333333"},
+		{"41b3de4355555556", "333333333.3333334"},
+		{"41b3de4355555557", "333333333.33333343"},
+		{"becbf647612f3696", "-0.0000033333333333333333"},
+		{"43143ff3c1cb0959", "1424953923781206.2"},
+	}
+	for _, tt := range tests {
+		bits, err := strconv.ParseUint(tt.bits, 16, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := FormatNumber(math.Float64frombits(bits))
+		if err != nil {
+			t.Errorf("%s: %v", tt.bits, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.bits, tt.expected, got)
+		}
+	}
+
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := FormatNumber(f); err == nil {
+			t.Errorf("Expected error for %v", f)
+		}
+	}
+}
+
+func TestTransformRejectsInvalidInput(t *testing.T) {
+	for _, input := range []string{
+		`{"a":1,"a":2}`,
+		`"\ud800"`,
+		`"\udc00\ud800"`,
+		`"\ud800\u0041"`,
+		"\"\xff\"",
+		"\"\x01\"",
+		`1e400`,
+		`01`,
+		`[1,]`,
+		`{"a":1} x`,
+		`NaN`,
+	} {
+		if got, err := Transform([]byte(input)); err == nil {
+			t.Errorf("Expected error for %s, got %s", input, got)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package manifest
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/semver"
+)
+
+// IndexEntry describes one published release of an application.
+type IndexEntry struct {
+	Version     string     `json:"version"`
+	ReleaseDate time.Time  `json:"releaseDate"`
+	Channels    []string   `json:"channels"`
+	Artifacts   []Artifact `json:"artifacts"`
+	Yanked      *Yank      `json:"yanked,omitempty"`
+}
+
+// Yank records that a release was withdrawn. Clients refuse to install a
+// yanked release and warn when it is installed.
+type Yank struct {
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+type IndexPayload struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	Repo          RepoInfo     `json:"repo"`
+	App           AppInfo      `json:"app"`
+	GeneratedAt   time.Time    `json:"generatedAt"`
+	Releases      []IndexEntry `json:"releases"`
+}
+
+// Index is the signed release history of an application, stored at
+// IndexPath. It is informational: installs are always verified against the
+// channel or version manifest, never against the index.
+type Index struct {
+	Payload   IndexPayload `json:"payload"`
+	Signature Signature    `json:"signature"`
+}
+
+// IndexPath returns the location of the release index of appID.
+func IndexPath(appID string) string {
+	return fmt.Sprintf("apps/%s/index.json", appID)
+}
+
+func SignIndex(payload IndexPayload, seedB64 string, keyID string) (*Index, error) {
+	sig, err := SignPayload(payload, seedB64, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return &Index{Payload: payload, Signature: *sig}, nil
+}
+
+// Verify checks that the index was signed by pubKey.
+func (i *Index) Verify(pubKey []byte) error {
+	return VerifyPayload(i.Payload, i.Signature, pubKey)
+}
+
+// GeneratedAt returns when the index was generated, the zero time for a nil
+// index.
+func (i *Index) GeneratedAt() time.Time {
+	if i == nil {
+		return time.Time{}
+	}
+	return i.Payload.GeneratedAt
+}
+
+// Yanked returns the yank record of version, or nil if the version was not
+// yanked. A nil index yanks nothing.
+func (i *Index) Yanked(version string) *Yank {
+	if i == nil {
+		return nil
+	}
+	if e := i.Payload.Find(version); e != nil {
+		return e.Yanked
+	}
+	return nil
+}
+
+// Find returns the entry of version, or nil.
+func (p *IndexPayload) Find(version string) *IndexEntry {
+	for i := range p.Releases {
+		if p.Releases[i].Version == version {
+			return &p.Releases[i]
+		}
+	}
+	return nil
+}
+
+// AddRelease records that the release described by the manifest was
+// published to its channel, merging with an existing entry of the same
+// version. Releases are kept sorted newest first.
+func (p *IndexPayload) AddRelease(m *Manifest) {
+	rel := m.Payload.Latest
+	e := p.Find(rel.Version)
+	if e == nil {
+		p.Releases = append(p.Releases, IndexEntry{Version: rel.Version, ReleaseDate: rel.ReleaseDate})
+		e = &p.Releases[len(p.Releases)-1]
+	}
+	e.Artifacts = rel.Artifacts
+	e.AddChannel(m.Payload.Channel)
+	p.sort()
+}
+
+// AddChannel records that the release was published to channel.
+func (e *IndexEntry) AddChannel(channel string) {
+	if channel != "" && !slices.Contains(e.Channels, channel) {
+		e.Channels = append(e.Channels, channel)
+		sort.Strings(e.Channels)
+	}
+}
+
+func (p *IndexPayload) sort() {
+	sort.SliceStable(p.Releases, func(i, j int) bool {
+		c, err := semver.Compare(p.Releases[i].Version, p.Releases[j].Version)
+		if err != nil {
+			return p.Releases[i].ReleaseDate.After(p.Releases[j].ReleaseDate)
+		}
+		return c > 0
+	})
+}
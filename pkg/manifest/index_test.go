@@ -0,0 +1,54 @@
+package manifest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+func TestIndexAddRelease(t *testing.T) {
+	release := func(version, channel string, artifacts ...Artifact) *Manifest {
+		return &Manifest{Payload: Payload{
+			Channel: channel,
+			Latest:  Release{Version: version, ReleaseDate: time.Now().UTC(), Artifacts: artifacts},
+		}}
+	}
+	linux := Artifact{OS: "linux", Arch: "amd64"}
+	windows := Artifact{OS: "windows", Arch: "amd64"}
+
+	var p IndexPayload
+	p.AddRelease(release("1.9.0", "stable", linux))
+	p.AddRelease(release("1.10.0", "beta", linux))
+	p.AddRelease(release("1.9.0", "stable", linux, windows))
+	p.Find("1.10.0").AddChannel("stable")
+
+	if len(p.Releases) != 2 || p.Releases[0].Version != "1.10.0" || p.Releases[1].Version != "1.9.0" {
+		t.Fatalf("Expected 1.10.0 then 1.9.0, got %+v", p.Releases)
+	}
+	if got := p.Releases[0].Channels; len(got) != 2 || got[0] != "beta" || got[1] != "stable" {
+		t.Errorf("Expected channels [beta stable], got %v", got)
+	}
+	if got := p.Releases[1]; len(got.Channels) != 1 || len(got.Artifacts) != 2 {
+		t.Errorf("Expected one channel and 2 artifacts for 1.9.0, got %+v", got)
+	}
+	if p.Find("2.0.0") != nil {
+		t.Error("Expected no entry for unknown version")
+	}
+}
+
+func TestIndexSignature(t *testing.T) {
+	seed := "tG8Y/V8NOnR5i/YkO9uH0WlG6G6fR5e7uI9oP9kI9mI="
+	pubKey, _ := sign.SeedToPubKey(seed)
+	index, err := SignIndex(IndexPayload{SchemaVersion: 1, Releases: []IndexEntry{{Version: "1.0.0"}}}, seed, "k")
+	if err != nil {
+		t.Fatalf("SignIndex failed: %v", err)
+	}
+	if err := index.Verify(pubKey); err != nil {
+		t.Errorf("Expected valid signature, got %v", err)
+	}
+	index.Payload.Releases[0].Version = "9.9.9"
+	if err := index.Verify(pubKey); err == nil {
+		t.Error("Expected tampered index to fail verification")
+	}
+}
@@ -3,6 +3,7 @@ package manifest
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/alapierre/itrust-updater/pkg/jcs"
@@ -13,11 +14,12 @@ import (
 var logger = logging.Component("pkg/manifest")
 
 type Signature struct {
-	Alg           string    `json:"alg"`
-	KeyID         string    `json:"keyId"`
-	CreatedAt     time.Time `json:"createdAt"`
-	PayloadSha256 string    `json:"payloadSha256"`
-	Sig           string    `json:"sig"`
+	Alg             string    `json:"alg"`
+	KeyID           string    `json:"keyId"`
+	PublicKeySha256 string    `json:"publicKeySha256,omitempty"`
+	CreatedAt       time.Time `json:"createdAt"`
+	PayloadSha256   string    `json:"payloadSha256"`
+	Sig             string    `json:"sig"`
 }
 
 type Artifact struct {
@@ -42,6 +44,7 @@ type Payload struct {
 	App           AppInfo   `json:"app"`
 	Channel       string    `json:"channel"`
 	GeneratedAt   time.Time `json:"generatedAt"`
+	ExpiresAt     time.Time `json:"expiresAt,omitzero"`
 	Latest        Release   `json:"latest"`
 }
 
@@ -55,9 +58,13 @@ type AppInfo struct {
 	Name string `json:"name"`
 }
 
+// Manifest is a signed payload. Signature holds the primary (repository key)
+// signature; a cosigned manifest additionally lists all signatures, primary
+// first, in Signatures. Clients predating cosigning only read Signature.
 type Manifest struct {
-	Payload   Payload   `json:"payload"`
-	Signature Signature `json:"signature"`
+	Payload    Payload     `json:"payload"`
+	Signature  Signature   `json:"signature"`
+	Signatures []Signature `json:"signatures,omitempty"`
 }
 
 type ArtifactsList struct {
@@ -66,46 +73,118 @@ type ArtifactsList struct {
 }
 
 func SignManifest(payload Payload, seedB64 string, keyID string) (*Manifest, error) {
-	payloadBytes, err := json.Marshal(payload)
+	sig, err := SignPayload(payload, seedB64, keyID)
 	if err != nil {
 		return nil, err
 	}
-	canonical, err := jcs.Transform(payloadBytes)
+	return &Manifest{Payload: payload, Signature: *sig}, nil
+}
+
+// Verify checks the signature with pubKey and rejects expired manifests.
+func (m *Manifest) Verify(pubKey []byte) error {
+	return m.VerifyThreshold([][]byte{pubKey}, 1)
+}
+
+// VerifySignature checks only that pubKey signed the payload, e.g. for
+// re-signing an expired manifest.
+func (m *Manifest) VerifySignature(pubKey []byte) error {
+	var lastErr error = fmt.Errorf("manifest is not signed")
+	for _, sig := range m.AllSignatures() {
+		if lastErr = VerifyPayload(m.Payload, sig, pubKey); lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+// SignPayload signs the JCS canonical JSON form of payload. It is shared by
+// all signed repository documents.
+func SignPayload(payload any, seedB64 string, keyID string) (*Signature, error) {
+	canonical, err := canonicalize(payload)
 	if err != nil {
 		return nil, err
 	}
-	payloadSha := sign.SHA256(canonical)
 	sig, err := sign.Sign(canonical, seedB64)
 	if err != nil {
 		return nil, err
 	}
-
-	return &Manifest{
-		Payload: payload,
-		Signature: Signature{
-			Alg:           "Ed25519",
-			KeyID:         keyID,
-			CreatedAt:     time.Now().UTC(),
-			PayloadSha256: payloadSha,
-			Sig:           sig,
-		},
+	pubKey, err := sign.SeedToPubKey(seedB64)
+	if err != nil {
+		return nil, err
+	}
+	return &Signature{
+		Alg:             "Ed25519",
+		KeyID:           keyID,
+		PublicKeySha256: sign.SHA256(pubKey),
+		CreatedAt:       time.Now().UTC(),
+		PayloadSha256:   sign.SHA256(canonical),
+		Sig:             sig,
 	}, nil
 }
 
-func (m *Manifest) Verify(pubKey []byte) error {
-	payloadBytes, err := json.Marshal(m.Payload)
+// VerifyPayload checks a signature created by SignPayload.
+func VerifyPayload(payload any, sig Signature, pubKey []byte) error {
+	canonical, err := canonicalize(payload)
 	if err != nil {
 		return err
 	}
-	canonical, err := jcs.Transform(payloadBytes)
+	if sign.SHA256(canonical) != sig.PayloadSha256 {
+		legacy := legacyEscaper.Replace(string(canonical))
+		if sign.SHA256([]byte(legacy)) != sig.PayloadSha256 {
+			return fmt.Errorf("payload SHA256 mismatch")
+		}
+		canonical = []byte(legacy)
+	}
+	return sign.Verify(canonical, sig.Sig, pubKey)
+}
+
+// legacyEscaper reproduces the canonical form of earlier releases, which
+// escaped these characters in strings like encoding/json does. They can only
+// occur inside strings, so replacing them in the canonical output is exact.
+var legacyEscaper = strings.NewReplacer(
+	"<", `\u003c`,
+	">", `\u003e`,
+	"&", `\u0026`,
+	"\u2028", `\u2028`,
+	"\u2029", `\u2029`,
+)
+
+func canonicalize(payload any) ([]byte, error) {
+	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return jcs.Transform(payloadBytes)
+}
+
+// CheckExpiry returns an error if the payload has expired at now. ExpiresAt
+// bounds how long a channel manifest may be served, so a mirror cannot freeze
+// clients on a stale release. A zero ExpiresAt means no expiry; it is omitted
+// from the signed payload, which keeps manifests from before expiry valid.
+func (p *Payload) CheckExpiry(now time.Time) error {
+	if !p.ExpiresAt.IsZero() && now.After(p.ExpiresAt) {
+		return fmt.Errorf("manifest expired at %s", p.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// VerifyBinding checks that the signed payload is the one that was asked for, so
+// a validly signed manifest of another repository, app, channel or version
+// cannot be substituted for it. Empty expectations are not checked.
+func (p *Payload) VerifyBinding(repoID, appID, channel, version string) error {
+	if repoID != "" && p.Repo.ID != repoID {
+		return fmt.Errorf("manifest belongs to repository %q, expected %q", p.Repo.ID, repoID)
+	}
+	if appID != "" && p.App.ID != appID {
+		return fmt.Errorf("manifest belongs to app %q, expected %q", p.App.ID, appID)
+	}
+	if channel != "" && p.Channel != channel {
+		return fmt.Errorf("manifest belongs to channel %q, expected %q", p.Channel, channel)
 	}
-	payloadSha := sign.SHA256(canonical)
-	if payloadSha != m.Signature.PayloadSha256 {
-		return fmt.Errorf("payload SHA256 mismatch")
+	if version != "" && p.Latest.Version != version {
+		return fmt.Errorf("manifest describes version %q, expected %q", p.Latest.Version, version)
 	}
-	return sign.Verify(canonical, m.Signature.Sig, pubKey)
+	return nil
 }
 
 func (m *Manifest) FindArtifact(os, arch string) (*Artifact, error) {
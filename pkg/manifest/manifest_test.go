@@ -2,6 +2,7 @@ package manifest
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 
@@ -102,3 +103,100 @@ func TestArtifactsList(t *testing.T) {
 		t.Errorf("Data mismatch")
 	}
 }
+
+func TestVerifyBinding(t *testing.T) {
+	p := Payload{
+		Repo:    RepoInfo{ID: "repo1"},
+		App:     AppInfo{ID: "app1"},
+		Channel: "stable",
+		Latest:  Release{Version: "1.2.3"},
+	}
+
+	tests := []struct {
+		name                            string
+		repoID, appID, channel, version string
+		wantErr                         bool
+	}{
+		{"match", "repo1", "app1", "stable", "1.2.3", false},
+		{"empty expectations", "", "", "", "", false},
+		{"other repo", "repo2", "app1", "stable", "", true},
+		{"other app", "repo1", "app2", "stable", "", true},
+		{"other channel", "repo1", "app1", "beta", "", true},
+		{"other version", "repo1", "app1", "", "1.2.4", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.VerifyBinding(tt.repoID, tt.appID, tt.channel, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error: %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestManifestExpiry(t *testing.T) {
+	seedB64 := "tG8Y/V8NOnR5i/YkO9uH0WlG6G6fR5e7uI9oP9kI9mI="
+	pubKey, err := sign.SeedToPubKey(seedB64)
+	if err != nil {
+		t.Fatalf("SeedToPubKey failed: %v", err)
+	}
+
+	payload := Payload{SchemaVersion: 1, App: AppInfo{ID: "app1"}, GeneratedAt: time.Now().UTC()}
+	data, _ := json.Marshal(payload)
+	if strings.Contains(string(data), "expiresAt") {
+		t.Errorf("Expected no expiresAt in payload without expiry, got %s", data)
+	}
+
+	payload.ExpiresAt = time.Now().Add(time.Hour).UTC()
+	m, err := SignManifest(payload, seedB64, "test-key")
+	if err != nil {
+		t.Fatalf("SignManifest failed: %v", err)
+	}
+	if err := m.Verify(pubKey); err != nil {
+		t.Errorf("Expected valid manifest, got %v", err)
+	}
+
+	payload.ExpiresAt = time.Now().Add(-time.Hour).UTC()
+	m, err = SignManifest(payload, seedB64, "test-key")
+	if err != nil {
+		t.Fatalf("SignManifest failed: %v", err)
+	}
+	if err := m.Verify(pubKey); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Expected expiry error, got %v", err)
+	}
+	if err := m.VerifySignature(pubKey); err != nil {
+		t.Errorf("Expected valid signature of expired manifest, got %v", err)
+	}
+}
+
+func TestVerifyLegacyCanonicalForm(t *testing.T) {
+	seedB64 := "tG8Y/V8NOnR5i/YkO9uH0WlG6G6fR5e7uI9oP9kI9mI="
+	pubKey, _ := sign.SeedToPubKey(seedB64)
+	notes := "Fixes <b> & more"
+	payload := Payload{SchemaVersion: 1, App: AppInfo{ID: "app1"}, Latest: Release{Version: "1.0.0", Notes: notes}}
+
+	canonical, err := canonicalize(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(canonical), `"Fixes <b> & more"`) {
+		t.Fatalf("Expected unescaped notes in canonical form, got %s", canonical)
+	}
+
+	// Earlier releases signed the encoding/json escaping of strings.
+	escaped, _ := json.Marshal(notes)
+	legacy := []byte(strings.Replace(string(canonical), `"`+notes+`"`, string(escaped), 1))
+	sigB64, err := sign.Sign(legacy, seedB64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := Signature{Alg: "Ed25519", PayloadSha256: sign.SHA256(legacy), Sig: sigB64}
+	if err := VerifyPayload(payload, sig, pubKey); err != nil {
+		t.Errorf("Expected legacy signature to verify, got %v", err)
+	}
+
+	payload.Latest.Notes = "Fixes <i> & more"
+	if err := VerifyPayload(payload, sig, pubKey); err == nil {
+		t.Error("Expected error for modified payload")
+	}
+}
@@ -0,0 +1,122 @@
+package manifest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+// AllSignatures returns every signature of the manifest, primary first.
+func (m *Manifest) AllSignatures() []Signature {
+	if len(m.Signatures) > 0 {
+		return m.Signatures
+	}
+	if m.Signature.Sig == "" {
+		return nil
+	}
+	return []Signature{m.Signature}
+}
+
+// AddSignature cosigns the manifest with seedB64. A previous signature of the
+// same key is replaced. The first signature stays the primary one.
+func (m *Manifest) AddSignature(seedB64, keyID string) error {
+	sig, err := SignPayload(m.Payload, seedB64, keyID)
+	if err != nil {
+		return err
+	}
+	var sigs []Signature
+	replaced := false
+	for _, existing := range m.AllSignatures() {
+		if existing.PublicKeySha256 == sig.PublicKeySha256 {
+			existing, replaced = *sig, true
+		}
+		sigs = append(sigs, existing)
+	}
+	if !replaced {
+		sigs = append(sigs, *sig)
+	}
+	m.SetSignatures(sigs)
+	return nil
+}
+
+// RemoveSignatures drops every signature made by pubKey.
+func (m *Manifest) RemoveSignatures(pubKey []byte) {
+	var sigs []Signature
+	for _, sig := range m.AllSignatures() {
+		if VerifyPayload(m.Payload, sig, pubKey) != nil {
+			sigs = append(sigs, sig)
+		}
+	}
+	m.SetSignatures(sigs)
+}
+
+// HasCosignatures reports whether the manifest carries signatures of keys
+// other than repoKey, which signing a changed payload would drop.
+func (m *Manifest) HasCosignatures(repoKey []byte) bool {
+	for _, sig := range m.AllSignatures() {
+		if VerifyPayload(m.Payload, sig, repoKey) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// SetSignatures replaces all signatures; the first one becomes the primary.
+func (m *Manifest) SetSignatures(sigs []Signature) {
+	m.Signatures = nil
+	m.Signature = Signature{}
+	if len(sigs) > 0 {
+		m.Signature = sigs[0]
+	}
+	// A single signature keeps the original format.
+	if len(sigs) > 1 {
+		m.Signatures = sigs
+	}
+}
+
+// VerifyThreshold checks that at least threshold distinct keys out of pubKeys
+// have a valid signature on the manifest, and that it has not expired.
+func (m *Manifest) VerifyThreshold(pubKeys [][]byte, threshold int) error {
+	if threshold < 1 {
+		threshold = 1
+	}
+	if threshold > len(pubKeys) {
+		return fmt.Errorf("signature threshold %d exceeds the %d trusted key(s)", threshold, len(pubKeys))
+	}
+
+	valid, lastErr := m.validSigners(pubKeys)
+	if len(valid) < threshold {
+		if threshold == 1 && lastErr != nil {
+			return lastErr
+		}
+		return fmt.Errorf("manifest has valid signatures from %d trusted key(s), %d required", len(valid), threshold)
+	}
+	return m.Payload.CheckExpiry(time.Now())
+}
+
+// Signers returns the fingerprints of the keys out of pubKeys that have a
+// valid signature on the manifest.
+func (m *Manifest) Signers(pubKeys [][]byte) []string {
+	valid, _ := m.validSigners(pubKeys)
+	return valid
+}
+
+func (m *Manifest) validSigners(pubKeys [][]byte) ([]string, error) {
+	var valid []string
+	seen := make(map[string]bool)
+	var lastErr error
+	for _, pubKey := range pubKeys {
+		fp := sign.SHA256(pubKey)
+		if seen[fp] {
+			continue
+		}
+		seen[fp] = true
+		if err := m.VerifySignature(pubKey); err != nil {
+			lastErr = err
+			continue
+		}
+		valid = append(valid, fp)
+	}
+	return valid, lastErr
+}
@@ -0,0 +1,110 @@
+package manifest
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+const (
+	pipelineSeed = "tG8Y/V8NOnR5i/YkO9uH0WlG6G6fR5e7uI9oP9kI9mI="
+	managerSeed  = "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="
+	otherSeed    = "AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI="
+)
+
+func pubKey(t *testing.T, seed string) []byte {
+	t.Helper()
+	key, err := sign.SeedToPubKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestThresholdVerification(t *testing.T) {
+	pipeline, manager, other := pubKey(t, pipelineSeed), pubKey(t, managerSeed), pubKey(t, otherSeed)
+	payload := Payload{SchemaVersion: 1, App: AppInfo{ID: "app1"}, GeneratedAt: time.Now().UTC(), Latest: Release{Version: "1.0.0"}}
+
+	m, err := SignManifest(payload, pipelineSeed, "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.VerifyThreshold([][]byte{pipeline, manager}, 2); err == nil || !strings.Contains(err.Error(), "1 trusted key(s), 2 required") {
+		t.Errorf("Expected threshold error before cosigning, got %v", err)
+	}
+
+	if err := m.AddSignature(managerSeed, "manager"); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Signatures) != 2 || m.Signature.KeyID != "pipeline" {
+		t.Fatalf("Expected 2 signatures with the pipeline signature primary, got %+v", m.Signatures)
+	}
+	if err := m.VerifyThreshold([][]byte{pipeline, manager}, 2); err != nil {
+		t.Errorf("Expected 2 of 2 to verify, got %v", err)
+	}
+	if err := m.VerifyThreshold([][]byte{pipeline, other}, 2); err == nil {
+		t.Error("Expected error when a required key did not sign")
+	}
+	if err := m.VerifyThreshold([][]byte{pipeline, pipeline}, 2); err == nil {
+		t.Error("Expected the same key to count once")
+	}
+	if err := m.VerifyThreshold([][]byte{pipeline}, 2); err == nil {
+		t.Error("Expected error for threshold above the number of keys")
+	}
+
+	// Signing again with the same key replaces the signature.
+	if err := m.AddSignature(managerSeed, "manager-2"); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Signatures) != 2 || m.Signatures[1].KeyID != "manager-2" {
+		t.Errorf("Expected the manager signature to be replaced, got %+v", m.Signatures)
+	}
+
+	m.RemoveSignatures(pipeline)
+	if m.Signature.KeyID != "manager-2" || len(m.Signatures) != 0 {
+		t.Errorf("Expected only the manager signature to remain, got %+v / %+v", m.Signature, m.Signatures)
+	}
+}
+
+func TestMultiSignatureCompatibility(t *testing.T) {
+	pipeline := pubKey(t, pipelineSeed)
+	payload := Payload{SchemaVersion: 1, App: AppInfo{ID: "app1"}, GeneratedAt: time.Now().UTC()}
+
+	m, err := SignManifest(payload, pipelineSeed, "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	single, _ := json.Marshal(m)
+	if strings.Contains(string(single), `"signatures"`) {
+		t.Errorf("Expected single-signature format, got %s", single)
+	}
+
+	if err := m.AddSignature(managerSeed, "manager"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(m)
+
+	// A client that predates cosigning only knows the primary signature.
+	var old struct {
+		Payload   Payload   `json:"payload"`
+		Signature Signature `json:"signature"`
+	}
+	if err := json.Unmarshal(data, &old); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyPayload(old.Payload, old.Signature, pipeline); err != nil {
+		t.Errorf("Expected old clients to verify the primary signature, got %v", err)
+	}
+
+	// Manifests without the array still verify.
+	var legacy Manifest
+	if err := json.Unmarshal(single, &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if err := legacy.Verify(pipeline); err != nil {
+		t.Errorf("Expected single-signature manifest to verify, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reporter receives progress updates of a single transfer.
+type Reporter interface {
+	// Start begins a transfer of total bytes (0 if unknown).
+	Start(total int64)
+	// Update reports the number of bytes of the target completed so far. The
+	// value may go back when a transfer restarts from the beginning.
+	Update(done int64)
+	// Finish ends the transfer.
+	Finish()
+}
+
+// Nop discards all progress updates.
+type Nop struct{}
+
+func (Nop) Start(total int64) {}
+func (Nop) Update(done int64) {}
+func (Nop) Finish()           {}
+
+// stats tracks the transfer state shared by the reporters. The rate is based
+// on bytes actually transferred in this run, so resumed bytes don't inflate it.
+type stats struct {
+	mu          sync.Mutex
+	total       int64
+	done        int64
+	transferred int64
+	baselined   bool
+	started     time.Time
+	now         func() time.Time
+}
+
+func (s *stats) start(total int64) {
+	s.total = total
+	s.done = 0
+	s.transferred = 0
+	s.baselined = false
+	s.started = s.now()
+}
+
+func (s *stats) update(done int64) {
+	// The first update carries bytes already present from an earlier run.
+	if s.baselined && done > s.done {
+		s.transferred += done - s.done
+	}
+	s.done = done
+	s.baselined = true
+}
+
+// rate returns the average rate in bytes per second.
+func (s *stats) rate() float64 {
+	elapsed := s.now().Sub(s.started).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(s.transferred) / elapsed
+}
+
+// eta returns the estimated remaining time, or -1 if unknown.
+func (s *stats) eta() time.Duration {
+	rate := s.rate()
+	if s.total <= 0 || rate <= 0 {
+		return -1
+	}
+	remaining := float64(s.total-s.done) / rate
+	return time.Duration(remaining * float64(time.Second)).Round(time.Second)
+}
+
+func (s *stats) percent() int {
+	if s.total <= 0 {
+		return 0
+	}
+	p := int(s.done * 100 / s.total)
+	if p > 100 {
+		p = 100
+	}
+	return p
+}
+
+// Bar renders a single-line progress bar, meant for interactive terminals.
+type Bar struct {
+	stats
+	w         io.Writer
+	width     int
+	interval  time.Duration
+	lastDraw  time.Time
+	lastWidth int
+}
+
+func NewBar(w io.Writer) *Bar {
+	return &Bar{stats: stats{now: time.Now}, w: w, width: 30, interval: 100 * time.Millisecond}
+}
+
+func (b *Bar) Start(total int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.start(total)
+	b.draw()
+}
+
+func (b *Bar) Update(done int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.update(done)
+	if b.now().Sub(b.lastDraw) >= b.interval {
+		b.draw()
+	}
+}
+
+func (b *Bar) Finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.draw()
+	fmt.Fprintln(b.w)
+}
+
+func (b *Bar) draw() {
+	b.lastDraw = b.now()
+	var line string
+	if b.total > 0 {
+		filled := b.width * b.percent() / 100
+		bar := strings.Repeat("=", filled)
+		if filled < b.width {
+			bar += ">" + strings.Repeat(" ", b.width-filled-1)
+		}
+		line = fmt.Sprintf("[%s] %3d%% %s/%s %s/s", bar, b.percent(), FormatBytes(b.done), FormatBytes(b.total), FormatBytes(int64(b.rate())))
+		if eta := b.eta(); eta >= 0 {
+			line += " ETA " + eta.String()
+		}
+	} else {
+		line = fmt.Sprintf("%s %s/s", FormatBytes(b.done), FormatBytes(int64(b.rate())))
+	}
+	// Pad to overwrite leftovers of a longer previous line.
+	pad := ""
+	if len(line) < b.lastWidth {
+		pad = strings.Repeat(" ", b.lastWidth-len(line))
+	}
+	b.lastWidth = len(line)
+	fmt.Fprintf(b.w, "\r%s%s", line, pad)
+}
+
+// LineReporter emits a progress line every interval, meant for logs and
+// non-interactive output.
+type LineReporter struct {
+	stats
+	emit     func(line string)
+	interval time.Duration
+	lastEmit time.Time
+}
+
+func NewLineReporter(emit func(line string), interval time.Duration) *LineReporter {
+	return &LineReporter{stats: stats{now: time.Now}, emit: emit, interval: interval}
+}
+
+func (l *LineReporter) Start(total int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.start(total)
+	l.lastEmit = l.started
+}
+
+func (l *LineReporter) Update(done int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.update(done)
+	if l.now().Sub(l.lastEmit) >= l.interval {
+		l.lastEmit = l.now()
+		l.emit(l.line())
+	}
+}
+
+func (l *LineReporter) Finish() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.emit(l.line())
+}
+
+func (l *LineReporter) line() string {
+	if l.total <= 0 {
+		return fmt.Sprintf("Downloaded %s at %s/s", FormatBytes(l.done), FormatBytes(int64(l.rate())))
+	}
+	line := fmt.Sprintf("Downloaded %s of %s (%d%%) at %s/s", FormatBytes(l.done), FormatBytes(l.total), l.percent(), FormatBytes(int64(l.rate())))
+	if eta := l.eta(); eta >= 0 {
+		line += ", ETA " + eta.String()
+	}
+	return line
+}
+
+// Reader reports the bytes read through it to a Reporter, starting at offset.
+type Reader struct {
+	r        io.Reader
+	reporter Reporter
+	done     int64
+}
+
+func NewReader(r io.Reader, reporter Reporter, offset int64) *Reader {
+	reporter.Update(offset)
+	return &Reader{r: r, reporter: reporter, done: offset}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.done += int64(n)
+		r.reporter.Update(r.done)
+	}
+	return n, err
+}
+
+// FormatBytes formats a byte count using binary units, e.g. "12.3 MB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 3; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
@@ -0,0 +1,103 @@
+package progress
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := map[string]int64{
+		"":       0,
+		"1000":   1000,
+		"500K":   500 * 1024,
+		"2m":     2 * 1024 * 1024,
+		"1.5MB":  1536 * 1024,
+		"1G":     1 << 30,
+		"100k/s": 100 * 1024,
+	}
+	for in, expected := range tests {
+		got, err := ParseRate(in)
+		if err != nil {
+			t.Errorf("ParseRate(%q) failed: %v", in, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("ParseRate(%q): expected %d, got %d", in, expected, got)
+		}
+	}
+	for _, in := range []string{"fast", "-1M", "M"} {
+		if _, err := ParseRate(in); err == nil {
+			t.Errorf("ParseRate(%q) should fail", in)
+		}
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	var slept time.Duration
+	r := NewLimitedReader(context.Background(), strings.NewReader(strings.Repeat("x", 4096)), 1024).(*LimitedReader)
+	r.sleep = func(ctx context.Context, d time.Duration) error {
+		slept += d
+		r.started = r.started.Add(-d)
+		return nil
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil || len(data) != 4096 {
+		t.Fatalf("ReadAll failed: %v (%d bytes)", err, len(data))
+	}
+	if slept < 3*time.Second || slept > 5*time.Second {
+		t.Errorf("Expected about 4s of throttling for 4 KB at 1 KB/s, got %v", slept)
+	}
+
+	if NewLimitedReader(context.Background(), strings.NewReader("x"), 0) == nil {
+		t.Error("Unlimited reader should be returned as is")
+	}
+}
+
+func TestLineReporter(t *testing.T) {
+	var lines []string
+	l := NewLineReporter(func(line string) { lines = append(lines, line) }, 10*time.Second)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	l.Start(10 * 1024 * 1024)
+	l.Update(1024 * 1024)
+	now = now.Add(10 * time.Second)
+	l.Update(2 * 1024 * 1024)
+	l.Finish()
+
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %v", lines)
+	}
+	expected := "Downloaded 2.0 MB of 10.0 MB (20%) at 102.4 KB/s, ETA 1m20s"
+	if lines[0] != expected {
+		t.Errorf("Expected %q, got %q", expected, lines[0])
+	}
+}
+
+func TestBarAndReader(t *testing.T) {
+	var buf bytes.Buffer
+	bar := NewBar(&buf)
+	bar.Start(10)
+	r := NewReader(strings.NewReader("01234"), bar, 5)
+	io.ReadAll(r)
+	bar.Finish()
+
+	out := buf.String()
+	if !strings.Contains(out, "100%") || !strings.Contains(out, "10 B/10 B") || !strings.HasSuffix(out, "\n") {
+		t.Errorf("Unexpected bar output: %q", out)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KB", 200 * 1024 * 1024: "200.0 MB"}
+	for in, expected := range tests {
+		if got := FormatBytes(in); got != expected {
+			t.Errorf("FormatBytes(%d): expected %s, got %s", in, expected, got)
+		}
+	}
+}
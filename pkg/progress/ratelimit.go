@@ -0,0 +1,89 @@
+package progress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LimitedReader throttles reads to a fixed number of bytes per second.
+type LimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	rate    int64
+	read    int64
+	started time.Time
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+// NewLimitedReader returns r throttled to bytesPerSecond. A non-positive rate
+// disables limiting.
+func NewLimitedReader(ctx context.Context, r io.Reader, bytesPerSecond int64) io.Reader {
+	if bytesPerSecond <= 0 {
+		return r
+	}
+	return &LimitedReader{ctx: ctx, r: r, rate: bytesPerSecond, sleep: sleepContext}
+}
+
+func (l *LimitedReader) Read(p []byte) (int, error) {
+	if l.started.IsZero() {
+		l.started = time.Now()
+	}
+	// Read in slices of at most 1/10 s worth of data to keep the flow smooth.
+	if chunk := l.rate / 10; chunk > 0 && int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+
+	expected := time.Duration(float64(l.read) / float64(l.rate) * float64(time.Second))
+	if wait := expected - time.Since(l.started); wait > 0 {
+		if serr := l.sleep(l.ctx, wait); serr != nil {
+			return n, serr
+		}
+	}
+	return n, err
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// ParseRate parses a rate in bytes per second such as "500K", "2M" or "1.5MB"
+// (binary units). An empty string means unlimited and yields 0.
+func ParseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "/S"), "B")
+	multiplier := int64(1)
+	if num != "" {
+		switch num[len(num)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			num = num[:len(num)-1]
+		}
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid rate %q (expected e.g. 500K or 2M)", s)
+	}
+	return int64(v * float64(multiplier)), nil
+}
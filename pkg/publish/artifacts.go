@@ -0,0 +1,171 @@
+package publish
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// LocalArtifact is a local file to be published for one platform.
+type LocalArtifact struct {
+	Path string
+	OS   string
+	Arch string
+}
+
+var platformPattern = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9]+$`)
+
+var (
+	knownOS   = []string{"linux", "windows", "darwin", "freebsd", "openbsd", "netbsd", "android", "ios", "solaris", "illumos", "aix"}
+	knownArch = []string{"amd64", "arm64", "386", "arm", "ppc64le", "ppc64", "s390x", "riscv64", "mips64le", "mips64", "mipsle", "mips", "loong64"}
+)
+
+// ParseArtifactSpec parses "path[:os/arch]". Without a platform, it is taken
+// from the file name (see PlatformFromName). A path may be a glob matching
+// several files.
+func ParseArtifactSpec(spec string) ([]LocalArtifact, error) {
+	pattern, platform := spec, ""
+	if i := strings.LastIndex(spec, ":"); i > 0 && platformPattern.MatchString(spec[i+1:]) {
+		pattern, platform = spec[:i], spec[i+1:]
+	}
+
+	paths := []string{pattern}
+	if strings.ContainsAny(pattern, "*?[") {
+		var err error
+		if paths, err = filepath.Glob(pattern); err != nil {
+			return nil, fmt.Errorf("invalid artifact pattern %q: %v", pattern, err)
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("no files match %q", pattern)
+		}
+		if platform != "" && len(paths) > 1 {
+			return nil, fmt.Errorf("%q matches %d files but names a single platform", pattern, len(paths))
+		}
+	}
+
+	var artifacts []LocalArtifact
+	for _, p := range paths {
+		a := LocalArtifact{Path: p}
+		switch {
+		case ArtifactType(p) == "jar":
+			// Runs anywhere.
+			a.OS, a.Arch = "any", "any"
+		case platform != "":
+			a.OS, a.Arch, _ = strings.Cut(platform, "/")
+		default:
+			if a.OS, a.Arch = PlatformFromName(p); a.OS == "" {
+				return nil, fmt.Errorf("cannot tell the platform of %s, use %s:<os>/<arch>", p, p)
+			}
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, nil
+}
+
+// PlatformFromName finds an OS followed by an architecture in a file name
+// such as app_1.0.0_linux_amd64.tar.gz. It returns empty strings if the name
+// names no platform.
+func PlatformFromName(path string) (goos, goarch string) {
+	tokens := strings.FieldsFunc(strings.ToLower(filepath.Base(path)), func(r rune) bool {
+		return r == '_' || r == '-' || r == '.'
+	})
+	for i := 0; i+1 < len(tokens); i++ {
+		if contains(knownOS, tokens[i]) && contains(knownArch, tokens[i+1]) {
+			return tokens[i], tokens[i+1]
+		}
+	}
+	return "", ""
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// distArtifact is an entry of GoReleaser's dist/artifacts.json.
+type distArtifact struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Goos   string `json:"goos"`
+	Goarch string `json:"goarch"`
+	Type   string `json:"type"`
+}
+
+// DistArtifacts returns the release artifacts of a GoReleaser dist directory,
+// read from its artifacts.json: the archives or, if the build produced none,
+// the binaries.
+func DistArtifacts(dir string) ([]LocalArtifact, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "artifacts.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dist artifacts: %v", err)
+	}
+	var entries []distArtifact
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", filepath.Join(dir, "artifacts.json"), err)
+	}
+
+	var artifacts []LocalArtifact
+	for _, kind := range []string{"Archive", "Binary"} {
+		for _, e := range entries {
+			if e.Type != kind || e.Goos == "" || e.Goarch == "" {
+				continue
+			}
+			p := e.Path
+			// GoReleaser records paths relative to the project root, which
+			// contains dist.
+			if !filepath.IsAbs(p) {
+				if _, err := os.Stat(p); err != nil {
+					p = filepath.Join(dir, e.Name)
+				}
+			}
+			artifacts = append(artifacts, LocalArtifact{Path: p, OS: e.Goos, Arch: e.Goarch})
+		}
+		if len(artifacts) > 0 {
+			return artifacts, nil
+		}
+	}
+	return nil, fmt.Errorf("no archives or binaries found in %s", dir)
+}
+
+// ArtifactType returns the manifest artifact type of the file. Archives
+// (zip, tar.gz, tar.zst) are extracted into a directory by get.
+func ArtifactType(path string) string {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(lower, ".tar.zst"), strings.HasSuffix(lower, ".tzst"):
+		return "tar.zst"
+	}
+	switch filepath.Ext(lower) {
+	case ".jar":
+		return "jar"
+	case ".zip":
+		return "zip"
+	case ".msi":
+		return "msi"
+	case ".exe":
+		return "exe"
+	}
+	return "binary"
+}
+
+// CheckPlatforms returns an error if two artifacts target the same platform.
+func CheckPlatforms(artifacts []LocalArtifact) error {
+	seen := make(map[string]string)
+	for _, a := range artifacts {
+		key := a.OS + "/" + a.Arch
+		if prev, ok := seen[key]; ok {
+			return fmt.Errorf("both %s and %s are for %s", prev, a.Path, key)
+		}
+		seen[key] = a.Path
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package publish
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func touch(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseArtifactSpec(t *testing.T) {
+	dir := t.TempDir()
+	touch(t, filepath.Join(dir, "app_1.0.0_linux_amd64.tar.gz"))
+	touch(t, filepath.Join(dir, "app_1.0.0_darwin_arm64.tar.gz"))
+	touch(t, filepath.Join(dir, "app.jar"))
+
+	tests := []struct {
+		spec     string
+		wantPath string
+		wantOS   string
+		wantArch string
+	}{
+		{"build/app.exe:windows/amd64", "build/app.exe", "windows", "amd64"},
+		{`C:\build\app.exe:windows/386`, `C:\build\app.exe`, "windows", "386"},
+		{"dist/app_linux_arm64", "dist/app_linux_arm64", "linux", "arm64"},
+		{filepath.Join(dir, "app.jar"), filepath.Join(dir, "app.jar"), "any", "any"},
+	}
+	for _, tt := range tests {
+		got, err := ParseArtifactSpec(tt.spec)
+		if err != nil {
+			t.Errorf("ParseArtifactSpec(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		if len(got) != 1 || got[0].Path != tt.wantPath || got[0].OS != tt.wantOS || got[0].Arch != tt.wantArch {
+			t.Errorf("ParseArtifactSpec(%q): expected %s %s/%s, got %+v", tt.spec, tt.wantPath, tt.wantOS, tt.wantArch, got)
+		}
+	}
+
+	got, err := ParseArtifactSpec(filepath.Join(dir, "*.tar.gz"))
+	if err != nil {
+		t.Fatalf("Glob failed: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 artifacts from glob, got %d", len(got))
+	}
+	if err := CheckPlatforms(got); err != nil {
+		t.Errorf("Expected distinct platforms, got %v", err)
+	}
+
+	if _, err := ParseArtifactSpec("build/app"); err == nil {
+		t.Error("Expected error for artifact without platform")
+	}
+	if _, err := ParseArtifactSpec(filepath.Join(dir, "*.tar.gz") + ":linux/amd64"); err == nil {
+		t.Error("Expected error for glob matching several files with a single platform")
+	}
+	if _, err := ParseArtifactSpec(filepath.Join(dir, "*.zip")); err == nil {
+		t.Error("Expected error for glob matching nothing")
+	}
+}
+
+func TestDistArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	dist := filepath.Join(dir, "dist")
+	touch(t, filepath.Join(dist, "app_1.0.0_linux_amd64.tar.gz"))
+	touch(t, filepath.Join(dist, "app_1.0.0_windows_amd64.zip"))
+	artifactsJSON := `[
+  {"name": "app", "path": "dist/app_linux_amd64_v1/app", "goos": "linux", "goarch": "amd64", "type": "Binary"},
+  {"name": "app_1.0.0_linux_amd64.tar.gz", "path": "dist/app_1.0.0_linux_amd64.tar.gz", "goos": "linux", "goarch": "amd64", "type": "Archive"},
+  {"name": "app_1.0.0_windows_amd64.zip", "path": "dist/app_1.0.0_windows_amd64.zip", "goos": "windows", "goarch": "amd64", "type": "Archive"},
+  {"name": "checksums.txt", "path": "dist/checksums.txt", "type": "Checksum"}
+]`
+	if err := os.WriteFile(filepath.Join(dist, "artifacts.json"), []byte(artifactsJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DistArtifacts(dist)
+	if err != nil {
+		t.Fatalf("DistArtifacts failed: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 archives, got %+v", got)
+	}
+	want := LocalArtifact{Path: filepath.Join(dist, "app_1.0.0_windows_amd64.zip"), OS: "windows", Arch: "amd64"}
+	if got[1] != want {
+		t.Errorf("Expected %+v, got %+v", want, got[1])
+	}
+}
+
+func TestCheckPlatforms(t *testing.T) {
+	err := CheckPlatforms([]LocalArtifact{
+		{Path: "a", OS: "linux", Arch: "amd64"},
+		{Path: "b", OS: "linux", Arch: "amd64"},
+	})
+	if err == nil {
+		t.Error("Expected error for duplicate platform")
+	}
+}
+
+func TestArtifactType(t *testing.T) {
+	tests := map[string]string{
+		"app.jar":                       "jar",
+		"dist/app_1.0.0_windows.ZIP":    "zip",
+		"app_1.0.0_linux_amd64.tar.gz":  "tar.gz",
+		"app.tgz":                       "tar.gz",
+		"app_1.0.0_linux_arm64.tar.zst": "tar.zst",
+		"app.exe":                       "exe",
+		"app":                           "binary",
+		"app_1.0.0_linux_amd64.gz":      "binary",
+	}
+	for path, want := range tests {
+		if got := ArtifactType(path); got != want {
+			t.Errorf("Expected type %s for %s, got %s", want, path, got)
+		}
+	}
+}
+
+func TestArtifactPath(t *testing.T) {
+	r := &Release{AppID: "app1", Version: "1.0.0"}
+	tests := map[string]string{
+		"dist/app.tar.gz":  "apps/app1/releases/v1.0.0/linux/amd64/app1_1.0.0_linux_amd64.tar.gz",
+		"dist/app.TGZ":     "apps/app1/releases/v1.0.0/linux/amd64/app1_1.0.0_linux_amd64.tar.gz",
+		"dist/app.tar.zst": "apps/app1/releases/v1.0.0/linux/amd64/app1_1.0.0_linux_amd64.tar.zst",
+		"dist/app.tzst":    "apps/app1/releases/v1.0.0/linux/amd64/app1_1.0.0_linux_amd64.tar.zst",
+		"dist/app.zip":     "apps/app1/releases/v1.0.0/linux/amd64/app1_1.0.0_linux_amd64.zip",
+		"dist/app":         "apps/app1/releases/v1.0.0/linux/amd64/app1_1.0.0_linux_amd64",
+		"dist/app.gz":      "apps/app1/releases/v1.0.0/linux/amd64/app1_1.0.0_linux_amd64.gz",
+	}
+	for path, want := range tests {
+		if got := r.ArtifactPath(LocalArtifact{Path: path, OS: "linux", Arch: "amd64"}); got != want {
+			t.Errorf("Expected %s for %s, got %s", want, path, got)
+		}
+	}
+}
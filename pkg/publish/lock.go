@@ -0,0 +1,165 @@
+package publish
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+)
+
+// DefaultLease is how long a lock stays valid; a writer that crashed while
+// holding it blocks others at most this long.
+const DefaultLease = 30 * time.Second
+
+var (
+	lockPollInterval = 500 * time.Millisecond
+	// lockSettleDelay is waited after writing the lock before reading it back,
+	// so that a competing write made at about the same time usually lands
+	// first. It narrows the race, it does not close it.
+	lockSettleDelay = time.Second
+	lockWaitTimeout = 2 * time.Minute
+)
+
+type lockInfo struct {
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Lock is a lock object stored in the repository. It only relies on plain
+// reads and writes, so it works on any backend, but it is best effort: the
+// owner confirms ownership by reading the lock back after a settle delay,
+// which makes overlapping holders unlikely rather than impossible.
+type Lock struct {
+	b         backend.Backend
+	path      string
+	owner     string
+	expiresAt time.Time
+}
+
+// AcquireLock waits until the lock at path is free or its lease has expired,
+// then takes it for lease. The backend offers no conditional create, so two
+// writers whose write and read-back overlap can both believe they hold it;
+// callers get mutual exclusion only on a best-effort basis.
+func AcquireLock(ctx context.Context, b backend.Backend, path string, lease time.Duration) (*Lock, error) {
+	owner, err := newOwner()
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(lockWaitTimeout)
+	waitingFor := ""
+	for {
+		held, err := readLock(ctx, b, path)
+		if err != nil {
+			return nil, err
+		}
+		if held != nil && time.Now().Before(held.ExpiresAt) {
+			if time.Now().After(deadline) {
+				return nil, fmt.Errorf("timed out waiting for lock %s held by %s", path, held.Owner)
+			}
+			if held.Owner != waitingFor {
+				logger.Infof("Waiting for lock %s held by %s", path, held.Owner)
+				waitingFor = held.Owner
+			}
+			if err := wait(ctx, lockPollInterval); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if held != nil {
+			logger.Warnf("Taking over expired lock %s of %s", path, held.Owner)
+		}
+
+		info := lockInfo{Owner: owner, ExpiresAt: time.Now().Add(lease).UTC()}
+		data, err := json.Marshal(info)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.Put(ctx, path, open(data), "application/json"); err != nil {
+			return nil, fmt.Errorf("failed to write lock %s: %v", path, err)
+		}
+		if err := wait(ctx, lockSettleDelay); err != nil {
+			return nil, err
+		}
+		held, err = readLock(ctx, b, path)
+		if err != nil {
+			return nil, err
+		}
+		if held != nil && held.Owner == owner {
+			logger.Debugf("Acquired lock %s", path)
+			return &Lock{b: b, path: path, owner: owner, expiresAt: info.ExpiresAt}, nil
+		}
+		logger.Infof("Lost the race for lock %s, retrying", path)
+	}
+}
+
+// Check returns an error if the lock is no longer held by this owner or its
+// lease has run out.
+func (l *Lock) Check(ctx context.Context) error {
+	held, err := readLock(ctx, l.b, l.path)
+	if err != nil {
+		return err
+	}
+	if held == nil || held.Owner != l.owner {
+		return fmt.Errorf("lock %s was taken over by another writer", l.path)
+	}
+	if time.Now().After(l.expiresAt) {
+		return fmt.Errorf("lease of lock %s expired", l.path)
+	}
+	return nil
+}
+
+// Release removes the lock if it is still held by this owner.
+func (l *Lock) Release(ctx context.Context) error {
+	held, err := readLock(ctx, l.b, l.path)
+	if err != nil || held == nil || held.Owner != l.owner {
+		return err
+	}
+	return l.b.Delete(ctx, l.path)
+}
+
+// readLock returns the current lock, or nil if there is none. An unreadable
+// lock is treated as expired.
+func readLock(ctx context.Context, b backend.Backend, path string) (*lockInfo, error) {
+	exists, err := b.Exists(ctx, path)
+	if err != nil || !exists {
+		return nil, err
+	}
+	rc, err := b.Get(ctx, path)
+	if err != nil {
+		// Released in between?
+		if exists, existsErr := b.Exists(ctx, path); existsErr == nil && !exists {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rc.Close()
+	var info lockInfo
+	if err := json.NewDecoder(rc).Decode(&info); err != nil {
+		logger.Warnf("Ignoring unreadable lock %s: %v", path, err)
+		return &lockInfo{Owner: "unknown"}, nil
+	}
+	return &info, nil
+}
+
+func newOwner() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), hex.EncodeToString(buf)), nil
+}
+
+func wait(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
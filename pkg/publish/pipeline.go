@@ -0,0 +1,367 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+// Stage is a step of the publish pipeline. Stages run in order and a failing
+// stage stops the pipeline, so the channel manifest, which clients follow,
+// only moves once everything it refers to is in place.
+type Stage string
+
+const (
+	// StageUpload uploads the artifacts and their .sha256 files.
+	StageUpload Stage = "upload"
+	// StageVerify downloads the uploaded objects again and checks their
+	// hashes (Release.VerifyUpload only).
+	StageVerify Stage = "verify"
+	// StageSign merges the artifacts into the signed version manifest.
+	StageSign Stage = "sign"
+	// StageIndex records the release in the application's release index.
+	StageIndex Stage = "index"
+	// StageChannel points the channel manifest at the version manifest.
+	StageChannel Stage = "channel"
+)
+
+// StageError reports the stage at which publishing failed.
+type StageError struct {
+	Stage Stage
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("%s stage failed: %v", e.Stage, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// Release describes a release to publish.
+type Release struct {
+	RepoID   string
+	RepoName string
+	AppID    string
+	AppName  string
+	Version  string
+	Channel  string
+
+	Artifacts []LocalArtifact
+
+	// Seed is the base64 Ed25519 signing seed, KeyID its key identifier.
+	Seed  string
+	KeyID string
+	// TTL sets the expiry of the channel manifest; zero means none.
+	TTL time.Duration
+	// Force allows replacing artifacts already published for the version and
+	// re-signing its manifests when that drops cosignatures.
+	Force bool
+	// VerifyUpload enables StageVerify.
+	VerifyUpload bool
+}
+
+// VersionManifestPath returns the path of the version manifest of r.
+func (r *Release) VersionManifestPath() string {
+	return fmt.Sprintf("apps/%s/releases/v%s/artifacts.json", r.AppID, r.Version)
+}
+
+// ChannelManifestPath returns the path of the channel manifest of r.
+func (r *Release) ChannelManifestPath() string {
+	return fmt.Sprintf("apps/%s/channels/%s.json", r.AppID, r.Channel)
+}
+
+// ArtifactPath returns the repository path of the artifact of a platform.
+// The extension follows the artifact type, so .tgz becomes .tar.gz; a plain
+// binary keeps the extension of the local file, if any.
+func (r *Release) ArtifactPath(a LocalArtifact) string {
+	ext := "." + ArtifactType(a.Path)
+	if ext == ".binary" {
+		ext = strings.ToLower(filepath.Ext(a.Path))
+	}
+	return fmt.Sprintf("apps/%s/releases/v%s/%s/%s/%s_%s_%s_%s%s", r.AppID, r.Version, a.OS, a.Arch, r.AppID, r.Version, a.OS, a.Arch, ext)
+}
+
+// Publish runs the publish pipeline for r and returns the channel manifest it
+// wrote. Pushing the same content again is not a conflict, so a push that
+// failed at a late stage can simply be repeated.
+func Publish(ctx context.Context, b backend.Backend, r *Release) (*manifest.Manifest, error) {
+	artifacts, err := describe(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkConflicts(ctx, b, r, artifacts); err != nil {
+		return nil, err
+	}
+
+	if err := upload(ctx, b, r, artifacts); err != nil {
+		return nil, &StageError{Stage: StageUpload, Err: err}
+	}
+	if r.VerifyUpload {
+		if err := verifyUploads(ctx, b, artifacts); err != nil {
+			return nil, &StageError{Stage: StageVerify, Err: err}
+		}
+	}
+	versionManifest, err := signVersion(ctx, b, r, artifacts)
+	if err != nil {
+		return nil, &StageError{Stage: StageSign, Err: err}
+	}
+	if err := recordRelease(ctx, b, versionManifest, r.Channel, r.Seed, r.KeyID); err != nil {
+		return nil, &StageError{Stage: StageIndex, Err: err}
+	}
+	m, err := updateChannel(ctx, b, r)
+	if err != nil {
+		return nil, &StageError{Stage: StageChannel, Err: err}
+	}
+	return m, nil
+}
+
+// describe computes the manifest entries of the local artifacts.
+func describe(r *Release) ([]manifest.Artifact, error) {
+	var artifacts []manifest.Artifact
+	for _, local := range r.Artifacts {
+		logger.Debugf("Calculating metadata for artifact: %s", local.Path)
+		sha, err := sign.FileSHA256(local.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to calculate SHA256 of %s: %v", local.Path, err)
+		}
+		fi, err := os.Stat(local.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %v", local.Path, err)
+		}
+		artifacts = append(artifacts, manifest.Artifact{
+			OS:     local.OS,
+			Arch:   local.Arch,
+			Type:   ArtifactType(local.Path),
+			URL:    r.ArtifactPath(local),
+			Size:   fi.Size(),
+			Sha256: sha,
+		})
+	}
+	return artifacts, nil
+}
+
+// checkConflicts refuses to republish a yanked version and to replace a
+// published artifact with different content unless r.Force is set.
+func checkConflicts(ctx context.Context, b backend.Backend, r *Release, artifacts []manifest.Artifact) error {
+	index, err := getDocument[manifest.Index](ctx, b, manifest.IndexPath(r.AppID))
+	if err != nil {
+		return err
+	}
+	if index.Yanked(r.Version) != nil {
+		return fmt.Errorf("version %s was yanked; publish the fix under a new version", r.Version)
+	}
+
+	current, err := getManifest(ctx, b, r.VersionManifestPath())
+	if err != nil || current == nil {
+		return err
+	}
+	for _, a := range artifacts {
+		if err := checkReplace(current.Payload.Latest.Artifacts, a, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkReplace(existing []manifest.Artifact, a manifest.Artifact, r *Release) error {
+	for _, e := range existing {
+		if e.OS != a.OS || e.Arch != a.Arch || e.Sha256 == a.Sha256 {
+			continue
+		}
+		if !r.Force {
+			return fmt.Errorf("artifact for %s/%s in version v%s already exists. Use --force to overwrite", a.OS, a.Arch, r.Version)
+		}
+		logger.Warnf("Overwriting existing artifact for %s/%s", a.OS, a.Arch)
+	}
+	return nil
+}
+
+func upload(ctx context.Context, b backend.Backend, r *Release, artifacts []manifest.Artifact) error {
+	for i, a := range artifacts {
+		path := r.Artifacts[i].Path
+		logger.Infof("Uploading artifact to %s", a.URL)
+		openArtifact := func() (io.ReadCloser, error) {
+			return os.Open(path)
+		}
+		if err := b.Put(ctx, a.URL, openArtifact, "application/octet-stream"); err != nil {
+			return fmt.Errorf("failed to upload %s: %v", a.URL, err)
+		}
+		logger.Debugf("Uploading %s.sha256", a.URL)
+		if err := b.Put(ctx, a.URL+".sha256", open([]byte(a.Sha256)), "text/plain"); err != nil {
+			return fmt.Errorf("failed to upload %s.sha256: %v", a.URL, err)
+		}
+	}
+	return nil
+}
+
+// verifyUploads downloads every uploaded artifact and .sha256 file and
+// compares them with the local hash.
+func verifyUploads(ctx context.Context, b backend.Backend, artifacts []manifest.Artifact) error {
+	for _, a := range artifacts {
+		logger.Infof("Verifying upload of %s", a.URL)
+		rc, err := b.Get(ctx, a.URL)
+		if err != nil {
+			return fmt.Errorf("failed to download %s: %v", a.URL, err)
+		}
+		h := sign.NewHasher()
+		n, err := io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("failed to download %s: %v", a.URL, err)
+		}
+		if got := h.Sum(); got != a.Sha256 || n != a.Size {
+			return fmt.Errorf("%s does not match the local artifact: expected %s (%d bytes), got %s (%d bytes)", a.URL, a.Sha256, a.Size, got, n)
+		}
+
+		rc, err = b.Get(ctx, a.URL+".sha256")
+		if err != nil {
+			return fmt.Errorf("failed to download %s.sha256: %v", a.URL, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("failed to download %s.sha256: %v", a.URL, err)
+		}
+		if got := strings.TrimSpace(string(data)); got != a.Sha256 {
+			return fmt.Errorf("%s.sha256 contains %q, expected %s", a.URL, got, a.Sha256)
+		}
+	}
+	return nil
+}
+
+// signVersion merges the artifacts into artifacts.json. Jobs pushing other
+// platforms of the same version may write it concurrently; the merge is
+// retried on their result instead of overwriting it.
+func signVersion(ctx context.Context, b backend.Backend, r *Release, artifacts []manifest.Artifact) (*manifest.Manifest, error) {
+	pubKey, err := sign.SeedToPubKey(r.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive public key: %v", err)
+	}
+	logger.Infof("Signing and uploading manifest")
+	m, err := UpdateManifest(ctx, b, r.VersionManifestPath(), func(current *manifest.Manifest) (*manifest.Manifest, error) {
+		var merged []manifest.Artifact
+		if current != nil {
+			if err := checkCosignatures(current, pubKey, r.Version, r.Channel, r.Force); err != nil {
+				return nil, err
+			}
+			merged = current.Payload.Latest.Artifacts
+		}
+		for _, a := range artifacts {
+			if err := checkReplace(merged, a, r); err != nil {
+				return nil, fmt.Errorf("%v (pushed concurrently)", err)
+			}
+			merged, _ = MergeArtifact(merged, a)
+		}
+		now := time.Now().UTC()
+		return manifest.SignManifest(manifest.Payload{
+			SchemaVersion: 1,
+			Repo:          manifest.RepoInfo{ID: r.RepoID, Name: r.RepoName},
+			App:           manifest.AppInfo{ID: r.AppID, Name: r.AppName},
+			Channel:       r.Channel,
+			GeneratedAt:   now,
+			Latest: manifest.Release{
+				Version:     r.Version,
+				ReleaseDate: now,
+				Artifacts:   merged,
+			},
+		}, r.Seed, r.KeyID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("Version manifest lists %d artifact(s)", len(m.Payload.Latest.Artifacts))
+	return m, nil
+}
+
+// recordRelease adds the release, as signed in m, to the release index and
+// marks it as published to channel.
+func recordRelease(ctx context.Context, b backend.Backend, m *manifest.Manifest, channel, seed, keyID string) error {
+	path := manifest.IndexPath(m.Payload.App.ID)
+	logger.Infof("Updating release index: %s", path)
+	_, err := UpdateIndex(ctx, b, path, func(current *manifest.Index) (*manifest.Index, error) {
+		payload := manifest.IndexPayload{
+			SchemaVersion: 1,
+			Repo:          m.Payload.Repo,
+			App:           m.Payload.App,
+		}
+		if current != nil {
+			payload.Releases = current.Payload.Releases
+		}
+		payload.AddRelease(m)
+		payload.Find(m.Payload.Latest.Version).AddChannel(channel)
+		payload.GeneratedAt = time.Now().UTC()
+		return manifest.SignIndex(payload, seed, keyID)
+	})
+	return err
+}
+
+// updateChannel copies the latest version manifest, which may include
+// artifacts of concurrent pushes, to the channel. Only channel manifests
+// expire; version manifests stay valid for pinned installs.
+func updateChannel(ctx context.Context, b backend.Backend, r *Release) (*manifest.Manifest, error) {
+	pubKey, err := sign.SeedToPubKey(r.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive public key: %v", err)
+	}
+	logger.Infof("Updating channel manifest: %s", r.ChannelManifestPath())
+	return UpdateManifest(ctx, b, r.ChannelManifestPath(), func(*manifest.Manifest) (*manifest.Manifest, error) {
+		latest, err := getManifest(ctx, b, r.VersionManifestPath())
+		if err != nil {
+			return nil, err
+		}
+		if latest == nil {
+			return nil, fmt.Errorf("version manifest %s disappeared", r.VersionManifestPath())
+		}
+		if err := latest.VerifySignature(pubKey); err != nil {
+			return nil, fmt.Errorf("version manifest %s is not signed by this key: %v", r.VersionManifestPath(), err)
+		}
+		if r.TTL == 0 && latest.Payload.Channel == r.Channel {
+			return latest, nil
+		}
+		if err := checkCosignatures(latest, pubKey, r.Version, r.Channel, r.Force); err != nil {
+			return nil, err
+		}
+		payload := latest.Payload
+		payload.Channel = r.Channel
+		if r.TTL > 0 {
+			payload.ExpiresAt = payload.GeneratedAt.Add(r.TTL)
+			logger.Debugf("Channel manifest expires at %s", payload.ExpiresAt.Format(time.RFC3339))
+		}
+		return manifest.SignManifest(payload, r.Seed, r.KeyID)
+	})
+}
+
+// getManifest returns the manifest at path, or nil if there is none.
+func getManifest(ctx context.Context, b backend.Backend, path string) (*manifest.Manifest, error) {
+	return getDocument[manifest.Manifest](ctx, b, path)
+}
+
+// getDocument returns the document at path, or nil if there is none.
+func getDocument[T any](ctx context.Context, b backend.Backend, path string) (*T, error) {
+	exists, err := b.Exists(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check %s: %v", path, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	rc, err := b.Get(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %v", path, err)
+	}
+	v, err := decode[T](rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", path, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,200 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+// faultyBackend fails writes to paths ending in failPut and flips the content
+// of uploads ending in corrupt.
+type faultyBackend struct {
+	backend.Backend
+	failPut string
+	corrupt string
+}
+
+func (f *faultyBackend) Put(ctx context.Context, path string, openBody func() (io.ReadCloser, error), contentType string) error {
+	if f.failPut != "" && strings.HasSuffix(path, f.failPut) {
+		return errors.New("injected failure")
+	}
+	if f.corrupt != "" && strings.HasSuffix(path, f.corrupt) {
+		rc, err := openBody()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		data[0] ^= 0xff
+		return f.Backend.Put(ctx, path, open(data), contentType)
+	}
+	return f.Backend.Put(ctx, path, openBody, contentType)
+}
+
+func testRelease(t *testing.T, version string) *Release {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "app_linux_amd64")
+	if err := os.WriteFile(path, []byte("binary "+version), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &Release{
+		RepoID:       "repo1",
+		AppID:        "app1",
+		Version:      version,
+		Channel:      "stable",
+		Artifacts:    []LocalArtifact{{Path: path, OS: "linux", Arch: "amd64"}},
+		Seed:         testSeed,
+		KeyID:        "k",
+		VerifyUpload: true,
+	}
+}
+
+func TestPublish(t *testing.T) {
+	ctx := context.Background()
+	b := backend.NewFileBackend(t.TempDir())
+
+	r := testRelease(t, "1.0.0")
+	m, err := Publish(ctx, b, r)
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if m.Payload.Latest.Version != "1.0.0" || len(m.Payload.Latest.Artifacts) != 1 {
+		t.Errorf("Unexpected channel manifest: %+v", m.Payload.Latest)
+	}
+
+	// Same content again is not a conflict, so failed pushes can be repeated.
+	if _, err := Publish(ctx, b, r); err != nil {
+		t.Errorf("Expected repeated push to succeed, got %v", err)
+	}
+
+	if err := os.WriteFile(r.Artifacts[0].Path, []byte("changed"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Publish(ctx, b, r); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected conflict for changed artifact, got %v", err)
+	}
+	r.Force = true
+	if _, err := Publish(ctx, b, r); err != nil {
+		t.Errorf("Expected forced push to succeed, got %v", err)
+	}
+
+	beta := testRelease(t, "1.1.0")
+	beta.Channel = "beta"
+	if _, err := Publish(ctx, b, beta); err != nil {
+		t.Fatalf("Publish of 1.1.0 failed: %v", err)
+	}
+	rc, err := b.Get(ctx, manifest.IndexPath("app1"))
+	if err != nil {
+		t.Fatalf("Expected release index, got %v", err)
+	}
+	index, err := decode[manifest.Index](rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, _ := sign.SeedToPubKey(testSeed)
+	if err := index.Verify(pubKey); err != nil {
+		t.Errorf("Expected signed index, got %v", err)
+	}
+	releases := index.Payload.Releases
+	if len(releases) != 2 || releases[0].Version != "1.1.0" || releases[1].Version != "1.0.0" {
+		t.Fatalf("Expected releases 1.1.0 and 1.0.0, got %+v", releases)
+	}
+	if releases[0].Channels[0] != "beta" || releases[1].Channels[0] != "stable" {
+		t.Errorf("Unexpected channels: %v, %v", releases[0].Channels, releases[1].Channels)
+	}
+	if releases[1].Artifacts[0].Sha256 != sign.SHA256([]byte("changed")) {
+		t.Errorf("Expected index to list the forced artifact, got %+v", releases[1].Artifacts)
+	}
+}
+
+func TestPublishStageFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		fault  faultyBackend
+		stage  Stage
+		signed bool // whether artifacts.json of the failed release is written
+	}{
+		{"artifact upload", faultyBackend{failPut: "_amd64"}, StageUpload, false},
+		{"sha256 upload", faultyBackend{failPut: ".sha256"}, StageUpload, false},
+		{"corrupted upload", faultyBackend{corrupt: "_amd64"}, StageVerify, false},
+		{"corrupted sha256", faultyBackend{corrupt: ".sha256"}, StageVerify, false},
+		{"version manifest", faultyBackend{failPut: "artifacts.json"}, StageSign, false},
+		{"release index", faultyBackend{failPut: "index.json"}, StageIndex, true},
+		{"channel manifest", faultyBackend{failPut: "stable.json"}, StageChannel, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fb := backend.NewFileBackend(t.TempDir())
+			if _, err := Publish(ctx, fb, testRelease(t, "1.0.0")); err != nil {
+				t.Fatalf("Publish of 1.0.0 failed: %v", err)
+			}
+
+			b := tt.fault
+			b.Backend = plainBackend{fb}
+			r := testRelease(t, "1.1.0")
+			_, err := Publish(ctx, &b, r)
+			var stageErr *StageError
+			if !errors.As(err, &stageErr) || stageErr.Stage != tt.stage {
+				t.Fatalf("Expected failure at %s stage, got %v", tt.stage, err)
+			}
+
+			channel, err := getManifest(ctx, fb, r.ChannelManifestPath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := channel.Payload.Latest.Version; got != "1.0.0" {
+				t.Errorf("Expected channel to stay at 1.0.0, got %s", got)
+			}
+			exists, err := fb.Exists(ctx, r.VersionManifestPath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists != tt.signed {
+				t.Errorf("Expected version manifest written: %v, got %v", tt.signed, exists)
+			}
+
+			// Repeating the push on a healthy backend completes the release.
+			if _, err := Publish(ctx, fb, r); err != nil {
+				t.Fatalf("Expected retry to succeed, got %v", err)
+			}
+			channel, err = getManifest(ctx, fb, r.ChannelManifestPath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := channel.Payload.Latest.Version; got != "1.1.0" {
+				t.Errorf("Expected channel at 1.1.0 after retry, got %s", got)
+			}
+		})
+	}
+}
+
+func TestPublishWithoutVerifyUploadSkipsVerify(t *testing.T) {
+	ctx := context.Background()
+	fb := backend.NewFileBackend(t.TempDir())
+	r := testRelease(t, "1.0.0")
+	r.VerifyUpload = false
+	if _, err := Publish(ctx, &faultyBackend{Backend: fb, corrupt: "_amd64"}, r); err != nil {
+		t.Fatalf("Expected publish without verification to succeed, got %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(fb.Root, "apps/app1/releases/v1.0.0/linux/amd64/app1_1.0.0_linux_amd64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(data, []byte("binary 1.0.0")) {
+		t.Error("Expected the stored artifact to be corrupted")
+	}
+}
@@ -0,0 +1,139 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/semver"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+// Promotion moves an already published release from one channel to another.
+type Promotion struct {
+	RepoID  string
+	AppID   string
+	Version string
+	From    string
+	To      string
+
+	Seed  string
+	KeyID string
+	TTL   time.Duration
+	// Force skips the checks that the release was published to From, was not
+	// yanked and that To does not carry a newer version, and promotes a
+	// cosigned release although the cosignatures are dropped.
+	Force bool
+}
+
+// Promote points channel p.To at the signed version manifest of p.Version,
+// re-signed with the new channel. No artifact is uploaded or changed, so
+// exactly the build that was tested in p.From is promoted. It returns the
+// channel manifest that was written.
+func Promote(ctx context.Context, b backend.Backend, p *Promotion) (*manifest.Manifest, error) {
+	if p.From == p.To {
+		return nil, fmt.Errorf("source and target channel are both %q", p.To)
+	}
+	pubKey, err := sign.SeedToPubKey(p.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive public key: %v", err)
+	}
+	r := &Release{RepoID: p.RepoID, AppID: p.AppID, Version: p.Version, Channel: p.To}
+
+	vm, err := getVerifiedRelease(ctx, b, r, pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Force {
+		if err := checkPromotion(ctx, b, p, vm, pubKey); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkCosignatures(vm, pubKey, p.Version, p.To, p.Force); err != nil {
+		return nil, err
+	}
+
+	if err := recordRelease(ctx, b, vm, p.To, p.Seed, p.KeyID); err != nil {
+		return nil, &StageError{Stage: StageIndex, Err: err}
+	}
+
+	logger.Infof("Updating channel manifest: %s", r.ChannelManifestPath())
+	m, err := UpdateManifest(ctx, b, r.ChannelManifestPath(), func(*manifest.Manifest) (*manifest.Manifest, error) {
+		vm, err := getVerifiedRelease(ctx, b, r, pubKey)
+		if err != nil {
+			return nil, err
+		}
+		return channelManifest(vm, p.To, p.TTL, p.Seed, p.KeyID)
+	})
+	if err != nil {
+		return nil, &StageError{Stage: StageChannel, Err: err}
+	}
+	return m, nil
+}
+
+// checkPromotion verifies that the release was published to p.From, was not
+// yanked and that p.To is not already at a newer version, which clients would
+// refuse to downgrade from. The release index must be signed by pubKey.
+func checkPromotion(ctx context.Context, b backend.Backend, p *Promotion, vm *manifest.Manifest, pubKey []byte) error {
+	path := manifest.IndexPath(p.AppID)
+	index, err := getDocument[manifest.Index](ctx, b, path)
+	if err != nil {
+		return err
+	}
+	if index != nil {
+		if err := index.Verify(pubKey); err != nil {
+			return fmt.Errorf("release index %s is not signed by this key: %v", path, err)
+		}
+	}
+	if index.Yanked(p.Version) != nil {
+		return fmt.Errorf("version %s was yanked. Use --force to promote it anyway", p.Version)
+	}
+
+	inFrom := vm.Payload.Channel == p.From
+	if !inFrom && index != nil {
+		if e := index.Payload.Find(p.Version); e != nil {
+			inFrom = slices.Contains(e.Channels, p.From)
+		}
+	}
+	if !inFrom {
+		from, err := getManifest(ctx, b, fmt.Sprintf("apps/%s/channels/%s.json", p.AppID, p.From))
+		if err != nil {
+			return err
+		}
+		inFrom = from != nil && from.Payload.Latest.Version == p.Version
+	}
+	if !inFrom {
+		return fmt.Errorf("version %s was never published to channel %s. Use --force to promote it anyway", p.Version, p.From)
+	}
+
+	to, err := getManifest(ctx, b, fmt.Sprintf("apps/%s/channels/%s.json", p.AppID, p.To))
+	if err != nil || to == nil {
+		return err
+	}
+	if c, err := semver.Compare(to.Payload.Latest.Version, p.Version); err == nil && c > 0 {
+		return fmt.Errorf("channel %s is at %s, newer than %s; installed clients would refuse the downgrade. Use --force to promote it anyway", p.To, to.Payload.Latest.Version, p.Version)
+	}
+	return nil
+}
+
+// getVerifiedRelease returns the version manifest of r after checking that it
+// is signed by pubKey and describes r.
+func getVerifiedRelease(ctx context.Context, b backend.Backend, r *Release, pubKey []byte) (*manifest.Manifest, error) {
+	vm, err := getManifest(ctx, b, r.VersionManifestPath())
+	if err != nil {
+		return nil, err
+	}
+	if vm == nil {
+		return nil, fmt.Errorf("version %s of %s is not published", r.Version, r.AppID)
+	}
+	if err := vm.VerifySignature(pubKey); err != nil {
+		return nil, fmt.Errorf("version manifest %s is not signed by this key: %v", r.VersionManifestPath(), err)
+	}
+	if err := vm.Payload.VerifyBinding(r.RepoID, r.AppID, "", r.Version); err != nil {
+		return nil, fmt.Errorf("version manifest %s rejected: %v", r.VersionManifestPath(), err)
+	}
+	return vm, nil
+}
@@ -0,0 +1,147 @@
+package publish
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+func TestPromote(t *testing.T) {
+	ctx := context.Background()
+	b := backend.NewFileBackend(t.TempDir())
+
+	beta := testRelease(t, "1.4.0")
+	beta.Channel = "beta"
+	betaManifest, err := Publish(ctx, b, beta)
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	p := &Promotion{AppID: "app1", Version: "1.4.0", From: "beta", To: "stable", Seed: testSeed, KeyID: "k"}
+	m, err := Promote(ctx, b, p)
+	if err != nil {
+		t.Fatalf("Promote failed: %v", err)
+	}
+	pubKey, _ := sign.SeedToPubKey(testSeed)
+	if err := m.Verify(pubKey); err != nil {
+		t.Errorf("Expected signed channel manifest, got %v", err)
+	}
+	if m.Payload.Channel != "stable" || m.Payload.Latest.Version != "1.4.0" {
+		t.Errorf("Expected stable 1.4.0, got %s %s", m.Payload.Channel, m.Payload.Latest.Version)
+	}
+	if m.Payload.Latest.Artifacts[0] != betaManifest.Payload.Latest.Artifacts[0] {
+		t.Errorf("Expected the beta artifacts, got %+v", m.Payload.Latest.Artifacts)
+	}
+	if !m.Payload.GeneratedAt.After(betaManifest.Payload.GeneratedAt) {
+		t.Error("Expected the promoted manifest to be newer than the beta one")
+	}
+
+	index, err := getDocument[manifest.Index](ctx, b, manifest.IndexPath("app1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := index.Payload.Find("1.4.0").Channels; !slices.Equal(got, []string{"beta", "stable"}) {
+		t.Errorf("Expected index channels [beta stable], got %v", got)
+	}
+
+	// Promoting again, e.g. after a failed run, is fine.
+	if _, err := Promote(ctx, b, p); err != nil {
+		t.Errorf("Expected repeated promotion to succeed, got %v", err)
+	}
+}
+
+func TestPromoteCosignedRelease(t *testing.T) {
+	ctx := context.Background()
+	b := backend.NewFileBackend(t.TempDir())
+	beta := testRelease(t, "1.4.0")
+	beta.Channel = "beta"
+	if _, err := Publish(ctx, b, beta); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	path := beta.VersionManifestPath()
+	if _, err := UpdateManifest(ctx, b, path, func(current *manifest.Manifest) (*manifest.Manifest, error) {
+		return current, current.AddSignature("AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=", "cosigner")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Promotion{AppID: "app1", Version: "1.4.0", From: "beta", To: "stable", Seed: testSeed, KeyID: "k"}
+	if _, err := Promote(ctx, b, p); err == nil || !strings.Contains(err.Error(), "cosigned") {
+		t.Errorf("Expected promotion dropping cosignatures to be refused, got %v", err)
+	}
+	p.Force = true
+	if _, err := Promote(ctx, b, p); err != nil {
+		t.Errorf("Expected forced promotion to succeed, got %v", err)
+	}
+}
+
+func TestPromoteChecks(t *testing.T) {
+	ctx := context.Background()
+	b := backend.NewFileBackend(t.TempDir())
+	for _, r := range []*Release{testRelease(t, "1.4.0"), testRelease(t, "1.5.0")} {
+		if _, err := Publish(ctx, b, r); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+	beta := testRelease(t, "1.6.0")
+	beta.Channel = "beta"
+	if _, err := Publish(ctx, b, beta); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		p       Promotion
+		wantErr string
+	}{
+		{"not in source channel", Promotion{Version: "1.6.0", From: "alpha", To: "stable"}, "never published to channel alpha"},
+		{"downgrade", Promotion{Version: "1.4.0", From: "stable", To: "beta"}, "newer than 1.4.0"},
+		{"unknown version", Promotion{Version: "9.0.0", From: "beta", To: "stable"}, "not published"},
+		{"same channel", Promotion{Version: "1.6.0", From: "beta", To: "beta"}, "both"},
+		{"other key", Promotion{Version: "1.6.0", From: "beta", To: "stable", Seed: "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="}, "not signed by this key"},
+		{"other app", Promotion{AppID: "app2", Version: "1.6.0", From: "beta", To: "stable"}, "not published"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.p
+			if p.AppID == "" {
+				p.AppID = "app1"
+			}
+			if p.Seed == "" {
+				p.Seed = testSeed
+			}
+			_, err := Promote(ctx, b, &p)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	// A planted index could hide a yank or fake the source channel.
+	planted, err := manifest.SignIndex(manifest.IndexPayload{
+		SchemaVersion: 1,
+		Repo:          manifest.RepoInfo{ID: "repo1"},
+		App:           manifest.AppInfo{ID: "app1"},
+		Releases:      []manifest.IndexEntry{{Version: "1.6.0", Channels: []string{"alpha"}}},
+	}, "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=", "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UpdateIndex(ctx, b, manifest.IndexPath("app1"), func(*manifest.Index) (*manifest.Index, error) { return planted, nil }); err != nil {
+		t.Fatal(err)
+	}
+	unsigned := Promotion{AppID: "app1", Version: "1.6.0", From: "alpha", To: "stable", Seed: testSeed}
+	if _, err := Promote(ctx, b, &unsigned); err == nil || !strings.Contains(err.Error(), "release index") {
+		t.Errorf("Expected planted release index to be refused, got %v", err)
+	}
+
+	forced := Promotion{AppID: "app1", Version: "1.6.0", From: "alpha", To: "stable", Seed: testSeed, Force: true}
+	if _, err := Promote(ctx, b, &forced); err != nil {
+		t.Errorf("Expected forced promotion to succeed, got %v", err)
+	}
+}
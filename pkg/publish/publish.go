@@ -0,0 +1,182 @@
+// Package publish updates shared repository documents, such as the manifest
+// of a release pushed from several CI jobs at once, without losing concurrent
+// changes.
+//
+// Backends implementing backend.ConditionalPutter get a compare-and-swap: the
+// manifest is read with its version, updated and written back only if nobody
+// modified it in between; otherwise the update is retried on the fresh
+// content. Other backends (e.g. Nexus raw repositories), and S3-compatible
+// servers found to ignore conditional requests, are serialized with a lock
+// object carrying a lease, see Lock.
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/logging"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+)
+
+var logger = logging.Component("pkg/publish")
+
+const maxUpdateAttempts = 10
+
+// retryDelay is the base delay before retrying a conflicting update; it grows
+// with each attempt and is jittered so racing writers spread out.
+var retryDelay = 200 * time.Millisecond
+
+// UpdateFunc computes the new, signed manifest from the current one, which is
+// nil if none exists yet. It may be called several times and must not keep
+// state between calls.
+type UpdateFunc func(current *manifest.Manifest) (*manifest.Manifest, error)
+
+// UpdateManifest replaces the manifest at path with the result of update,
+// retrying when another writer modified it concurrently. It returns the
+// manifest that was written.
+func UpdateManifest(ctx context.Context, b backend.Backend, path string, update UpdateFunc) (*manifest.Manifest, error) {
+	return updateDocument(ctx, b, path, update)
+}
+
+// UpdateIndex is UpdateManifest for the release index.
+func UpdateIndex(ctx context.Context, b backend.Backend, path string, update func(current *manifest.Index) (*manifest.Index, error)) (*manifest.Index, error) {
+	return updateDocument(ctx, b, path, update)
+}
+
+func updateDocument[T any](ctx context.Context, b backend.Backend, path string, update func(current *T) (*T, error)) (*T, error) {
+	cp, ok := b.(backend.ConditionalPutter)
+	if ok {
+		if p, isProber := b.(backend.ConditionalProber); isProber {
+			honoured, err := p.ConditionalWritesHonoured(ctx)
+			if err != nil {
+				return nil, err
+			}
+			ok = honoured
+		}
+	}
+	if !ok {
+		return updateLocked(ctx, b, path, update)
+	}
+
+	for attempt := 1; ; attempt++ {
+		rc, version, err := cp.GetVersion(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		current, err := decode[T](rc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %v", path, err)
+		}
+		next, err := update(current)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.MarshalIndent(next, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		err = cp.PutIfMatch(ctx, path, open(data), "application/json", version)
+		if err == nil {
+			return next, nil
+		}
+		if !errors.Is(err, backend.ErrPreconditionFailed) {
+			return nil, fmt.Errorf("failed to upload %s: %v", path, err)
+		}
+		if attempt == maxUpdateAttempts {
+			return nil, fmt.Errorf("failed to upload %s: still modified concurrently after %d attempts", path, attempt)
+		}
+		logger.Infof("%s was modified concurrently, merging again (attempt %d/%d)", path, attempt+1, maxUpdateAttempts)
+		if err := backoff(ctx, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func updateLocked[T any](ctx context.Context, b backend.Backend, path string, update func(current *T) (*T, error)) (*T, error) {
+	lock, err := AcquireLock(ctx, b, path+".lock", DefaultLease)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := lock.Release(context.WithoutCancel(ctx)); err != nil {
+			logger.Warnf("Failed to release lock %s.lock, others wait until its lease expires: %v", path, err)
+		}
+	}()
+
+	var current *T
+	exists, err := b.Exists(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		rc, err := b.Get(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		if current, err = decode[T](rc); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %v", path, err)
+		}
+	}
+	next, err := update(current)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(next, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := lock.Check(ctx); err != nil {
+		return nil, err
+	}
+	if err := b.Put(ctx, path, open(data), "application/json"); err != nil {
+		return nil, fmt.Errorf("failed to upload %s: %v", path, err)
+	}
+	return next, nil
+}
+
+// MergeArtifact returns artifacts with a replacing the artifact of the same
+// OS and architecture, or appended. replaced reports whether one existed.
+func MergeArtifact(artifacts []manifest.Artifact, a manifest.Artifact) (merged []manifest.Artifact, replaced bool) {
+	merged = make([]manifest.Artifact, 0, len(artifacts)+1)
+	for _, existing := range artifacts {
+		if existing.OS == a.OS && existing.Arch == a.Arch {
+			merged = append(merged, a)
+			replaced = true
+		} else {
+			merged = append(merged, existing)
+		}
+	}
+	if !replaced {
+		merged = append(merged, a)
+	}
+	return merged, replaced
+}
+
+func decode[T any](rc io.ReadCloser) (*T, error) {
+	if rc == nil {
+		return nil, nil
+	}
+	defer rc.Close()
+	var v T
+	if err := json.NewDecoder(rc).Decode(&v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func open(data []byte) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+func backoff(ctx context.Context, attempt int) error {
+	return wait(ctx, time.Duration(attempt)*retryDelay+rand.N(retryDelay+1))
+}
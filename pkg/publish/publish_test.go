@@ -0,0 +1,174 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+)
+
+const testSeed = "tG8Y/V8NOnR5i/YkO9uH0WlG6G6fR5e7uI9oP9kI9mI="
+
+// plainBackend hides the conditional writes of the wrapped backend, like a
+// Nexus raw repository.
+type plainBackend struct {
+	backend.Backend
+}
+
+// slowBackend delays reads, so racing writers all read the same state before
+// any of them writes.
+type slowBackend struct {
+	*backend.FileBackend
+}
+
+func (s slowBackend) GetVersion(ctx context.Context, path string) (io.ReadCloser, string, error) {
+	time.Sleep(20 * time.Millisecond)
+	return s.FileBackend.GetVersion(ctx, path)
+}
+
+// ignoringBackend accepts conditional writes but ignores their condition,
+// like some S3-compatible servers, and says so when probed. Writes are
+// delayed, so racing writers would all overwrite the same state.
+type ignoringBackend struct {
+	*backend.FileBackend
+}
+
+func (i ignoringBackend) PutIfMatch(ctx context.Context, path string, openBody func() (io.ReadCloser, error), contentType, version string) error {
+	time.Sleep(20 * time.Millisecond)
+	return i.Put(ctx, path, openBody, contentType)
+}
+
+func (i ignoringBackend) ConditionalWritesHonoured(ctx context.Context) (bool, error) {
+	return false, nil
+}
+
+func init() {
+	retryDelay = 5 * time.Millisecond
+	lockPollInterval = 5 * time.Millisecond
+	lockSettleDelay = 20 * time.Millisecond
+}
+
+// push adds the artifact of one platform the way push does.
+func push(ctx context.Context, b backend.Backend, platform string) error {
+	_, err := UpdateManifest(ctx, b, "apps/app1/releases/v1.0.0/artifacts.json", func(current *manifest.Manifest) (*manifest.Manifest, error) {
+		var artifacts []manifest.Artifact
+		if current != nil {
+			artifacts = current.Payload.Latest.Artifacts
+		}
+		artifacts, _ = MergeArtifact(artifacts, manifest.Artifact{OS: platform, Arch: "amd64", URL: platform})
+		return manifest.SignManifest(manifest.Payload{
+			SchemaVersion: 1,
+			App:           manifest.AppInfo{ID: "app1"},
+			Latest:        manifest.Release{Version: "1.0.0", Artifacts: artifacts},
+		}, testSeed, "k")
+	})
+	return err
+}
+
+func TestRacingPushers(t *testing.T) {
+	tests := []struct {
+		name string
+		open func(root string) backend.Backend
+	}{
+		{"conditional", func(root string) backend.Backend { return slowBackend{backend.NewFileBackend(root)} }},
+		{"lock", func(root string) backend.Backend { return plainBackend{backend.NewFileBackend(root)} }},
+		{"ignored conditions", func(root string) backend.Backend { return ignoringBackend{backend.NewFileBackend(root)} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			b := tt.open(t.TempDir())
+
+			const pushers = 5
+			var wg sync.WaitGroup
+			errs := make(chan error, pushers)
+			for i := 0; i < pushers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs <- push(ctx, b, fmt.Sprintf("os%d", i))
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Fatalf("push failed: %v", err)
+				}
+			}
+
+			rc, err := b.Get(ctx, "apps/app1/releases/v1.0.0/artifacts.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := decode[manifest.Manifest](rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Payload.Latest.Artifacts) != pushers {
+				t.Errorf("Expected %d artifacts, got %+v", pushers, m.Payload.Latest.Artifacts)
+			}
+			if exists, _ := b.Exists(ctx, "apps/app1/releases/v1.0.0/artifacts.json.lock"); exists {
+				t.Error("Expected lock to be released")
+			}
+		})
+	}
+}
+
+func TestUpdateManifestStopsOnUpdateError(t *testing.T) {
+	b := backend.NewFileBackend(t.TempDir())
+	_, err := UpdateManifest(context.Background(), b, "m.json", func(current *manifest.Manifest) (*manifest.Manifest, error) {
+		return nil, fmt.Errorf("conflict")
+	})
+	if err == nil || err.Error() != "conflict" {
+		t.Errorf("Expected update error, got %v", err)
+	}
+}
+
+func TestAcquireLockTakesOverExpiredLease(t *testing.T) {
+	ctx := context.Background()
+	b := backend.NewFileBackend(t.TempDir())
+
+	stale, err := AcquireLock(ctx, b, "x.lock", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := stale.Check(ctx); err == nil {
+		t.Error("Expected expired lease to fail the check")
+	}
+
+	l, err := AcquireLock(ctx, b, "x.lock", DefaultLease)
+	if err != nil {
+		t.Fatalf("Expected expired lock to be taken over, got %v", err)
+	}
+	if err := stale.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Check(ctx); err != nil {
+		t.Errorf("Expected release by a former owner to leave the lock alone, got %v", err)
+	}
+	if err := l.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := b.Exists(ctx, "x.lock"); exists {
+		t.Error("Expected lock to be released")
+	}
+}
+
+func TestMergeArtifact(t *testing.T) {
+	artifacts := []manifest.Artifact{{OS: "linux", Arch: "amd64", URL: "a"}, {OS: "windows", Arch: "amd64", URL: "b"}}
+	merged, replaced := MergeArtifact(artifacts, manifest.Artifact{OS: "linux", Arch: "amd64", URL: "c"})
+	if !replaced || len(merged) != 2 || merged[0].URL != "c" {
+		t.Errorf("Expected linux artifact to be replaced, got %+v", merged)
+	}
+	merged, replaced = MergeArtifact(artifacts, manifest.Artifact{OS: "linux", Arch: "arm64"})
+	if replaced || len(merged) != 3 {
+		t.Errorf("Expected artifact to be appended, got %+v", merged)
+	}
+}
@@ -0,0 +1,188 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/semver"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+// Yanking withdraws a published release.
+type Yanking struct {
+	RepoID  string
+	AppID   string
+	Version string
+	Reason  string
+
+	Seed  string
+	KeyID string
+	TTL   time.Duration
+	// Force rolls channels back to a cosigned release even though the
+	// cosignatures are dropped.
+	Force bool
+}
+
+// ChannelRollback reports what a channel serving the yanked release was
+// rolled back to. An empty Version means the channel had no other good
+// release and still serves the yanked one.
+type ChannelRollback struct {
+	Channel string
+	Version string
+}
+
+// Yank marks the release as yanked in the release index and rolls every
+// channel serving it back to the newest release of that channel that was not
+// yanked. Artifacts and version manifests are kept, so clients can still
+// verify what they run.
+func Yank(ctx context.Context, b backend.Backend, y *Yanking) ([]ChannelRollback, error) {
+	pubKey, err := sign.SeedToPubKey(y.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive public key: %v", err)
+	}
+	vm, err := getVerifiedRelease(ctx, b, &Release{RepoID: y.RepoID, AppID: y.AppID, Version: y.Version}, pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	path := manifest.IndexPath(y.AppID)
+	logger.Infof("Marking %s as yanked in %s", y.Version, path)
+	index, err := UpdateIndex(ctx, b, path, func(current *manifest.Index) (*manifest.Index, error) {
+		payload := manifest.IndexPayload{
+			SchemaVersion: 1,
+			Repo:          vm.Payload.Repo,
+			App:           vm.Payload.App,
+		}
+		if current != nil {
+			if err := current.Verify(pubKey); err != nil {
+				return nil, fmt.Errorf("release index %s is not signed by this key: %v", path, err)
+			}
+			payload = current.Payload
+		}
+		if payload.Find(y.Version) == nil {
+			payload.AddRelease(vm)
+		}
+		e := payload.Find(y.Version)
+		if e.Yanked == nil {
+			e.Yanked = &manifest.Yank{At: time.Now().UTC()}
+		}
+		e.Yanked.Reason = y.Reason
+		payload.GeneratedAt = time.Now().UTC()
+		return manifest.SignIndex(payload, y.Seed, y.KeyID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update release index: %v", err)
+	}
+
+	objects, err := b.List(ctx, fmt.Sprintf("apps/%s/channels/", y.AppID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list channels: %v", err)
+	}
+	var rollbacks []ChannelRollback
+	for _, obj := range objects {
+		if !isChannelManifest(obj.Path) {
+			continue
+		}
+		cm, err := getManifest(ctx, b, obj.Path)
+		if err != nil {
+			return rollbacks, err
+		}
+		if cm == nil || cm.Payload.Latest.Version != y.Version {
+			continue
+		}
+		channel := cm.Payload.Channel
+		rb, err := rollBack(ctx, b, y, index, channel, obj.Path, pubKey)
+		if err != nil {
+			return rollbacks, fmt.Errorf("failed to roll back channel %s: %v", channel, err)
+		}
+		rollbacks = append(rollbacks, rb)
+	}
+	return rollbacks, nil
+}
+
+// rollBack points the channel manifest at path to the newest good release of
+// channel listed in the index that is older than the yanked one.
+func rollBack(ctx context.Context, b backend.Backend, y *Yanking, index *manifest.Index, channel, path string, pubKey []byte) (ChannelRollback, error) {
+	rb := ChannelRollback{Channel: channel}
+	var previous *manifest.Manifest
+	for _, e := range index.Payload.Releases {
+		if e.Yanked != nil || !slices.Contains(e.Channels, channel) {
+			continue
+		}
+		if c, err := semver.Compare(e.Version, y.Version); err != nil || c >= 0 {
+			continue
+		}
+		vm, err := getVerifiedRelease(ctx, b, &Release{RepoID: y.RepoID, AppID: y.AppID, Version: e.Version}, pubKey)
+		if err != nil {
+			logger.Warnf("Not rolling %s back to %s: %v", channel, e.Version, err)
+			continue
+		}
+		previous = vm
+		break
+	}
+	if previous == nil {
+		logger.Warnf("Channel %s has no other good release to roll back to", channel)
+		return rb, nil
+	}
+
+	if err := checkCosignatures(previous, pubKey, previous.Payload.Latest.Version, channel, y.Force); err != nil {
+		return rb, err
+	}
+	logger.Infof("Rolling channel %s back to %s", channel, previous.Payload.Latest.Version)
+	_, err := UpdateManifest(ctx, b, path, func(current *manifest.Manifest) (*manifest.Manifest, error) {
+		if current != nil && current.Payload.Latest.Version != y.Version {
+			// Moved on concurrently; keep it.
+			return current, nil
+		}
+		return channelManifest(previous, channel, y.TTL, y.Seed, y.KeyID)
+	})
+	if err != nil {
+		return rb, err
+	}
+	rb.Version = previous.Payload.Latest.Version
+	return rb, nil
+}
+
+// channelManifest re-signs the version manifest vm for channel. Its
+// generation time is now: the channel moves to an older release on a
+// rollback or promotion, but the manifest must still be newer than anything
+// the channel served before, or clients protected against rollbacks would
+// refuse it.
+// Cosignatures of vm are not carried over; callers check for them with
+// checkCosignatures first.
+func channelManifest(vm *manifest.Manifest, channel string, ttl time.Duration, seed, keyID string) (*manifest.Manifest, error) {
+	payload := vm.Payload
+	payload.Channel = channel
+	payload.GeneratedAt = time.Now().UTC()
+	payload.ExpiresAt = time.Time{}
+	if ttl > 0 {
+		payload.ExpiresAt = payload.GeneratedAt.Add(ttl)
+	}
+	return manifest.SignManifest(payload, seed, keyID)
+}
+
+// checkCosignatures refuses to re-sign the cosigned manifest m of version for
+// channel unless force is set: the result would carry only the repository
+// signature, which clients requiring cosignatures refuse until the cosigners
+// sign it again.
+func checkCosignatures(m *manifest.Manifest, pubKey []byte, version, channel string, force bool) error {
+	if !m.HasCosignatures(pubKey) {
+		return nil
+	}
+	if !force {
+		return fmt.Errorf("version %s is cosigned and re-signing it for channel %s would drop the cosignatures. Use --force to proceed anyway and have the cosigners sign it again", version, channel)
+	}
+	logger.Warnf("Cosignatures of version %s are not carried over; cosigners must sign the %s channel manifest again", version, channel)
+	return nil
+}
+
+// isChannelManifest reports whether path has the form apps/<app>/channels/<channel>.json.
+func isChannelManifest(path string) bool {
+	parts := strings.Split(path, "/")
+	return len(parts) == 4 && parts[0] == "apps" && parts[2] == "channels" && strings.HasSuffix(parts[3], ".json")
+}
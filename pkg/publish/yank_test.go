@@ -0,0 +1,117 @@
+package publish
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+func TestYank(t *testing.T) {
+	ctx := context.Background()
+	b := backend.NewFileBackend(t.TempDir())
+	for _, r := range []*Release{testRelease(t, "1.4.0"), testRelease(t, "1.4.1")} {
+		if _, err := Publish(ctx, b, r); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+	before, err := getManifest(ctx, b, "apps/app1/channels/stable.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	beta := testRelease(t, "1.5.0")
+	beta.Channel = "beta"
+	if _, err := Publish(ctx, b, beta); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	rollbacks, err := Yank(ctx, b, &Yanking{AppID: "app1", Version: "1.4.1", Reason: "crashes on start", Seed: testSeed, KeyID: "k"})
+	if err != nil {
+		t.Fatalf("Yank failed: %v", err)
+	}
+	if len(rollbacks) != 1 || rollbacks[0] != (ChannelRollback{Channel: "stable", Version: "1.4.0"}) {
+		t.Errorf("Expected stable to roll back to 1.4.0, got %+v", rollbacks)
+	}
+
+	pubKey, _ := sign.SeedToPubKey(testSeed)
+	stable, err := getManifest(ctx, b, "apps/app1/channels/stable.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stable.Verify(pubKey); err != nil {
+		t.Errorf("Expected signed channel manifest, got %v", err)
+	}
+	if stable.Payload.Latest.Version != "1.4.0" || stable.Payload.Channel != "stable" {
+		t.Errorf("Expected stable at 1.4.0, got %s %s", stable.Payload.Channel, stable.Payload.Latest.Version)
+	}
+	if !stable.Payload.GeneratedAt.After(before.Payload.GeneratedAt) {
+		t.Error("Expected the rolled back manifest to be newer than the yanked one")
+	}
+	if m, _ := getManifest(ctx, b, "apps/app1/channels/beta.json"); m.Payload.Latest.Version != "1.5.0" {
+		t.Errorf("Expected beta untouched, got %s", m.Payload.Latest.Version)
+	}
+
+	index, err := getDocument[manifest.Index](ctx, b, manifest.IndexPath("app1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Verify(pubKey); err != nil {
+		t.Errorf("Expected signed index, got %v", err)
+	}
+	if y := index.Yanked("1.4.1"); y == nil || y.Reason != "crashes on start" {
+		t.Errorf("Expected 1.4.1 yanked, got %+v", y)
+	}
+	if index.Yanked("1.4.0") != nil {
+		t.Error("Expected 1.4.0 not yanked")
+	}
+
+	if _, err := Publish(ctx, b, testRelease(t, "1.4.1")); err == nil || !strings.Contains(err.Error(), "yanked") {
+		t.Errorf("Expected republishing a yanked version to fail, got %v", err)
+	}
+	p := &Promotion{AppID: "app1", Version: "1.4.1", From: "stable", To: "beta", Seed: testSeed}
+	if _, err := Promote(ctx, b, p); err == nil || !strings.Contains(err.Error(), "yanked") {
+		t.Errorf("Expected promoting a yanked version to fail, got %v", err)
+	}
+}
+
+func TestYankSkipsNewerReleases(t *testing.T) {
+	ctx := context.Background()
+	b := backend.NewFileBackend(t.TempDir())
+	for _, v := range []string{"1.4.0", "1.5.0", "1.4.1"} {
+		r := testRelease(t, v)
+		r.Force = true
+		if _, err := Publish(ctx, b, r); err != nil {
+			t.Fatalf("Publish %s failed: %v", v, err)
+		}
+	}
+
+	rollbacks, err := Yank(ctx, b, &Yanking{AppID: "app1", Version: "1.4.1", Seed: testSeed, KeyID: "k"})
+	if err != nil {
+		t.Fatalf("Yank failed: %v", err)
+	}
+	if len(rollbacks) != 1 || rollbacks[0] != (ChannelRollback{Channel: "stable", Version: "1.4.0"}) {
+		t.Errorf("Expected stable to roll back to 1.4.0, not forward to 1.5.0, got %+v", rollbacks)
+	}
+}
+
+func TestYankWithoutPreviousRelease(t *testing.T) {
+	ctx := context.Background()
+	b := backend.NewFileBackend(t.TempDir())
+	if _, err := Publish(ctx, b, testRelease(t, "1.0.0")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	rollbacks, err := Yank(ctx, b, &Yanking{AppID: "app1", Version: "1.0.0", Seed: testSeed, KeyID: "k"})
+	if err != nil {
+		t.Fatalf("Yank failed: %v", err)
+	}
+	if len(rollbacks) != 1 || rollbacks[0].Version != "" {
+		t.Errorf("Expected stable without a release to roll back to, got %+v", rollbacks)
+	}
+	if _, err := Yank(ctx, b, &Yanking{AppID: "app1", Version: "2.0.0", Seed: testSeed, KeyID: "k"}); err == nil {
+		t.Error("Expected error yanking an unknown version")
+	}
+}
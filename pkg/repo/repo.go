@@ -15,6 +15,7 @@ var logger = logging.Component("pkg/repo")
 type RepoConfig struct {
 	RepoID       string
 	BaseURL      string
+	Backend      string
 	PubkeyPath   string
 	PubkeySha256 string
 }
@@ -29,6 +30,7 @@ func LoadRepoConfig(configDir, repoID string) (*RepoConfig, error) {
 	return &RepoConfig{
 		RepoID:       cfg.Get("ITRUST_REPO_ID", repoID),
 		BaseURL:      cfg.Get("ITRUST_BASE_URL", ""),
+		Backend:      cfg.Get("ITRUST_BACKEND", ""),
 		PubkeyPath:   cfg.Get("ITRUST_REPO_PUBKEY_PATH", "repo/public-keys/ed25519.pub"),
 		PubkeySha256: cfg.Get("ITRUST_REPO_PUBKEY_SHA256", ""),
 	}, nil
@@ -40,10 +42,7 @@ func SaveRepoConfig(configDir string, rc *RepoConfig) error {
 		return err
 	}
 
-	content := fmt.Sprintf("ITRUST_REPO_ID=%s\nITRUST_BASE_URL=%s\nITRUST_REPO_PUBKEY_PATH=%s\nITRUST_REPO_PUBKEY_SHA256=%s\n",
-		rc.RepoID, rc.BaseURL, rc.PubkeyPath, rc.PubkeySha256)
-
-	return os.WriteFile(path, []byte(content), 0600)
+	return os.WriteFile(path, []byte(ToEnvSnippet(rc)), 0600)
 }
 
 func GetRepoConfigPath(configDir, repoID string) string {
@@ -54,6 +53,9 @@ func ToEnvSnippet(rc *RepoConfig) string {
 	var sb strings.Builder
 	sb.WriteString(fmt.Sprintf("ITRUST_REPO_ID=%s\n", rc.RepoID))
 	sb.WriteString(fmt.Sprintf("ITRUST_BASE_URL=%s\n", rc.BaseURL))
+	if rc.Backend != "" {
+		sb.WriteString(fmt.Sprintf("ITRUST_BACKEND=%s\n", rc.Backend))
+	}
 	sb.WriteString(fmt.Sprintf("ITRUST_REPO_PUBKEY_PATH=%s\n", rc.PubkeyPath))
 	sb.WriteString(fmt.Sprintf("ITRUST_REPO_PUBKEY_SHA256=%s\n", rc.PubkeySha256))
 	return sb.String()
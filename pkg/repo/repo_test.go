@@ -15,6 +15,7 @@ func TestRepoConfig(t *testing.T) {
 	rc := &RepoConfig{
 		RepoID:       "test-repo",
 		BaseURL:      "https://nexus.example.com",
+		Backend:      "nexus",
 		PubkeyPath:   "keys/ed25519.pub",
 		PubkeySha256: "abcdef1234567890",
 	}
@@ -34,7 +35,7 @@ func TestRepoConfig(t *testing.T) {
 		t.Fatalf("LoadRepoConfig failed: %v", err)
 	}
 
-	if loaded.RepoID != rc.RepoID || loaded.BaseURL != rc.BaseURL || loaded.PubkeyPath != rc.PubkeyPath || loaded.PubkeySha256 != rc.PubkeySha256 {
+	if loaded.RepoID != rc.RepoID || loaded.BaseURL != rc.BaseURL || loaded.Backend != rc.Backend || loaded.PubkeyPath != rc.PubkeyPath || loaded.PubkeySha256 != rc.PubkeySha256 {
 		t.Errorf("Loaded config mismatch: %+v vs %+v", loaded, rc)
 	}
 }
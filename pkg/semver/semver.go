@@ -0,0 +1,113 @@
+// Package semver compares release versions by semantic versioning precedence.
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Compare returns -1, 0 or +1 depending on whether version a is lower than,
+// equal to or higher than b. A leading "v" and build metadata ("+...") are
+// ignored. The version core may have any number of numeric components, with
+// missing ones treated as zero (1.2 == 1.2.0), so date based versions such as
+// 2024.1.15 compare as expected. Pre-releases (1.0.0-rc.1) sort before the release.
+func Compare(a, b string) (int, error) {
+	va, err := parse(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parse(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < len(va.core) || i < len(vb.core); i++ {
+		var x, y uint64
+		if i < len(va.core) {
+			x = va.core[i]
+		}
+		if i < len(vb.core) {
+			y = vb.core[i]
+		}
+		if x != y {
+			return cmp(x < y), nil
+		}
+	}
+
+	switch {
+	case len(va.pre) == 0 && len(vb.pre) == 0:
+		return 0, nil
+	case len(va.pre) == 0:
+		return 1, nil
+	case len(vb.pre) == 0:
+		return -1, nil
+	}
+	for i := 0; i < len(va.pre) && i < len(vb.pre); i++ {
+		if c := comparePre(va.pre[i], vb.pre[i]); c != 0 {
+			return c, nil
+		}
+	}
+	switch {
+	case len(va.pre) < len(vb.pre):
+		return -1, nil
+	case len(va.pre) > len(vb.pre):
+		return 1, nil
+	}
+	return 0, nil
+}
+
+type version struct {
+	core []uint64
+	pre  []string
+}
+
+func parse(s string) (version, error) {
+	v := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	v, _, _ = strings.Cut(v, "+")
+	core, pre, hasPre := strings.Cut(v, "-")
+
+	var out version
+	for _, part := range strings.Split(core, ".") {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return version{}, fmt.Errorf("invalid version %q", s)
+		}
+		out.core = append(out.core, n)
+	}
+	if hasPre {
+		out.pre = strings.Split(pre, ".")
+		for _, id := range out.pre {
+			if id == "" {
+				return version{}, fmt.Errorf("invalid version %q", s)
+			}
+		}
+	}
+	return out, nil
+}
+
+// comparePre compares pre-release identifiers: numeric ones numerically and
+// below alphanumeric ones, which compare in ASCII order.
+func comparePre(a, b string) int {
+	na, errA := strconv.ParseUint(a, 10, 64)
+	nb, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		if na == nb {
+			return 0
+		}
+		return cmp(na < nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func cmp(less bool) int {
+	if less {
+		return -1
+	}
+	return 1
+}
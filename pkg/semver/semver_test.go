@@ -0,0 +1,40 @@
+package semver
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2", "1.2.0", 0},
+		{"1.2.3+build.5", "1.2.3", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"2.0.0", "1.99.99", 1},
+		{"2024.1.15", "2023.12.31", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-rc.1", "1.0.0-beta", 1},
+	}
+	for _, tt := range tests {
+		got, err := Compare(tt.a, tt.b)
+		if err != nil {
+			t.Errorf("Compare(%q, %q) failed: %v", tt.a, tt.b, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("Compare(%q, %q): expected %d, got %d", tt.a, tt.b, tt.expected, got)
+		}
+	}
+
+	for _, bad := range []string{"", "latest", "1.x", "1.0.0-"} {
+		if _, err := Compare(bad, "1.0.0"); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package trust
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+// RevokedKey is an entry of the revocation list.
+type RevokedKey struct {
+	Fingerprint string    `json:"fingerprint"`
+	KeyID       string    `json:"keyId,omitempty"`
+	RevokedAt   time.Time `json:"revokedAt"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+type RevocationPayload struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	RepoID        string       `json:"repoId"`
+	GeneratedAt   time.Time    `json:"generatedAt"`
+	Keys          []RevokedKey `json:"keys"`
+}
+
+// RevocationList names the keys that must no longer be trusted, whatever they
+// signed. It is signed by the current repository key.
+type RevocationList struct {
+	Payload   RevocationPayload  `json:"payload"`
+	Signature manifest.Signature `json:"signature"`
+}
+
+// RevocationPath returns the location of the revocation list.
+func RevocationPath(pubkeyPath string) string {
+	return path.Join(path.Dir(pubkeyPath), "revoked.json")
+}
+
+// Verify checks that the list was signed by pubKey.
+func (l *RevocationList) Verify(pubKey []byte) error {
+	return manifest.VerifyPayload(l.Payload, l.Signature, pubKey)
+}
+
+// Lookup returns the entry revoking the key with the given fingerprint, or
+// nil. A nil list revokes nothing.
+func (l *RevocationList) Lookup(fingerprint string) *RevokedKey {
+	if l == nil {
+		return nil
+	}
+	for i, k := range l.Payload.Keys {
+		if k.Fingerprint == fingerprint {
+			return &l.Payload.Keys[i]
+		}
+	}
+	return nil
+}
+
+// GeneratedAt returns when the list was generated, the zero time for a nil
+// list.
+func (l *RevocationList) GeneratedAt() time.Time {
+	if l == nil {
+		return time.Time{}
+	}
+	return l.Payload.GeneratedAt
+}
+
+// FetchRevocations returns the revocation list of the repository, verified
+// against repoKey, the current repository key. It returns nil if the
+// repository has not published one; clients must then check that they have
+// not seen a list before (see install.State.CheckRevocations).
+func FetchRevocations(ctx context.Context, b backend.Backend, repoID, pubkeyPath string, repoKey []byte) (*RevocationList, error) {
+	p := RevocationPath(pubkeyPath)
+	exists, err := b.Exists(ctx, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check revocation list: %v", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	data, err := fetch(ctx, b, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revocation list: %v", err)
+	}
+	var l RevocationList
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("failed to decode revocation list: %v", err)
+	}
+	if err := l.Verify(repoKey); err != nil {
+		return nil, fmt.Errorf("revocation list verification failed: %v", err)
+	}
+	if repoID != "" && l.Payload.RepoID != repoID {
+		return nil, fmt.Errorf("revocation list belongs to repository %q, expected %q", l.Payload.RepoID, repoID)
+	}
+	return &l, nil
+}
+
+// PublishRevocation adds key to the revocation list and uploads it signed
+// with seedB64, which must be the seed of the current repository key. The
+// current key itself cannot be revoked; rotate it first.
+func PublishRevocation(ctx context.Context, b backend.Backend, repoID, pubkeyPath, seedB64, keyID string, key RevokedKey) (*RevocationList, error) {
+	current, err := fetch(ctx, b, pubkeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repository public key: %v", err)
+	}
+	signingKey, err := sign.SeedToPubKey(seedB64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive public key: %v", err)
+	}
+	if !bytes.Equal(signingKey, current) {
+		return nil, fmt.Errorf("signing seed does not match the current repository key %s", sign.SHA256(current))
+	}
+	if key.Fingerprint == sign.SHA256(current) {
+		return nil, fmt.Errorf("cannot revoke the current repository key %s, rotate it first", key.Fingerprint)
+	}
+
+	l, err := FetchRevocations(ctx, b, repoID, pubkeyPath, current)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil {
+		l = &RevocationList{Payload: RevocationPayload{SchemaVersion: 1, RepoID: repoID}}
+	}
+	if existing := l.Lookup(key.Fingerprint); existing != nil {
+		*existing = key
+	} else {
+		l.Payload.Keys = append(l.Payload.Keys, key)
+	}
+	if err := putRevocations(ctx, b, pubkeyPath, l, seedB64, keyID); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// resignRevocations re-signs an existing revocation list signed by oldPubKey
+// with newSeedB64 after a key rotation. A list already signed by the new key
+// is left alone.
+func resignRevocations(ctx context.Context, b backend.Backend, repoID, pubkeyPath string, oldPubKey []byte, newSeedB64, keyID string) error {
+	newPubKey, err := sign.SeedToPubKey(newSeedB64)
+	if err != nil {
+		return fmt.Errorf("failed to derive public key: %v", err)
+	}
+	if _, err := FetchRevocations(ctx, b, repoID, pubkeyPath, newPubKey); err == nil {
+		return nil
+	}
+	l, err := FetchRevocations(ctx, b, repoID, pubkeyPath, oldPubKey)
+	if err != nil || l == nil {
+		return err
+	}
+	return putRevocations(ctx, b, pubkeyPath, l, newSeedB64, keyID)
+}
+
+func putRevocations(ctx context.Context, b backend.Backend, pubkeyPath string, l *RevocationList, seedB64, keyID string) error {
+	l.Payload.GeneratedAt = time.Now().UTC()
+	sig, err := manifest.SignPayload(l.Payload, seedB64, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to sign revocation list: %v", err)
+	}
+	l.Signature = *sig
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	p := RevocationPath(pubkeyPath)
+	logger.Infof("Uploading %s", p)
+	open := func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	if err := b.Put(ctx, p, open, "application/json"); err != nil {
+		return fmt.Errorf("failed to upload %s: %v", p, err)
+	}
+	return nil
+}
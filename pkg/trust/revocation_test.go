@@ -0,0 +1,71 @@
+package trust
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+func TestRevocationList(t *testing.T) {
+	ctx := context.Background()
+	seedA, seedB, seedC := testSeed(1), testSeed(2), testSeed(3)
+	b, _ := newRepo(t, seedA)
+	keyA, _ := sign.SeedToPubKey(seedA)
+
+	l, err := FetchRevocations(ctx, b, "repo1", pubkeyPath, keyA)
+	if err != nil || l != nil {
+		t.Fatalf("Expected no revocation list, got %v, %v", l, err)
+	}
+	if l.Lookup(fingerprint(t, seedC)) != nil {
+		t.Error("Expected nil list to revoke nothing")
+	}
+
+	if _, err := PublishRevocation(ctx, b, "repo1", pubkeyPath, seedA, "k", RevokedKey{Fingerprint: fingerprint(t, seedA)}); err == nil {
+		t.Error("Expected error revoking the current key")
+	}
+	if _, err := PublishRevocation(ctx, b, "repo1", pubkeyPath, seedB, "k", RevokedKey{Fingerprint: fingerprint(t, seedC)}); err == nil {
+		t.Error("Expected error signing with a key that is not the current one")
+	}
+	if _, err := PublishRevocation(ctx, b, "repo1", pubkeyPath, seedA, "k", RevokedKey{Fingerprint: fingerprint(t, seedC), Reason: "leaked", RevokedAt: time.Now().UTC()}); err != nil {
+		t.Fatalf("PublishRevocation failed: %v", err)
+	}
+
+	// Rotating re-signs the list with the new key, which can then revoke the old one.
+	if _, err := PublishRotation(ctx, b, "repo1", pubkeyPath, seedA, seedB, "k"); err != nil {
+		t.Fatalf("PublishRotation failed: %v", err)
+	}
+	if _, err := PublishRevocation(ctx, b, "repo1", pubkeyPath, seedB, "k", RevokedKey{Fingerprint: fingerprint(t, seedA)}); err != nil {
+		t.Fatalf("PublishRevocation failed: %v", err)
+	}
+
+	keyB, _ := sign.SeedToPubKey(seedB)
+	l, err = FetchRevocations(ctx, b, "repo1", pubkeyPath, keyB)
+	if err != nil {
+		t.Fatalf("FetchRevocations failed: %v", err)
+	}
+	if len(l.Payload.Keys) != 2 || l.Lookup(fingerprint(t, seedA)) == nil || l.Lookup(fingerprint(t, seedC)).Reason != "leaked" {
+		t.Errorf("Unexpected revocation list %+v", l.Payload)
+	}
+	if l.Lookup(fingerprint(t, seedB)) != nil {
+		t.Error("Expected current key not to be revoked")
+	}
+
+	if _, err := FetchRevocations(ctx, b, "repo1", pubkeyPath, keyA); err == nil {
+		t.Error("Expected list signed by another key to be refused")
+	}
+	if _, err := FetchRevocations(ctx, b, "repo2", pubkeyPath, keyB); err == nil || !strings.Contains(err.Error(), "repository") {
+		t.Errorf("Expected repository mismatch error, got %v", err)
+	}
+
+	// Dropping an entry invalidates the signature.
+	l.Payload.Keys = l.Payload.Keys[:1]
+	data, _ := json.Marshal(l)
+	put(t, b, RevocationPath(pubkeyPath), data)
+	if _, err := FetchRevocations(ctx, b, "repo1", pubkeyPath, keyB); err == nil {
+		t.Error("Expected tampered revocation list to be refused")
+	}
+}
@@ -0,0 +1,260 @@
+// Package trust lets clients follow rotations of the repository signing key.
+//
+// Next to the current key (repo/public-keys/ed25519.pub) a repository keeps
+// every key it has used under keys/<fingerprint>.pub and, for every retired
+// key, a transition statement under transitions/<fingerprint>.json naming its
+// successor, signed by the retired key. A client pinned to an old fingerprint
+// walks these statements until it reaches the current key.
+package trust
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/logging"
+	"github.com/alapierre/itrust-updater/pkg/manifest"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+var logger = logging.Component("pkg/trust")
+
+// maxTransitions bounds the chain a client is willing to follow.
+const maxTransitions = 32
+
+type TransitionPayload struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	RepoID        string    `json:"repoId"`
+	From          string    `json:"from"`
+	To            string    `json:"to"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// Transition states that the key with fingerprint From was replaced by To. It
+// is signed by the retiring key.
+type Transition struct {
+	Payload   TransitionPayload  `json:"payload"`
+	Signature manifest.Signature `json:"signature"`
+}
+
+// KeyPath returns the location of the key with the given fingerprint.
+func KeyPath(pubkeyPath, fingerprint string) string {
+	return path.Join(path.Dir(pubkeyPath), "keys", fingerprint+".pub")
+}
+
+// TransitionPath returns the location of the transition away from the key
+// with the given fingerprint.
+func TransitionPath(pubkeyPath, fromFingerprint string) string {
+	return path.Join(path.Dir(pubkeyPath), "transitions", fromFingerprint+".json")
+}
+
+// SignTransition signs a transition with the seed of the retiring key.
+func SignTransition(payload TransitionPayload, oldSeedB64, keyID string) (*Transition, error) {
+	oldPubKey, err := sign.SeedToPubKey(oldSeedB64)
+	if err != nil {
+		return nil, err
+	}
+	if payload.From != sign.SHA256(oldPubKey) {
+		return nil, fmt.Errorf("transition must be signed by the key it retires")
+	}
+	sig, err := manifest.SignPayload(payload, oldSeedB64, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return &Transition{Payload: payload, Signature: *sig}, nil
+}
+
+// Verify checks that the transition was signed by oldPubKey, the key it retires.
+func (t *Transition) Verify(oldPubKey []byte) error {
+	if t.Payload.From != sign.SHA256(oldPubKey) {
+		return fmt.Errorf("transition is not from key %s", sign.SHA256(oldPubKey))
+	}
+	return manifest.VerifyPayload(t.Payload, t.Signature, oldPubKey)
+}
+
+// ResolveKey returns the current repository key if it is trusted by a client
+// pinned to pinnedFingerprint: either it is the pinned key itself or it is
+// reached from it by an unbroken chain of valid transitions. Anything else is
+// refused.
+func ResolveKey(ctx context.Context, b backend.Backend, repoID, pubkeyPath, pinnedFingerprint string) ([]byte, error) {
+	current, err := fetch(ctx, b, pubkeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repository public key: %v", err)
+	}
+	currentFingerprint := sign.SHA256(current)
+	if currentFingerprint == pinnedFingerprint {
+		return current, nil
+	}
+
+	logger.Infof("Repository key changed to %s, following key transitions from %s", currentFingerprint, pinnedFingerprint)
+	key, err := FetchKey(ctx, b, pubkeyPath, pinnedFingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("public key verification failed: key %s differs from pinned %s and the pinned key is not published: %v", currentFingerprint, pinnedFingerprint, err)
+	}
+
+	fingerprint := pinnedFingerprint
+	seen := map[string]bool{fingerprint: true}
+	for i := 0; i < maxTransitions; i++ {
+		t, err := fetchTransition(ctx, b, pubkeyPath, fingerprint)
+		if err != nil {
+			return nil, fmt.Errorf("broken key transition chain at %s: %v", fingerprint, err)
+		}
+		if err := t.Verify(key); err != nil {
+			return nil, fmt.Errorf("broken key transition chain at %s: invalid transition: %v", fingerprint, err)
+		}
+		if repoID != "" && t.Payload.RepoID != repoID {
+			return nil, fmt.Errorf("broken key transition chain at %s: transition belongs to repository %q, expected %q", fingerprint, t.Payload.RepoID, repoID)
+		}
+		if seen[t.Payload.To] {
+			return nil, fmt.Errorf("broken key transition chain at %s: cycle back to %s", fingerprint, t.Payload.To)
+		}
+		seen[t.Payload.To] = true
+
+		key, err = FetchKey(ctx, b, pubkeyPath, t.Payload.To)
+		if err != nil {
+			return nil, fmt.Errorf("broken key transition chain at %s: %v", fingerprint, err)
+		}
+		logger.Infof("Key %s was replaced by %s on %s", fingerprint, t.Payload.To, t.Payload.CreatedAt.Format(time.RFC3339))
+		fingerprint = t.Payload.To
+		if fingerprint == currentFingerprint {
+			return current, nil
+		}
+	}
+	return nil, fmt.Errorf("key transition chain from %s is longer than %d steps", pinnedFingerprint, maxTransitions)
+}
+
+// FetchKey returns the published key with the given fingerprint, verified
+// against it.
+func FetchKey(ctx context.Context, b backend.Backend, pubkeyPath, fingerprint string) ([]byte, error) {
+	key, err := fetch(ctx, b, KeyPath(pubkeyPath, fingerprint))
+	if err != nil {
+		return nil, err
+	}
+	if err := sign.VerifyFingerprint(key, fingerprint); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func fetchTransition(ctx context.Context, b backend.Backend, pubkeyPath, fromFingerprint string) (*Transition, error) {
+	data, err := fetch(ctx, b, TransitionPath(pubkeyPath, fromFingerprint))
+	if err != nil {
+		return nil, err
+	}
+	var t Transition
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("failed to decode transition: %v", err)
+	}
+	return &t, nil
+}
+
+func fetch(ctx context.Context, b backend.Backend, p string) ([]byte, error) {
+	rc, err := b.Get(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// PublishKey publishes pubKey under keys/<fingerprint>.pub.
+func PublishKey(ctx context.Context, b backend.Backend, pubkeyPath string, pubKey []byte) error {
+	keyPath := KeyPath(pubkeyPath, sign.SHA256(pubKey))
+	open := func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(pubKey)), nil
+	}
+	if err := b.Put(ctx, keyPath, open, "application/octet-stream"); err != nil {
+		return fmt.Errorf("failed to upload %s: %v", keyPath, err)
+	}
+	return nil
+}
+
+// PublishRotation makes the key of newSeedB64 the current repository key in
+// one go: PublishTransition followed by ActivateKey.
+func PublishRotation(ctx context.Context, b backend.Backend, repoID, pubkeyPath, oldSeedB64, newSeedB64, keyID string) (*Transition, error) {
+	newPubKey, err := sign.SeedToPubKey(newSeedB64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive new public key: %v", err)
+	}
+	t, err := PublishTransition(ctx, b, repoID, pubkeyPath, oldSeedB64, newPubKey, keyID)
+	if err != nil {
+		return nil, err
+	}
+	oldPubKey, _ := sign.SeedToPubKey(oldSeedB64)
+	if err := ActivateKey(ctx, b, repoID, pubkeyPath, oldPubKey, newSeedB64, keyID); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// PublishTransition publishes the old key and newPubKey under keys/ and the
+// transition between them signed by the old key. The key at pubkeyPath is
+// left alone, so clients keep using the old key until ActivateKey; running
+// it again is harmless.
+func PublishTransition(ctx context.Context, b backend.Backend, repoID, pubkeyPath, oldSeedB64 string, newPubKey []byte, keyID string) (*Transition, error) {
+	oldPubKey, err := sign.SeedToPubKey(oldSeedB64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive old public key: %v", err)
+	}
+
+	t, err := SignTransition(TransitionPayload{
+		SchemaVersion: 1,
+		RepoID:        repoID,
+		From:          sign.SHA256(oldPubKey),
+		To:            sign.SHA256(newPubKey),
+		CreatedAt:     time.Now().UTC(),
+	}, oldSeedB64, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign key transition: %v", err)
+	}
+	tJSON, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	uploads := []struct {
+		path        string
+		data        []byte
+		contentType string
+	}{
+		{KeyPath(pubkeyPath, t.Payload.From), oldPubKey, "application/octet-stream"},
+		{KeyPath(pubkeyPath, t.Payload.To), newPubKey, "application/octet-stream"},
+		{TransitionPath(pubkeyPath, t.Payload.From), tJSON, "application/json"},
+	}
+	for _, u := range uploads {
+		logger.Infof("Uploading %s", u.path)
+		data := u.data
+		open := func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+		if err := b.Put(ctx, u.path, open, u.contentType); err != nil {
+			return nil, fmt.Errorf("failed to upload %s: %v", u.path, err)
+		}
+	}
+	return t, nil
+}
+
+// ActivateKey replaces the key at pubkeyPath with the key of newSeedB64 and
+// re-signs the revocation list, unless that was already done.
+func ActivateKey(ctx context.Context, b backend.Backend, repoID, pubkeyPath string, oldPubKey []byte, newSeedB64, keyID string) error {
+	newPubKey, err := sign.SeedToPubKey(newSeedB64)
+	if err != nil {
+		return fmt.Errorf("failed to derive new public key: %v", err)
+	}
+	logger.Infof("Uploading %s", pubkeyPath)
+	open := func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(newPubKey)), nil
+	}
+	if err := b.Put(ctx, pubkeyPath, open, "application/octet-stream"); err != nil {
+		return fmt.Errorf("failed to upload %s: %v", pubkeyPath, err)
+	}
+	if err := resignRevocations(ctx, b, repoID, pubkeyPath, oldPubKey, newSeedB64, keyID); err != nil {
+		return fmt.Errorf("failed to re-sign revocation list: %v", err)
+	}
+	return nil
+}
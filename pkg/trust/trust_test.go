@@ -0,0 +1,149 @@
+package trust
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alapierre/itrust-updater/pkg/backend"
+	"github.com/alapierre/itrust-updater/pkg/sign"
+)
+
+const pubkeyPath = "repo/public-keys/ed25519.pub"
+
+func testSeed(b byte) string {
+	seed := make([]byte, 32)
+	for i := range seed {
+		seed[i] = b
+	}
+	return base64.StdEncoding.EncodeToString(seed)
+}
+
+func fingerprint(t *testing.T, seed string) string {
+	t.Helper()
+	pub, err := sign.SeedToPubKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sign.SHA256(pub)
+}
+
+func newRepo(t *testing.T, seed string) (*backend.FileBackend, string) {
+	t.Helper()
+	root := t.TempDir()
+	b := backend.NewFileBackend(root)
+	pub, err := sign.SeedToPubKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, b, pubkeyPath, pub)
+	return b, root
+}
+
+func put(t *testing.T, b backend.Backend, path string, data []byte) {
+	t.Helper()
+	open := func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(string(data))), nil }
+	if err := b.Put(context.Background(), path, open, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveKeyFollowsChain(t *testing.T) {
+	ctx := context.Background()
+	seedA, seedB, seedC := testSeed(1), testSeed(2), testSeed(3)
+	b, _ := newRepo(t, seedA)
+
+	key, err := ResolveKey(ctx, b, "repo1", pubkeyPath, fingerprint(t, seedA))
+	if err != nil {
+		t.Fatalf("ResolveKey failed: %v", err)
+	}
+	if sign.SHA256(key) != fingerprint(t, seedA) {
+		t.Errorf("Expected key A")
+	}
+
+	if _, err := PublishRotation(ctx, b, "repo1", pubkeyPath, seedA, seedB, "k"); err != nil {
+		t.Fatalf("PublishRotation failed: %v", err)
+	}
+	if _, err := PublishRotation(ctx, b, "repo1", pubkeyPath, seedB, seedC, "k"); err != nil {
+		t.Fatalf("PublishRotation failed: %v", err)
+	}
+
+	for _, pinned := range []string{seedA, seedB, seedC} {
+		key, err := ResolveKey(ctx, b, "repo1", pubkeyPath, fingerprint(t, pinned))
+		if err != nil {
+			t.Fatalf("ResolveKey failed: %v", err)
+		}
+		if sign.SHA256(key) != fingerprint(t, seedC) {
+			t.Errorf("Expected key C, got %s", sign.SHA256(key))
+		}
+	}
+
+	if _, err := ResolveKey(ctx, b, "repo2", pubkeyPath, fingerprint(t, seedA)); err == nil || !strings.Contains(err.Error(), "repository") {
+		t.Errorf("Expected repository mismatch error, got %v", err)
+	}
+}
+
+func TestResolveKeyRefusesBrokenChains(t *testing.T) {
+	ctx := context.Background()
+	seedA, seedB, attacker := testSeed(1), testSeed(2), testSeed(9)
+	fpA := fingerprint(t, seedA)
+
+	t.Run("replaced key without transition", func(t *testing.T) {
+		b, _ := newRepo(t, seedA)
+		pub, _ := sign.SeedToPubKey(attacker)
+		put(t, b, pubkeyPath, pub)
+		if _, err := ResolveKey(ctx, b, "repo1", pubkeyPath, fpA); err == nil {
+			t.Error("Expected error for key without transition")
+		}
+	})
+
+	t.Run("transition signed by the new key", func(t *testing.T) {
+		b, _ := newRepo(t, seedA)
+		pubA, _ := sign.SeedToPubKey(seedA)
+		pubX, _ := sign.SeedToPubKey(attacker)
+		put(t, b, KeyPath(pubkeyPath, fpA), pubA)
+		put(t, b, KeyPath(pubkeyPath, sign.SHA256(pubX)), pubX)
+		put(t, b, pubkeyPath, pubX)
+
+		// Forge a statement claiming to come from A, signed by the attacker.
+		forged, err := SignTransition(TransitionPayload{RepoID: "repo1", From: sign.SHA256(pubX), To: sign.SHA256(pubX), CreatedAt: time.Now()}, attacker, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		forged.Payload.From = fpA
+		data, _ := json.Marshal(forged)
+		put(t, b, TransitionPath(pubkeyPath, fpA), data)
+
+		if _, err := ResolveKey(ctx, b, "repo1", pubkeyPath, fpA); err == nil || !strings.Contains(err.Error(), "invalid transition") {
+			t.Errorf("Expected invalid transition error, got %v", err)
+		}
+	})
+
+	t.Run("tampered successor key", func(t *testing.T) {
+		b, root := newRepo(t, seedA)
+		if _, err := PublishRotation(ctx, b, "repo1", pubkeyPath, seedA, seedB, "k"); err != nil {
+			t.Fatal(err)
+		}
+		pubX, _ := sign.SeedToPubKey(attacker)
+		keyFile := filepath.Join(root, filepath.FromSlash(KeyPath(pubkeyPath, fingerprint(t, seedB))))
+		if err := os.WriteFile(keyFile, pubX, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ResolveKey(ctx, b, "repo1", pubkeyPath, fpA); err == nil {
+			t.Error("Expected error for tampered successor key")
+		}
+	})
+}
+
+func TestSignTransitionRequiresRetiringKey(t *testing.T) {
+	_, err := SignTransition(TransitionPayload{From: fingerprint(t, testSeed(1)), To: fingerprint(t, testSeed(2))}, testSeed(2), "k")
+	if err == nil {
+		t.Error("Expected error when signing with a key other than the retiring one")
+	}
+}